@@ -0,0 +1,125 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oomparser
+
+import "time"
+
+// BurstEvent summarizes the OOMs in a container passed to Bursts within a
+// window of each other.
+type BurstEvent struct {
+	ContainerName string
+	// the number of OOMs in the burst, at least 1
+	Count int
+	// the TimeOfDeath of the first and last OOMs in the burst
+	First, Last time.Time
+	// the ProcessName killed most often in the burst, or first if there is a
+	// tie
+	ProcessName string
+	// the OOMs in the burst, in the order they were received
+	Ooms []*OomInstance
+}
+
+// a burst that is still collecting OOMs
+type pendingBurst struct {
+	event *BurstEvent
+	// when the burst's window closes, and the order it was opened in, which
+	// is the order the windows close in
+	deadline time.Time
+	seq      int
+	kills    map[string]int
+}
+
+func (self *pendingBurst) add(oomInstance *OomInstance) {
+	event := self.event
+	event.Count++
+	event.Ooms = append(event.Ooms, oomInstance)
+	if event.Count == 1 || oomInstance.TimeOfDeath.Before(event.First) {
+		event.First = oomInstance.TimeOfDeath
+	}
+	if oomInstance.TimeOfDeath.After(event.Last) {
+		event.Last = oomInstance.TimeOfDeath
+	}
+	self.kills[oomInstance.ProcessName]++
+	if self.kills[oomInstance.ProcessName] > self.kills[event.ProcessName] {
+		event.ProcessName = oomInstance.ProcessName
+	}
+}
+
+// Bursts coalesces the OOMs sent to in by ContainerName, sending a BurstEvent
+// for each container once window has passed since its first OOM was received,
+// with all the OOMs in it received until then. A container that OOMs once
+// gets a burst of one, so nothing is lost, but it is only sent after window.
+// The bursts still open when in is closed are sent, and then the channel
+// returned is closed.
+func Bursts(in <-chan *OomInstance, window time.Duration) <-chan *BurstEvent {
+	out := make(chan *BurstEvent)
+	go func() {
+		defer close(out)
+		pending := map[string]*pendingBurst{}
+		seq := 0
+		oldestPending := func() *pendingBurst {
+			var oldest *pendingBurst
+			for _, burst := range pending {
+				if oldest == nil || burst.seq < oldest.seq {
+					oldest = burst
+				}
+			}
+			return oldest
+		}
+		// sends the bursts whose windows have closed by now, or all of them
+		// if all is set, oldest first.
+		flush := func(now time.Time, all bool) {
+			for {
+				oldest := oldestPending()
+				if oldest == nil || (!all && oldest.deadline.After(now)) {
+					return
+				}
+				delete(pending, oldest.event.ContainerName)
+				out <- oldest.event
+			}
+		}
+		for {
+			// Wait for the oldest burst's window to close, if any.
+			var timeout <-chan time.Time
+			if oldest := oldestPending(); oldest != nil {
+				timeout = time.After(time.Until(oldest.deadline))
+			}
+			select {
+			case oomInstance, ok := <-in:
+				if !ok {
+					flush(time.Time{}, true)
+					return
+				}
+				now := time.Now()
+				flush(now, false)
+				burst, ok := pending[oomInstance.ContainerName]
+				if !ok {
+					burst = &pendingBurst{
+						event:    &BurstEvent{ContainerName: oomInstance.ContainerName},
+						deadline: now.Add(window),
+						seq:      seq,
+						kills:    map[string]int{},
+					}
+					pending[oomInstance.ContainerName] = burst
+					seq++
+				}
+				burst.add(oomInstance)
+			case now := <-timeout:
+				flush(now, false)
+			}
+		}
+	}()
+	return out
+}
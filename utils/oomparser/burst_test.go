@@ -0,0 +1,79 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oomparser
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBursts(t *testing.T) {
+	deathTime := time.Date(2016, time.January, 21, 22, 1, 49, 0, time.UTC)
+	kill := func(seconds int, processName string, containerName string) *OomInstance {
+		return &OomInstance{
+			ProcessName:   processName,
+			ContainerName: containerName,
+			TimeOfDeath:   deathTime.Add(time.Duration(seconds) * time.Second),
+		}
+	}
+	in := make(chan *OomInstance, 5)
+	in <- kill(0, "ruby", "/crashloop")
+	in <- kill(3, "sh", "/crashloop")
+	in <- kill(4, "evilprogram2", "/other")
+	in <- kill(6, "ruby", "/crashloop")
+	in <- kill(9, "ruby", "/crashloop")
+	close(in)
+
+	var bursts []*BurstEvent
+	for burst := range Bursts(in, time.Minute) {
+		bursts = append(bursts, burst)
+	}
+	if len(bursts) != 2 {
+		t.Fatalf("expected a burst for each container, got %v", bursts)
+	}
+	crashloop := bursts[0]
+	if crashloop.ContainerName != "/crashloop" || crashloop.Count != 4 || len(crashloop.Ooms) != 4 || crashloop.ProcessName != "ruby" {
+		t.Errorf("expected 4 kills of mostly ruby in /crashloop, got %+v", crashloop)
+	}
+	if !crashloop.First.Equal(deathTime) || !crashloop.Last.Equal(deathTime.Add(9*time.Second)) {
+		t.Errorf("expected the burst to last from %v to %v, got %v to %v", deathTime, deathTime.Add(9*time.Second), crashloop.First, crashloop.Last)
+	}
+	if other := bursts[1]; other.ContainerName != "/other" || other.Count != 1 || other.ProcessName != "evilprogram2" {
+		t.Errorf("expected a single kill of evilprogram2 in /other, got %+v", other)
+	}
+
+	// A burst is sent once its window closes, and the next OOM starts
+	// another.
+	in = make(chan *OomInstance)
+	out := Bursts(in, 20*time.Millisecond)
+	in <- kill(0, "ruby", "/crashloop")
+	in <- kill(1, "ruby", "/crashloop")
+	select {
+	case burst := <-out:
+		if burst.Count != 2 {
+			t.Errorf("expected a burst of 2 once the window closed, got %+v", burst)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("timeout happened before the burst's window closed")
+	}
+	in <- kill(2, "ruby", "/crashloop")
+	close(in)
+	if burst := <-out; burst == nil || burst.Count != 1 {
+		t.Errorf("expected a new burst of 1 after the window, got %+v", burst)
+	}
+	if burst, ok := <-out; ok {
+		t.Errorf("expected the channel to be closed, got %+v", burst)
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oomparser
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const defaultCgroupRoot = "/sys/fs/cgroup"
+
+// The memory cgroup hierarchies that a cgroup is looked up in, relative to the
+// cgroup root, and the file in each that has its memory usage.
+var memoryHierarchies = []struct {
+	dir, usageFile string
+}{
+	// cgroup v2's unified hierarchy
+	{"", "memory.current"},
+	// cgroup v1's memory hierarchy
+	{"memory", "memory.usage_in_bytes"},
+}
+
+// looks the oomInstance's VictimContainerName up in the cgroup hierarchies
+// mounted at root, setting whether it still exists and, if so, its current
+// memory usage.
+func resolveCgroup(root string, oomInstance *OomInstance) {
+	if oomInstance.VictimContainerName == "" {
+		return
+	}
+	for _, hierarchy := range memoryHierarchies {
+		dir := filepath.Join(root, hierarchy.dir, oomInstance.VictimContainerName)
+		// Every cgroup has a cgroup.procs, while a directory of the same
+		// name may be left in the other hierarchy.
+		if _, err := os.Stat(filepath.Join(dir, "cgroup.procs")); err != nil {
+			continue
+		}
+		oomInstance.VictimCgroupExists = true
+		// The root cgroup of cgroup v2 has no memory.current.
+		usage, err := ioutil.ReadFile(filepath.Join(dir, hierarchy.usageFile))
+		if err != nil {
+			return
+		}
+		if usageBytes, err := strconv.ParseUint(strings.TrimSpace(string(usage)), 10, 64); err == nil {
+			oomInstance.VictimCgroupUsageBytes = usageBytes
+		}
+		return
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oomparser
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeCgroupFile(t *testing.T, path string, contents string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("failed to create %q: %v", filepath.Dir(path), err)
+	}
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("failed to write %q: %v", path, err)
+	}
+}
+
+func TestResolveCgroup(t *testing.T) {
+	root, err := ioutil.TempDir("", "oomparser")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+	// cgroup v2
+	writeCgroupFile(t, filepath.Join(root, "cgroup.procs"), "1\n")
+	writeCgroupFile(t, filepath.Join(root, "mem3", "cgroup.procs"), "")
+	writeCgroupFile(t, filepath.Join(root, "mem3", "memory.current"), "134217728\n")
+	// cgroup v1
+	writeCgroupFile(t, filepath.Join(root, "memory", "kubepods", "cgroup.procs"), "")
+	writeCgroupFile(t, filepath.Join(root, "memory", "kubepods", "memory.usage_in_bytes"), "268435456\n")
+	// Left behind in the other hierarchy by a cgroup that is gone.
+	if err := os.MkdirAll(filepath.Join(root, "memory", "gone"), 0755); err != nil {
+		t.Fatalf("failed to create a cgroup directory: %v", err)
+	}
+
+	testCases := []struct {
+		victim string
+		exists bool
+		usage  uint64
+	}{
+		{"/mem3", true, 128 << 20},
+		{"/kubepods", true, 256 << 20},
+		{"/", true, 0},
+		{"/gone", false, 0},
+		{"/never", false, 0},
+		{"", false, 0},
+	}
+	for _, testCase := range testCases {
+		oomInstance := &OomInstance{VictimContainerName: testCase.victim}
+		resolveCgroup(root, oomInstance)
+		if oomInstance.VictimCgroupExists != testCase.exists || oomInstance.VictimCgroupUsageBytes != testCase.usage {
+			t.Errorf("%q: expected VictimCgroupExists %v with usage %d, got %v with %d", testCase.victim, testCase.exists, testCase.usage, oomInstance.VictimCgroupExists, oomInstance.VictimCgroupUsageBytes)
+		}
+	}
+
+	for _, resolve := range []bool{false, true} {
+		input := startLine + "\n" + containerLine + "\n" + endLine + "\n"
+		oomLog := NewFromReader(strings.NewReader(input))
+		oomLog.ResolveCgroup = resolve
+		oomLog.CgroupRoot = root
+		outStream := make(chan *OomInstance)
+		go oomLog.StreamOoms(outStream)
+		if oomInstance := <-outStream; oomInstance.VictimCgroupExists != resolve {
+			t.Errorf("expected VictimCgroupExists %v with ResolveCgroup %v, got %v", resolve, resolve, oomInstance.VictimCgroupExists)
+		}
+		oomLog.Close()
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oomparser
+
+import "time"
+
+// the fields that identify an OOM kill, see Dedup
+type dedupKey struct {
+	pid           int
+	processName   string
+	timeOfDeath   int64
+	containerName string
+}
+
+func newDedupKey(oomInstance *OomInstance) dedupKey {
+	return dedupKey{
+		pid:           oomInstance.Pid,
+		processName:   oomInstance.ProcessName,
+		timeOfDeath:   oomInstance.TimeOfDeath.Unix(),
+		containerName: oomInstance.ContainerName,
+	}
+}
+
+// Dedup passes on the OOMs sent to in, dropping any that was already passed on
+// within the last window of time, e.g. as both a replay of /dev/kmsg and a
+// syslog tail reported it. OOMs are the same if they have the same Pid,
+// ProcessName and ContainerName, and TimeOfDeath to the second. The channel
+// returned is closed once in is.
+func Dedup(in <-chan *OomInstance, window time.Duration) <-chan *OomInstance {
+	out := make(chan *OomInstance)
+	go func() {
+		defer close(out)
+		seen := map[dedupKey]time.Time{}
+		for oomInstance := range in {
+			now := time.Now()
+			for key, seenAt := range seen {
+				if now.Sub(seenAt) >= window {
+					delete(seen, key)
+				}
+			}
+			key := newDedupKey(oomInstance)
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = now
+			out <- oomInstance
+		}
+	}()
+	return out
+}
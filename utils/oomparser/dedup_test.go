@@ -0,0 +1,60 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oomparser
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDedup(t *testing.T) {
+	deathTime := time.Date(2016, time.January, 21, 22, 1, 49, 0, time.UTC)
+	oomInstance := &OomInstance{Pid: 19667, ProcessName: "evilprogram2", TimeOfDeath: deathTime, ContainerName: "/mem2"}
+	// The same kill read from /dev/kmsg, with the microseconds the syslog
+	// line lacks.
+	fromKmsg := *oomInstance
+	fromKmsg.TimeOfDeath = deathTime.Add(421192 * time.Microsecond)
+	other := *oomInstance
+	other.Pid = 19668
+
+	in := make(chan *OomInstance, 3)
+	in <- oomInstance
+	in <- &fromKmsg
+	in <- &other
+	close(in)
+	var deduped []*OomInstance
+	for oomInstance := range Dedup(in, time.Minute) {
+		deduped = append(deduped, oomInstance)
+	}
+	if len(deduped) != 2 || deduped[0] != oomInstance || deduped[1] != &other {
+		t.Errorf("expected the duplicate kill to be dropped, got %v", deduped)
+	}
+
+	in = make(chan *OomInstance)
+	out := Dedup(in, 10*time.Millisecond)
+	go func() {
+		in <- oomInstance
+		time.Sleep(20 * time.Millisecond)
+		in <- &fromKmsg
+		close(in)
+	}()
+	count := 0
+	for range out {
+		count++
+	}
+	if count != 2 {
+		t.Errorf("expected a kill repeated after the window to be passed on again, got %d events", count)
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oomparser
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// OomEvent is an event sent by StreamEvents: an *OomStart, an *OomInstance
+// or an *OomParseError.
+type OomEvent interface {
+	oomEvent()
+}
+
+// OomStart is sent when the kernel starts reporting an OOM, before the
+// process it kills is known. The OomInstance for it follows once the kill is
+// found.
+type OomStart struct {
+	// the process whose allocation invoked the OOM killer, if reported
+	InvokingProcess string
+	// the order of the allocation, or -1 if it was not reported
+	AllocationOrder int
+	// the GFP flags of the allocation, if reported
+	GfpMask string
+	// when the kernel logged the start, only known when reading /dev/kmsg
+	Time time.Time
+}
+
+// OomParseError is sent for a line that looked like part of an OOM but could
+// not be parsed. Parsing carries on after it.
+type OomParseError struct {
+	// the line, without its trailing newline
+	Line string
+	Err  error
+}
+
+func (self *OomParseError) Error() string {
+	return fmt.Sprintf("failed to parse %q: %v", self.Line, self.Err)
+}
+
+func (*OomStart) oomEvent()      {}
+func (*OomInstance) oomEvent()   {}
+func (*OomParseError) oomEvent() {}
+
+// StreamEvents behaves like StreamOomsContext, but sends an OomStart to
+// events as each OOM starts, then the OomInstance once it finishes, and an
+// OomParseError for each line that fails to parse. Overflow is ignored, so
+// parsing blocks until each event is received. If CloseStreamOnExit is set,
+// events is closed when the stream ends.
+func (self *OomParser) StreamEvents(ctx context.Context, events chan<- OomEvent) error {
+	return self.streamOoms(ctx, nil, nil, nil, events)
+}
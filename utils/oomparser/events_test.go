@@ -0,0 +1,108 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oomparser
+
+import (
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestStreamEvents(t *testing.T) {
+	badLimitLine := "Jan 21 22:01:49 localhost kernel: [62279.001234] memory: usage 980kB, limit 99999999999999999999999kB, failcnt 1"
+	dump := startLine + "\n" + containerLine + "\n" + endLine + "\n"
+	input := dump + startLine + "\n" + badLimitLine + "\n" + containerLine + "\n" + endLine + "\n"
+	oomLog := NewFromReader(strings.NewReader(input))
+	oomLog.CloseStreamOnExit = true
+	events := make(chan OomEvent)
+	go oomLog.StreamEvents(context.Background(), events)
+
+	var got []OomEvent
+	for event := range events {
+		got = append(got, event)
+	}
+	if len(got) != 5 {
+		t.Fatalf("expected 5 events, got %d: %v", len(got), got)
+	}
+	for _, i := range []int{0, 2} {
+		oomStart, ok := got[i].(*OomStart)
+		if !ok {
+			t.Fatalf("expected event %d to be an OomStart, got %T", i, got[i])
+		}
+		if oomStart.InvokingProcess != "ruby" || oomStart.AllocationOrder != 0 || oomStart.GfpMask != "0x201da" {
+			t.Errorf("expected the start of an OOM invoked by ruby, got %+v", oomStart)
+		}
+	}
+	parseErr, ok := got[3].(*OomParseError)
+	if !ok {
+		t.Fatalf("expected event 3 to be an OomParseError, got %T", got[3])
+	}
+	if parseErr.Line != badLimitLine || !strings.Contains(parseErr.Error(), badLimitLine) {
+		t.Errorf("expected the error to identify the line %q, got %v", badLimitLine, parseErr)
+	}
+	for _, i := range []int{1, 4} {
+		oomInstance, ok := got[i].(*OomInstance)
+		if !ok {
+			t.Fatalf("expected event %d to be an OomInstance, got %T", i, got[i])
+		}
+		if oomInstance.Pid != 19667 || oomInstance.ContainerName != "/mem2" {
+			t.Errorf("expected the OOM of pid 19667 in /mem2, got %+v", oomInstance)
+		}
+	}
+}
+
+func TestOnOom(t *testing.T) {
+	for _, closeStreamOnExit := range []bool{false, true} {
+		file, err := os.Open(groupKillLogFile)
+		if err != nil {
+			t.Fatalf("failed to open %s: %v", groupKillLogFile, err)
+		}
+		oomLog := NewFromReader(file)
+		oomLog.CloseStreamOnExit = closeStreamOnExit
+		var pids []int
+		err = oomLog.OnOom(context.Background(), func(oomInstance *OomInstance) {
+			pids = append(pids, oomInstance.Pid)
+		})
+		if err != io.EOF {
+			t.Errorf("expected OnOom to return %v at the end of %s, got %v", io.EOF, groupKillLogFile, err)
+		}
+		if expected := []int{7011, 7001, 7010, 7012}; !reflect.DeepEqual(pids, expected) {
+			t.Errorf("expected the handler to be called for pids %v, got %v", expected, pids)
+		}
+		file.Close()
+	}
+}
+
+func TestOnOomCancelled(t *testing.T) {
+	reader, writer := io.Pipe()
+	defer writer.Close()
+	go io.WriteString(writer, strings.Repeat(startLine+"\n"+containerLine+"\n"+endLine+"\n", 3))
+	oomLog := NewFromReader(reader)
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	err := oomLog.OnOom(ctx, func(*OomInstance) {
+		calls++
+		if calls == 2 {
+			cancel()
+		}
+	})
+	if err != context.Canceled || calls != 2 {
+		t.Errorf("expected OnOom to return %v after the handler cancelled it, got %v after %d calls", context.Canceled, err, calls)
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.18
+// +build go1.18
+
+package oomparser
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Kernel logs are not trusted to be well formed, so no lines may make the
+// parser panic. The input is taken to be lines of an OOM dump, as the details
+// of some, such as the task table, are parsed from several lines.
+func FuzzParseLine(f *testing.F) {
+	for _, logFile := range []string{containerLogFile, systemLogFile, kubepodsLogFile, cgroupv2LogFile, pagesLogFile, groupKillLogFile, kmsgLogFile} {
+		contents, err := ioutil.ReadFile(logFile)
+		if err != nil {
+			f.Fatalf("failed to read %s: %v", logFile, err)
+		}
+		for _, line := range strings.Split(string(contents), "\n") {
+			f.Add(line)
+		}
+	}
+	f.Add("Jan 21 22:01:49 localhost kernel: [62279.421192] Killed process 99999999999999999999 (evilprogram2)")
+	f.Add("oom-kill:constraint=CONSTRAINT_MEMCG,oom_memcg=/a,task_memcg=/a/b,task=x,pid=99999999999999999999,uid=99999999999999999999")
+	f.Add("[  pid  ]   uid  tgid total_vm      rss nr_ptes swapents oom_score_adj name\n[99999999999999999999]  1000 19667   1 99999999999999999999 1 0 -99999999999999999999 evilprogram2")
+	f.Add("[  pid  ] \n[19667]  1000 19667")
+	now := time.Date(2016, time.March, 1, 12, 0, 0, 0, time.UTC)
+	f.Fuzz(func(t *testing.T, lines string) {
+		for _, line := range strings.Split(lines, "\n") {
+			DefaultMatchers.getContainerName(line, &OomInstance{ContainerName: "/", VictimUID: -1})
+			DefaultMatchers.getProcessNamePid(line, now, &OomInstance{})
+		}
+		parser := NewFromReader(strings.NewReader(startLine + "\n" + lines + "\n" + endLine + "\n" + lines + "\n"))
+		parser.ParseCgroupStats = true
+		parser.TopConsumers = 3
+		nextLine, _ := parser.readAll()
+		parser.parseLines(nextLine, nil, func(*OomInstance) {}, func(string, error) {}, noopMetrics{})
+	})
+}
@@ -0,0 +1,63 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.23
+// +build go1.23
+
+package oomparser
+
+import (
+	"fmt"
+	"io"
+	"iter"
+	"time"
+)
+
+// Events returns an iterator over the OOMs in the kernel log lines read from
+// in, like ParseAll, but parsing them as the loop asks for them rather than
+// all up front. A line that fails to parse is yielded as an error with a nil
+// OOM, and the loop may carry on past it. Failing to read in is yielded as a
+// final error; reaching its end is not an error.
+func Events(in io.Reader) iter.Seq2[*OomInstance, error] {
+	return func(yield func(*OomInstance, error) bool) {
+		parser := NewFromReader(in)
+		readLine, readErr := parser.readAll()
+		stopped := false
+		nextLine := func() (string, time.Time, bool) {
+			if stopped {
+				return "", time.Time{}, false
+			}
+			return readLine()
+		}
+		seq := uint64(0)
+		emit := func(oomInstance *OomInstance) {
+			if stopped {
+				return
+			}
+			seq++
+			oomInstance.EventSeq = seq
+			stopped = !yield(oomInstance, nil)
+		}
+		reportError := func(line string, err error) {
+			if stopped {
+				return
+			}
+			stopped = !yield(nil, fmt.Errorf("failed to parse %q: %v", line, err))
+		}
+		parser.parseLines(nextLine, nil, emit, reportError, noopMetrics{})
+		if err := readErr(); !stopped && err != io.EOF {
+			yield(nil, err)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.23
+// +build go1.23
+
+package oomparser
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEvents(t *testing.T) {
+	file, err := os.Open(groupKillLogFile)
+	if err != nil {
+		t.Fatalf("failed to open %s: %v", groupKillLogFile, err)
+	}
+	defer file.Close()
+	var pids []int
+	for oomInstance, err := range Events(file) {
+		if err != nil {
+			t.Fatalf("unexpected error reading %s: %v", groupKillLogFile, err)
+		}
+		if oomInstance.EventSeq != uint64(len(pids)+1) {
+			t.Errorf("expected EventSeq %d, got %d", len(pids)+1, oomInstance.EventSeq)
+		}
+		pids = append(pids, oomInstance.Pid)
+	}
+	if expected := []int{7011, 7001, 7010, 7012}; !reflect.DeepEqual(pids, expected) {
+		t.Errorf("expected the OOMs of pids %v, got %v", expected, pids)
+	}
+}
+
+func TestEventsBreak(t *testing.T) {
+	dump := startLine + "\n" + containerLine + "\n" + endLine + "\n"
+	count := 0
+	for _, err := range Events(strings.NewReader(strings.Repeat(dump, 5))) {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		count++
+		if count == 2 {
+			break
+		}
+	}
+	if count != 2 {
+		t.Errorf("expected to stop after 2 OOMs, got %d", count)
+	}
+}
+
+func TestEventsErrors(t *testing.T) {
+	badLimitLine := "Jan 21 22:01:49 localhost kernel: [62279.001234] memory: usage 980kB, limit 99999999999999999999999kB, failcnt 1"
+	readErr := errors.New("disk on fire")
+	input := startLine + "\n" + badLimitLine + "\n" + containerLine + "\n" + endLine + "\n"
+	var oomInstances []*OomInstance
+	var errs []error
+	for oomInstance, err := range Events(&failingReader{strings.NewReader(input), readErr}) {
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		oomInstances = append(oomInstances, oomInstance)
+	}
+	if len(oomInstances) != 1 || oomInstances[0].Pid != 19667 {
+		t.Errorf("expected the OOM after the bad line, got %v", oomInstances)
+	}
+	if len(errs) != 2 || !strings.Contains(errs[0].Error(), badLimitLine) || errs[1] != readErr {
+		t.Errorf("expected an error for %q and then %v, got %v", badLimitLine, readErr, errs)
+	}
+}
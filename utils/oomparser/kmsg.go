@@ -0,0 +1,118 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oomparser
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// KmsgRecord is a record read from /dev/kmsg, such as
+// "6,1930,5864708440,-;memorymonster invoked oom-killer: ...".
+type KmsgRecord struct {
+	// the syslog facility shifted left by 3, ORed with the level
+	Priority int
+	// the record's sequence number, which increases by one per record
+	Seq uint64
+	// when the record was logged, on the kernel's monotonic clock
+	Timestamp time.Duration
+	// the message, with the kernel's "\xNN" escapes undone
+	Message string
+}
+
+// Facility returns the syslog facility of the record, 0 for the kernel's own.
+func (self KmsgRecord) Facility() int {
+	return self.Priority >> 3
+}
+
+// Level returns the level of the record, from 0 for KERN_EMERG to 7 for
+// KERN_DEBUG.
+func (self KmsgRecord) Level() int {
+	return self.Priority & 7
+}
+
+// ParseKmsgRecord parses a line read from /dev/kmsg. It returns an error for
+// lines that are not records, such as the continuation lines that follow some
+// records, which start with a space and carry key/value metadata.
+func ParseKmsgRecord(line string) (KmsgRecord, error) {
+	var record KmsgRecord
+	line = strings.TrimSuffix(line, "\n")
+	end := strings.IndexByte(line, ';')
+	if end < 0 {
+		return record, fmt.Errorf("no kmsg header in %q", line)
+	}
+	// This runs for every record, so the header is not split into a slice.
+	header := line[:end]
+	priority, header := nextKmsgHeaderField(header)
+	seq, header := nextKmsgHeaderField(header)
+	usec, _ := nextKmsgHeaderField(header)
+	var err error
+	if record.Priority, err = strconv.Atoi(priority); err != nil {
+		return record, fmt.Errorf("invalid kmsg priority in %q: %v", line, err)
+	}
+	if record.Seq, err = strconv.ParseUint(seq, 10, 64); err != nil {
+		return record, fmt.Errorf("invalid kmsg sequence number in %q: %v", line, err)
+	}
+	timestamp, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil {
+		return record, fmt.Errorf("invalid kmsg timestamp in %q: %v", line, err)
+	}
+	record.Timestamp = time.Duration(timestamp) * time.Microsecond
+	// The kernel escapes unprintable bytes and backslashes in /dev/kmsg
+	// messages. Backslashes are common in cgroup paths, as systemd escapes
+	// the "-" in unit names as "\x2d", e.g. "machine-qemu\x2d1.scope", which
+	// /dev/kmsg shows as "machine-qemu\x5cx2d1.scope".
+	record.Message = unescapeHex(line[end+1:])
+	return record, nil
+}
+
+// returns the first comma separated field of a kmsg header, and the fields
+// after it.
+func nextKmsgHeaderField(header string) (string, string) {
+	if i := strings.IndexByte(header, ','); i >= 0 {
+		return header[:i], header[i+1:]
+	}
+	return header, ""
+}
+
+// KmsgReader reads the records of /dev/kmsg, or of a copy of it such as the
+// output of "cat /dev/kmsg", skipping their continuation lines.
+type KmsgReader struct {
+	reader *bufio.Reader
+}
+
+// NewKmsgReader returns a KmsgReader that reads from in.
+func NewKmsgReader(in io.Reader) *KmsgReader {
+	return &KmsgReader{reader: bufio.NewReader(in)}
+}
+
+// ReadRecord returns the next record, or io.EOF once in ends. A line that is
+// not a record is returned as an error, after which reading can carry on.
+func (self *KmsgReader) ReadRecord() (KmsgRecord, error) {
+	for {
+		line, err := self.reader.ReadString('\n')
+		if line == "" && err != nil {
+			return KmsgRecord{}, err
+		}
+		if strings.HasPrefix(line, " ") {
+			continue
+		}
+		return ParseKmsgRecord(line)
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oomparser
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseKmsgRecord(t *testing.T) {
+	testCases := []struct {
+		line     string
+		expected KmsgRecord
+		level    int
+		facility int
+	}{
+		{
+			"6,1933,120000500,-;Task in /mem2 killed as a result of limit of /mem3\n",
+			KmsgRecord{Priority: 6, Seq: 1933, Timestamp: 120000500 * time.Microsecond, Message: "Task in /mem2 killed as a result of limit of /mem3"},
+			6, 0,
+		},
+		// Newer kernels add the caller after the flags.
+		{
+			"4,2210,9012345001,-,caller=T48213;stress invoked oom-killer: gfp_mask=0xcc0(GFP_KERNEL), order=0, oom_score_adj=984",
+			KmsgRecord{Priority: 4, Seq: 2210, Timestamp: 9012345001 * time.Microsecond, Message: "stress invoked oom-killer: gfp_mask=0xcc0(GFP_KERNEL), order=0, oom_score_adj=984"},
+			4, 0,
+		},
+		// Written from userspace by systemd, so not the kernel's facility.
+		{
+			"30,2300,9013000000,-;systemd[1]: stress.service: A process of this unit has been killed by the OOM killer.",
+			KmsgRecord{Priority: 30, Seq: 2300, Timestamp: 9013000000 * time.Microsecond, Message: "systemd[1]: stress.service: A process of this unit has been killed by the OOM killer."},
+			6, 3,
+		},
+		{
+			"6,1,100,-;Task in /machine.slice/machine-qemu\\x5cx2d1.scope killed as a result of limit of /machine.slice",
+			KmsgRecord{Priority: 6, Seq: 1, Timestamp: 100 * time.Microsecond, Message: "Task in /machine.slice/machine-qemu\\x2d1.scope killed as a result of limit of /machine.slice"},
+			6, 0,
+		},
+	}
+	for _, testCase := range testCases {
+		record, err := ParseKmsgRecord(testCase.line)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", testCase.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(record, testCase.expected) {
+			t.Errorf("%q: expected %+v, got %+v", testCase.line, testCase.expected, record)
+		}
+		if record.Level() != testCase.level || record.Facility() != testCase.facility {
+			t.Errorf("%q: expected level %d and facility %d, got %d and %d", testCase.line, testCase.level, testCase.facility, record.Level(), record.Facility())
+		}
+	}
+
+	for _, line := range []string{
+		"Task in /mem2 killed as a result of limit of /mem3",
+		" SUBSYSTEM=usb",
+		"x,1933,120000500,-;message",
+		"6,-1,120000500,-;message",
+		"6,1933;message",
+		"6,1933,soon,-;message",
+	} {
+		if record, err := ParseKmsgRecord(line); err == nil {
+			t.Errorf("%q: expected an error, got %+v", line, record)
+		}
+	}
+}
+
+func TestKmsgReader(t *testing.T) {
+	input := strings.Join([]string{
+		"6,10,100,-;usb 1-1: new high-speed USB device number 2 using xhci_hcd",
+		" SUBSYSTEM=usb",
+		" DEVICE=c189:1",
+		"not a record",
+		"3,11,200,-;Killed process 19667 (evilprogram2) total-vm:1460016kB",
+	}, "\n")
+	reader := NewKmsgReader(strings.NewReader(input))
+	record, err := reader.ReadRecord()
+	if err != nil || record.Seq != 10 {
+		t.Errorf("expected record 10, got %+v and %v", record, err)
+	}
+	if _, err := reader.ReadRecord(); err == nil {
+		t.Errorf("expected an error for a line that is not a record, skipping the continuation lines")
+	}
+	record, err = reader.ReadRecord()
+	if err != nil || record.Seq != 11 || record.Message != "Killed process 19667 (evilprogram2) total-vm:1460016kB" {
+		t.Errorf("expected record 11 without a trailing newline, got %+v and %v", record, err)
+	}
+	if _, err := reader.ReadRecord(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oomparser
+
+import (
+	"io"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+var (
+	// Android's low memory killer logs a line per kill, e.g. from the
+	// kernel driver:
+	// "lowmemorykiller: Killing 'com.android.chrome' (7609), adj 1000,"
+	// or from the lmkd daemon that replaced it:
+	// "lowmemorykiller: Kill 'com.android.chrome' (7609), uid 10085, oom_score_adj 900 to free 45000kB"
+	lmkdKillRegexp = regexp.MustCompile(`lowmemorykiller: Kill(?:ing)? '([^']+)' \(([0-9]+)\), (?:uid ([0-9]+), )?(?:adj|oom_adj|oom_score_adj) (-?[0-9]+)`)
+	// logcat dates lines as "03-04 09:12:01.123", with no year.
+	lmkdLogcatTimeRegexp = regexp.MustCompile(`^([0-9]{2}-[0-9]{2} [0-9]{2}:[0-9]{2}:[0-9]{2}\.[0-9]{3}) `)
+	lmkdSyslogTimeRegexp = regexp.MustCompile(`^([A-Z][a-z]{2} [ 0-9][0-9] [0-9]{2}:[0-9]{2}:[0-9]{2}) `)
+	lmkdIsoTimeRegexp    = regexp.MustCompile(`^([0-9]{4}-[0-9]{2}-[0-9]{2}T[0-9]{2}:[0-9]{2}:[0-9]{2}(?:[+-][0-9]{2}:?[0-9]{2}|Z)) `)
+)
+
+// NewFromLmkd returns an OomParser that reads the kills of Android's low memory
+// killer from in, such as the output of "logcat" or a kernel log. Only the
+// fields the low memory killer logs are set: Pid, ProcessName, TimeOfDeath,
+// OomScoreAdj, and VictimUID if it was logged. These kills are not caused by
+// a memory cgroup, so IsGlobal is always set.
+func NewFromLmkd(in io.Reader) *OomParser {
+	parser := NewFromReader(in)
+	parser.lmkd = true
+	return parser
+}
+
+// returns the kill logged on a low memory killer line, or nil if the line is
+// not one. The time of death is lineTime if it is set, as for /dev/kmsg
+// records, and otherwise the line's own date, in the year before now if it
+// would otherwise be in the future.
+func getLmkdKill(line string, lineTime time.Time, now time.Time) (*OomInstance, error) {
+	parsedLine := lmkdKillRegexp.FindStringSubmatch(line)
+	if parsedLine == nil {
+		return nil, nil
+	}
+	pid, err := strconv.Atoi(parsedLine[2])
+	if err != nil {
+		return nil, err
+	}
+	oomScoreAdj, err := strconv.Atoi(parsedLine[4])
+	if err != nil {
+		return nil, err
+	}
+	oomInstance := &OomInstance{
+		Pid:             pid,
+		ProcessName:     parsedLine[1],
+		TimeOfDeath:     lineTime,
+		ContainerName:   "/",
+		OomScoreAdj:     oomScoreAdj,
+		HasOomScoreAdj:  true,
+		AllocationOrder: -1,
+		VictimUID:       -1,
+		IsGlobal:        true,
+		VictimGlobalPid: pid,
+		LogLevel:        -1,
+	}
+	if parsedLine[3] != "" {
+		if oomInstance.VictimUID, err = strconv.Atoi(parsedLine[3]); err != nil {
+			return nil, err
+		}
+	}
+	if !lineTime.IsZero() {
+		return oomInstance, nil
+	}
+	var timestamp []string
+	if timestamp = lmkdLogcatTimeRegexp.FindStringSubmatch(line); timestamp != nil {
+		oomInstance.TimeOfDeath, err = parseYearlessTime("01-02 15:04:05.000", timestamp[1], now)
+	} else if timestamp = lmkdSyslogTimeRegexp.FindStringSubmatch(line); timestamp != nil {
+		oomInstance.TimeOfDeath, err = parseSyslogTime(timestamp[1], now)
+	} else if timestamp = lmkdIsoTimeRegexp.FindStringSubmatch(line); timestamp != nil {
+		oomInstance.TimeOfDeath, err = parseIsoTime(timestamp[1])
+	}
+	if err != nil {
+		return nil, err
+	}
+	return oomInstance, nil
+}
@@ -0,0 +1,60 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oomparser
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewFromLmkd(t *testing.T) {
+	input := strings.Join([]string{
+		"03-04 09:12:01.123  1203  1203 I lowmemorykiller: Kill 'com.android.chrome' (7609), uid 10085, oom_score_adj 900 to free 45000kB",
+		"03-04 09:12:01.130  1203  1203 I lowmemorykiller: Reclaimed 45000kB at oom_score_adj 900",
+		"Mar  4 09:12:02 localhost kernel: [ 3601.000001] lowmemorykiller: Killing 'com.example.app' (7700), adj 1000,",
+		"Mar  4 09:12:02 localhost kernel: [ 3601.000002]    to free 105700kB on behalf of 'kswapd0' (90) because",
+		// A kernel OOM is not reported by an lmkd parser.
+		startLine, containerLine, endLine,
+	}, "\n") + "\n"
+	oomLog := NewFromLmkd(strings.NewReader(input))
+	oomLog.CloseStreamOnExit = true
+	outStream := make(chan *OomInstance)
+	go oomLog.StreamOoms(outStream)
+	var oomInstances []*OomInstance
+	for oomInstance := range outStream {
+		oomInstances = append(oomInstances, oomInstance)
+	}
+	if len(oomInstances) != 2 {
+		t.Fatalf("expected two lmkd kills, got %v", oomInstances)
+	}
+
+	expectedTime, _ := parseYearlessTime("01-02 15:04:05.000", "03-04 09:12:01.123", time.Now())
+	chrome := oomInstances[0]
+	if chrome.Pid != 7609 || chrome.ProcessName != "com.android.chrome" || chrome.OomScoreAdj != 900 || !chrome.HasOomScoreAdj || chrome.VictimUID != 10085 || !chrome.IsGlobal {
+		t.Errorf("expected com.android.chrome (7609) of uid 10085 killed at adj 900, got %v", chrome)
+	}
+	if !chrome.TimeOfDeath.Equal(expectedTime) || chrome.TimeOfDeath.Nanosecond() != 123000000 {
+		t.Errorf("expected the logcat time %v, got %v", expectedTime, chrome.TimeOfDeath)
+	}
+
+	app := oomInstances[1]
+	if app.Pid != 7700 || app.ProcessName != "com.example.app" || app.OomScoreAdj != 1000 || app.VictimUID != -1 {
+		t.Errorf("expected com.example.app (7700) killed at adj 1000, got %v", app)
+	}
+	if app.TimeOfDeath.Month() != time.March || app.TimeOfDeath.Day() != 4 || app.TimeOfDeath.Second() != 2 {
+		t.Errorf("expected the syslog time, got %v", app.TimeOfDeath)
+	}
+}
@@ -0,0 +1,308 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oomparser
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// Rotated logs with these extensions are compressed and cannot be followed.
+var compressedLogExtensions = map[string]bool{
+	".gz":  true,
+	".bz2": true,
+	".xz":  true,
+	".zst": true,
+}
+
+// logFileReader follows a log file by name, like "tail -F". When it reaches
+// the end of the file it checks whether the file was rotated, in which case it
+// moves on to the start of the new file, or truncated, in which case it starts
+// again from the beginning. Reaching the end is reported as io.EOF, so it is
+// read with follow set.
+type logFileReader struct {
+	path string
+	// file is nil until path exists.
+	file   *os.File
+	offset int64
+	closed bool
+	lock   sync.Mutex
+	// logger returns where to warn that path does not exist yet, which is
+	// done once, on the first read.
+	logger        func() Logger
+	warnedMissing bool
+}
+
+// opens path, starting from its end if it already exists, so that only
+// messages logged from now on are read.
+func newLogFileReader(path string) (*logFileReader, error) {
+	if compressedLogExtensions[filepath.Ext(path)] {
+		return nil, fmt.Errorf("cannot follow compressed log file %q", path)
+	}
+	self := &logFileReader{path: path}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return self, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	self.file = file
+	self.offset = offset
+	return self, nil
+}
+
+func (self *logFileReader) Read(p []byte) (int, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.closed {
+		return 0, os.ErrClosed
+	}
+	if self.file == nil {
+		if err := self.reopen(); err != nil {
+			if err == io.EOF && !self.warnedMissing {
+				self.warnedMissing = true
+				self.logger().Warningf("log file %q does not exist yet, waiting for it to be created", self.path)
+			}
+			return 0, err
+		}
+	}
+	n, err := self.file.Read(p)
+	self.offset += int64(n)
+	if err == io.EOF {
+		if err := self.checkRotation(); err != nil {
+			return n, err
+		}
+	}
+	return n, err
+}
+
+// opens path from its start, reporting io.EOF if it does not exist yet.
+func (self *logFileReader) reopen() error {
+	file, err := os.Open(self.path)
+	if os.IsNotExist(err) {
+		return io.EOF
+	}
+	if err != nil {
+		return err
+	}
+	if self.file != nil {
+		self.file.Close()
+	}
+	self.file = file
+	self.offset = 0
+	return nil
+}
+
+// switches to the new file if path was rotated, and rewinds if the file was
+// truncated. Only called at the end of the file, so nothing is skipped.
+func (self *logFileReader) checkRotation() error {
+	info, err := os.Stat(self.path)
+	if os.IsNotExist(err) {
+		// Rotated, but not recreated yet.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	current, err := self.file.Stat()
+	if err != nil {
+		return err
+	}
+	if !os.SameFile(info, current) {
+		glog.V(4).Infof("log file %q was rotated, reopening it", self.path)
+		return self.reopen()
+	}
+	if info.Size() < self.offset {
+		glog.V(4).Infof("log file %q was truncated, reading it from the start", self.path)
+		if _, err := self.file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		self.offset = 0
+	}
+	return nil
+}
+
+func (self *logFileReader) Close() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.closed {
+		return nil
+	}
+	self.closed = true
+	if self.file == nil {
+		return nil
+	}
+	return self.file.Close()
+}
+
+// NewFromLogFile returns an OomParser that follows the kernel messages written
+// to the syslog file at path from now on, across rotation and truncation. path
+// need not exist yet.
+func NewFromLogFile(path string) (*OomParser, error) {
+	reader, err := newLogFileReader(path)
+	if err != nil {
+		return nil, err
+	}
+	parser := NewFromReader(reader)
+	parser.follow = true
+	reader.logger = parser.logger
+	return parser, nil
+}
+
+// NewFromLogDir returns an OomParser that reads the files in dir whose names
+// match glob, as for filepath.Match, one after another from the oldest to the
+// newest, e.g. to backfill OOMs from "messages*". Files compressed by gzip or
+// bzip2, with the extension ".gz" or ".bz2", are decompressed. The stream ends
+// once the newest file has been read; it is not followed.
+//
+// Files are read in the order of their modification times, as rotation does
+// not change them, with rotation numbers breaking ties, so "messages.2.gz" is
+// read before "messages.1". The OOMs are sent in the order they are read; the
+// dates in the files may still go backwards, as syslog dates have no year. The
+// files are all opened here, so rotating them while they are read does not
+// change what is read.
+func NewFromLogDir(dir string, glob string) (*OomParser, error) {
+	if _, err := filepath.Match(glob, ""); err != nil {
+		return nil, err
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var logFiles []os.FileInfo
+	for _, entry := range entries {
+		if matched, _ := filepath.Match(glob, entry.Name()); matched && entry.Mode().IsRegular() {
+			logFiles = append(logFiles, entry)
+		}
+	}
+	if len(logFiles) == 0 {
+		return nil, fmt.Errorf("no log files in %q match %q", dir, glob)
+	}
+	sort.SliceStable(logFiles, func(i, j int) bool {
+		if !logFiles[i].ModTime().Equal(logFiles[j].ModTime()) {
+			return logFiles[i].ModTime().Before(logFiles[j].ModTime())
+		}
+		if iIndex, jIndex := rotationIndex(logFiles[i].Name()), rotationIndex(logFiles[j].Name()); iIndex != jIndex {
+			return iIndex > jIndex
+		}
+		return logFiles[i].Name() < logFiles[j].Name()
+	})
+	reader := &logDirReader{atLineStart: true}
+	for _, logFile := range logFiles {
+		if err := reader.open(filepath.Join(dir, logFile.Name())); err != nil {
+			reader.Close()
+			return nil, err
+		}
+	}
+	glog.V(4).Infof("reading %d log files from %q", len(reader.files), dir)
+	return NewFromReader(reader), nil
+}
+
+// returns the number logrotate gives a rotated log, e.g. 2 for
+// "messages.2.gz", or 0 if it has none.
+func rotationIndex(name string) int {
+	if compressedLogExtensions[filepath.Ext(name)] {
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	index, err := strconv.Atoi(strings.TrimPrefix(filepath.Ext(name), "."))
+	if err != nil {
+		return 0
+	}
+	return index
+}
+
+// logDirReader reads a series of log files as one, making sure each ends in a
+// newline so that the last line of one is not joined to the first of the next.
+type logDirReader struct {
+	files   []*os.File
+	readers []io.Reader
+	// whether the last byte read from the current file was a newline
+	atLineStart bool
+}
+
+// opens path to be read after the files already opened, decompressing it if
+// it has the extension of a compressed file.
+func (self *logDirReader) open(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	self.files = append(self.files, file)
+	var reader io.Reader
+	switch filepath.Ext(path) {
+	case ".gz":
+		reader, err = gzip.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("failed to decompress %q: %v", path, err)
+		}
+	case ".bz2":
+		reader = bzip2.NewReader(file)
+	default:
+		if compressedLogExtensions[filepath.Ext(path)] {
+			return fmt.Errorf("cannot decompress log file %q", path)
+		}
+		reader = file
+	}
+	self.readers = append(self.readers, reader)
+	return nil
+}
+
+func (self *logDirReader) Read(p []byte) (int, error) {
+	for len(self.readers) > 0 {
+		n, err := self.readers[0].Read(p)
+		if n > 0 {
+			self.atLineStart = p[n-1] == '\n'
+		}
+		if err == io.EOF {
+			if self.atLineStart {
+				self.readers = self.readers[1:]
+			} else {
+				self.readers[0] = strings.NewReader("\n")
+			}
+			err = nil
+		}
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+	return 0, io.EOF
+}
+
+func (self *logDirReader) Close() error {
+	var err error
+	for _, file := range self.files {
+		if closeErr := file.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,264 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oomparser
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func appendToFile(t *testing.T, path string, contents string) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatalf("failed to open %q: %v", path, err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(contents); err != nil {
+		t.Fatalf("failed to write to %q: %v", path, err)
+	}
+}
+
+func expectOom(t *testing.T, outStream chan *OomInstance, pid int, when string) {
+	select {
+	case oomInstance := <-outStream:
+		if oomInstance.Pid != pid {
+			t.Errorf("%s: expected pid %d, got %d", when, pid, oomInstance.Pid)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("%s: timeout happened before oomInstance was found", when)
+	}
+}
+
+func TestNewFromLogFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oomparser")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	logFile := filepath.Join(dir, "messages")
+	dump := startLine + "\n" + containerLine + "\n" + endLine + "\n"
+	// Already in the file, so should not be reported.
+	appendToFile(t, logFile, "Jan 21 22:01:49 localhost kernel: [62279.421192] Killed process 1 (init)\n"+dump)
+
+	oomLog, err := NewFromLogFile(logFile)
+	if err != nil {
+		t.Fatalf("failed to follow %q: %v", logFile, err)
+	}
+	defer oomLog.Close()
+	outStream := make(chan *OomInstance)
+	go oomLog.StreamOoms(outStream)
+
+	appendToFile(t, logFile, dump)
+	expectOom(t, outStream, 19667, "after appending")
+
+	if err := os.Rename(logFile, logFile+".1"); err != nil {
+		t.Fatalf("failed to rotate %q: %v", logFile, err)
+	}
+	appendToFile(t, logFile, startLine+"\n"+containerLine+"\n")
+	appendToFile(t, logFile, "Jan 21 22:01:49 localhost kernel: [62279.421192] Killed process 2001 (rotated)\n")
+	expectOom(t, outStream, 2001, "after rotation")
+
+	if err := os.Truncate(logFile, 0); err != nil {
+		t.Fatalf("failed to truncate %q: %v", logFile, err)
+	}
+	appendToFile(t, logFile, startLine+"\n"+"Jan 21 22:01:49 localhost kernel: [62279.421192] Killed process 2002 (truncated)\n")
+	expectOom(t, outStream, 2002, "after truncation")
+}
+
+func TestNewFromLogFileNotYetCreated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oomparser")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	logFile := filepath.Join(dir, "messages")
+
+	oomLog, err := NewFromLogFile(logFile)
+	if err != nil {
+		t.Fatalf("expected to wait for %q to be created, got %v", logFile, err)
+	}
+	defer oomLog.Close()
+	logger := &captureLogger{}
+	oomLog.Logger = logger
+	outStream := make(chan *OomInstance)
+	go oomLog.StreamOoms(outStream)
+
+	// The warning is logged by the first read, before the file is created.
+	for deadline := time.Now().Add(time.Second); len(logger.loggedWarnings()) == 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	appendToFile(t, logFile, startLine+"\n"+containerLine+"\n"+endLine+"\n")
+	expectOom(t, outStream, 19667, "after creation")
+	if warnings := logger.loggedWarnings(); len(warnings) != 1 || !strings.Contains(warnings[0], "does not exist yet") {
+		t.Errorf("expected one warning that %q does not exist yet, got %q", logFile, warnings)
+	}
+}
+
+func TestNewFromLogFileCompressed(t *testing.T) {
+	if _, err := NewFromLogFile("/var/log/messages.1.gz"); err == nil {
+		t.Errorf("expected an error following a compressed log file")
+	}
+}
+
+// copies the fixture at from to to, gzipping it if to ends in ".gz", and
+// sets its modification time.
+func writeLogFile(t *testing.T, from string, to string, modTime time.Time) {
+	contents, err := ioutil.ReadFile(from)
+	if err != nil {
+		t.Fatalf("failed to read %q: %v", from, err)
+	}
+	file, err := os.Create(to)
+	if err != nil {
+		t.Fatalf("failed to create %q: %v", to, err)
+	}
+	var writer io.WriteCloser = file
+	if filepath.Ext(to) == ".gz" {
+		writer = gzip.NewWriter(file)
+	}
+	if _, err := writer.Write(contents); err != nil {
+		t.Fatalf("failed to write %q: %v", to, err)
+	}
+	if writer != file {
+		if err := writer.Close(); err != nil {
+			t.Fatalf("failed to compress %q: %v", to, err)
+		}
+	}
+	if err := file.Close(); err != nil {
+		t.Fatalf("failed to write %q: %v", to, err)
+	}
+	if err := os.Chtimes(to, modTime, modTime); err != nil {
+		t.Fatalf("failed to set the modification time of %q: %v", to, err)
+	}
+}
+
+func streamPids(t *testing.T, oomLog *OomParser) []int {
+	oomLog.CloseStreamOnExit = true
+	outStream := make(chan *OomInstance)
+	go oomLog.StreamOoms(outStream)
+	var pids []int
+	for oomInstance := range outStream {
+		pids = append(pids, oomInstance.Pid)
+	}
+	if err := oomLog.Err(); err != io.EOF {
+		t.Errorf("expected the stream to end with %v, got %v", io.EOF, err)
+	}
+	return pids
+}
+
+func TestNewFromLogDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oomparser")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	rotated := time.Date(2016, time.March, 14, 0, 0, 0, 0, time.UTC)
+	// Listed from the newest. The dates in the files are not in order.
+	writeLogFile(t, systemLogFile, filepath.Join(dir, "messages"), rotated.Add(3*time.Hour))
+	writeLogFile(t, kubepodsLogFile, filepath.Join(dir, "messages.1"), rotated.Add(2*time.Hour))
+	writeLogFile(t, containerLogFile, filepath.Join(dir, "messages.2.gz"), rotated.Add(time.Hour))
+	writeLogFile(t, pagesLogFile+".bz2", filepath.Join(dir, "messages.3.bz2"), rotated)
+	writeLogFile(t, cgroupv2LogFile, filepath.Join(dir, "kern.log"), rotated)
+
+	oomLog, err := NewFromLogDir(dir, "messages*")
+	if err != nil {
+		t.Fatalf("failed to read the logs in %q: %v", dir, err)
+	}
+	defer oomLog.Close()
+	// Rotating the logs once they are open changes nothing.
+	if err := os.Rename(filepath.Join(dir, "messages"), filepath.Join(dir, "messages.0")); err != nil {
+		t.Fatalf("failed to rotate the logs: %v", err)
+	}
+	writeLogFile(t, groupKillLogFile, filepath.Join(dir, "messages"), rotated.Add(4*time.Hour))
+	if err := os.Remove(filepath.Join(dir, "messages.2.gz")); err != nil {
+		t.Fatalf("failed to rotate the logs: %v", err)
+	}
+	if pids, expected := streamPids(t, oomLog), []int{23107, 13536, 30211, 1532}; !reflect.DeepEqual(pids, expected) {
+		t.Errorf("expected the OOMs of pids %v from the oldest log to the newest, got %v", expected, pids)
+	}
+}
+
+func TestNewFromLogDirRotationOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oomparser")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	// With the same modification times, as after copying the logs, the
+	// rotation numbers give the order.
+	rotated := time.Date(2016, time.March, 14, 0, 0, 0, 0, time.UTC)
+	writeLogFile(t, systemLogFile, filepath.Join(dir, "messages"), rotated)
+	writeLogFile(t, kubepodsLogFile, filepath.Join(dir, "messages.1"), rotated)
+	writeLogFile(t, containerLogFile, filepath.Join(dir, "messages.10.gz"), rotated)
+	writeLogFile(t, pagesLogFile, filepath.Join(dir, "messages.9.gz"), rotated)
+	oomLog, err := NewFromLogDir(dir, "messages*")
+	if err != nil {
+		t.Fatalf("failed to read the logs in %q: %v", dir, err)
+	}
+	defer oomLog.Close()
+	if pids, expected := streamPids(t, oomLog), []int{13536, 23107, 30211, 1532}; !reflect.DeepEqual(pids, expected) {
+		t.Errorf("expected the OOMs of pids %v from the highest rotation number to the lowest, got %v", expected, pids)
+	}
+}
+
+func TestNewFromLogDirUnterminated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oomparser")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	// The last line of the older log is not joined to the first of the
+	// next.
+	appendToFile(t, filepath.Join(dir, "messages.1"), startLine+"\n"+containerLine+"\n"+endLine)
+	appendToFile(t, filepath.Join(dir, "messages"), startLine+"\n"+containerLine+"\n"+strings.Replace(endLine, "19667", "19668", 1)+"\n")
+	os.Chtimes(filepath.Join(dir, "messages.1"), time.Unix(0, 0), time.Unix(0, 0))
+	oomLog, err := NewFromLogDir(dir, "messages*")
+	if err != nil {
+		t.Fatalf("failed to read the logs in %q: %v", dir, err)
+	}
+	defer oomLog.Close()
+	if pids, expected := streamPids(t, oomLog), []int{19667, 19668}; !reflect.DeepEqual(pids, expected) {
+		t.Errorf("expected the OOMs of pids %v, got %v", expected, pids)
+	}
+}
+
+func TestNewFromLogDirErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oomparser")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if _, err := NewFromLogDir(dir, "messages*"); err == nil {
+		t.Errorf("expected an error with no logs to read")
+	}
+	if _, err := NewFromLogDir(dir, "messages["); err == nil {
+		t.Errorf("expected an error for a bad glob")
+	}
+	appendToFile(t, filepath.Join(dir, "messages.1.gz"), "not gzipped\n")
+	if _, err := NewFromLogDir(dir, "messages*"); err == nil {
+		t.Errorf("expected an error for a log that cannot be decompressed")
+	}
+	os.Remove(filepath.Join(dir, "messages.1.gz"))
+	appendToFile(t, filepath.Join(dir, "messages.1.xz"), "not xz\n")
+	if _, err := NewFromLogDir(dir, "messages*"); err == nil {
+		t.Errorf("expected an error for a log compressed with xz")
+	}
+}
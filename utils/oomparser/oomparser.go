@@ -16,157 +16,2803 @@ package oomparser
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"os"
 	"os/exec"
 	"path"
 	"regexp"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/google/cadvisor/utils"
-	"github.com/google/cadvisor/utils/tail"
 
 	"github.com/golang/glog"
+	"golang.org/x/net/context"
 )
 
+// MatcherSet holds the regexps that find the main lines of an OOM dump, so that
+// dumps in other formats can be parsed by setting OomParser.Matchers. Each is
+// described by the submatches it must capture.
+type MatcherSet struct {
+	// FirstLine matches the line that starts a dump.
+	FirstLine *regexp.Regexp
+	// Container matches the line naming the killed task's cgroup, then the
+	// cgroup whose limit was hit.
+	Container *regexp.Regexp
+	// OomKill matches the "oom-kill:" summary line, capturing its fields.
+	OomKill *regexp.Regexp
+	// LastLine matches the line naming the killed process, capturing its
+	// syslog date, pid and name.
+	LastLine *regexp.Regexp
+	// IsoLastLine is LastLine for lines with an ISO 8601 date instead.
+	IsoLastLine *regexp.Regexp
+	// UndatedLastLine is LastLine for lines without a date, such as those
+	// of /dev/kmsg and dmesg, capturing only the pid and name. It must match
+	// the lines LastLine and IsoLastLine do, which are only tried on lines
+	// that might match it.
+	UndatedLastLine *regexp.Regexp
+}
+
+// DefaultMatchers matches the dumps of mainline kernels, as logged to
+// /dev/kmsg, syslog or journald.
+var DefaultMatchers = MatcherSet{
+	FirstLine: regexp.MustCompile(`invoked oom-killer:`),
+	Container: regexp.MustCompile(`Task in (.*) killed as a result of limit of (.*)`),
+	// Newer kernels, including all cgroup v2 hosts, summarize the kill on a
+	// single "oom-kill:" line instead.
+	OomKill: regexp.MustCompile(`oom-kill:(.*)`),
+	// The name of the process killed is its comm, which may hold spaces and
+	// punctuation, e.g. "java.util" or "kworker/u8:2".
+	LastLine: regexp.MustCompile(`(^\p{L}{3,5}\.? .*[0-9]{1,2} [0-9]{1,2}:[0-9]{2}:[0-9]{2}) .* Killed process ([0-9]+) \(([^()]+)\)`),
+	// journalctl -o short-iso dates lines with their year and zone instead.
+	IsoLastLine: regexp.MustCompile(`(^[0-9]{4}-[0-9]{2}-[0-9]{2}T[0-9]{2}:[0-9]{2}:[0-9]{2}(?:[+-][0-9]{2}:?[0-9]{2}|Z)) .* Killed process ([0-9]+) \(([^()]+)\)`),
+	// /dev/kmsg messages have no date, their time is in the record's header.
+	// This also matches the "Out of memory: Killed process" wording of newer
+	// kernels.
+	UndatedLastLine: regexp.MustCompile(`Killed process ([0-9]+) \(([^()]+)\)`),
+}
+
 var (
-	containerRegexp = regexp.MustCompile(`Task in (.*) killed as a result of limit of (.*)`)
-	lastLineRegexp  = regexp.MustCompile(`(^[A-Z][a-z]{2} .*[0-9]{1,2} [0-9]{1,2}:[0-9]{2}:[0-9]{2}) .* Killed process ([0-9]+) \(([\w]+)\)`)
-	firstLineRegexp = regexp.MustCompile(`invoked oom-killer:`)
+	oomKillFlagRegexp = regexp.MustCompile(`^[a-z_]+$`)
+	// The invoking task's name may contain spaces, so it is found by what
+	// precedes it, tried in order: a printk timestamp, the syslog "kernel:"
+	// tag, or nothing at all for /dev/kmsg messages.
+	invokingProcessRegexps = []*regexp.Regexp{
+		regexp.MustCompile(`\] (.+) invoked oom-killer:`),
+		regexp.MustCompile(`kernel: (.+) invoked oom-killer:`),
+		regexp.MustCompile(`^(.+) invoked oom-killer:`),
+	}
+	// The header of the stack dump of the invoking task, in the initial pid
+	// namespace. Kernels from 6.10 also print its uid.
+	invokingTidRegexp = regexp.MustCompile(`CPU: [0-9]+ (?:UID: [0-9]+ )?PID: ([0-9]+) Comm: `)
+	// Kernels separate these fields with ", " or just " ".
+	allocationOrderRegexp = regexp.MustCompile(`invoked oom-killer:.*\border=(-?[0-9]+)`)
+	gfpMaskRegexp         = regexp.MustCompile(`invoked oom-killer:.*\bgfp_mask=(0x[0-9a-fA-F]+(?:\([^)]*\))?)`)
+	constraintRegexp      = regexp.MustCompile(`constraint=(CONSTRAINT_[A-Z_]+)`)
+	// Node lists such as "0-1,3" contain commas, but not ", ".
+	nodeMaskRegexp    = regexp.MustCompile(`invoked oom-killer:.*\bnodemask=([0-9-]+(?:,[0-9-]+)*)`)
+	memsAllowedRegexp = regexp.MustCompile(`\bcpuset=\S* mems_allowed=([0-9-]+(?:,[0-9-]+)*)`)
+	// Both the "cpuset=" line of older kernels and the "oom-kill:" line.
+	cpusetRegexp      = regexp.MustCompile(`\bcpuset=([^\s,]*)[ ,]mems_allowed=`)
+	oomScoreAdjRegexp = regexp.MustCompile(`oom_score_adj:(-?[0-9]+)`)
+	memoryLimitRegexp = regexp.MustCompile(`memory: usage [0-9]+(?:kB)?, limit ([0-9]+)(kB)?`)
+	taskHeaderRegexp  = regexp.MustCompile(`\[\s*pid\s*\]\s+(.*)`)
+	taskRowRegexp     = regexp.MustCompile(`\[\s*([0-9]+)\]\s+(.*)`)
+	// Kernels before 5.1 print the badness score the victim was chosen by
+	// before killing it, or one of its children instead.
+	killScoreRegexp = regexp.MustCompile(`\bKill process ([0-9]+) \(.*\) score (-?[0-9]+)`)
+	// Logged in place of the "Killed process" line when there is no process
+	// to kill, dated like it in syslog and journald.
+	noKillableRegexp = regexp.MustCompile(`^(?:(?:(\p{L}{3,5}\.? .*[0-9]{1,2} [0-9]{1,2}:[0-9]{2}:[0-9]{2})|([0-9]{4}-[0-9]{2}-[0-9]{2}T\S+)) )?.*Out of memory and no killable processes`)
+	// The optional hostname is followed by the tag and optional pid of the
+	// program that logged the line, e.g. "Jan  5 15:20:01 host CRON[14608]: ".
+	syslogTagRegexp   = regexp.MustCompile(`^(?:\p{L}{3,5}\.? [ 0-9][0-9] [0-9]{2}:[0-9]{2}:[0-9]{2}|[0-9]{4}-[0-9]{2}-[0-9]{2}T\S+) (?:\S+ )?([^\s:\[]+)(?:\[[0-9]+\])?: `)
+	oomGroupRegexp    = regexp.MustCompile(`Tasks in (.*) are going to be killed due to memory.oom.group set`)
+	cgroupStatsRegexp = regexp.MustCompile(`Memory cgroup stats for [^:]*:(.*)`)
+	// cgroup v1 prints all the stats on the header line, in kB.
+	cgroupV1StatRegexp = regexp.MustCompile(`([a-z_]+):([0-9]+)KB`)
+	// cgroup v2 prints a stat per line after the header, mostly in bytes.
+	cgroupV2StatRegexp = regexp.MustCompile(`(?:^|\] |kernel: )([a-z0-9_]+) ([0-9]+)\s*$`)
+	// cgroup v1 reports memory+swap, and cgroup v2 swap alone.
+	memcgCounterRegexp = regexp.MustCompile(`(memory|memory\+swap|swap): usage ([0-9]+)(kB)?, limit ([0-9]+)(?:kB)?`)
+	freeSwapRegexp     = regexp.MustCompile(`Free swap\s*=\s*([0-9]+)kB`)
+	totalSwapRegexp    = regexp.MustCompile(`Total swap\s*=\s*([0-9]+)kB`)
+	totalRAMRegexp     = regexp.MustCompile(`([0-9]+) pages RAM`)
+	// e.g. "Node 0 DMA32 free:44592kB min:44640kB low:55800kB ...". Newer
+	// kernels add fields such as boost: between them, so each watermark is
+	// found by its name.
+	zoneRegexp      = regexp.MustCompile(`\bNode ([0-9]+) (\w+) free:`)
+	zoneFieldRegexp = regexp.MustCompile(`\b(free|min|low|high):([0-9]+)kB`)
+)
+
+// The constraints under which the kernel invokes the OOM killer, as reported in
+// OomInstance.Constraint.
+const (
+	// memory was exhausted system wide
+	ConstraintNone = "CONSTRAINT_NONE"
+	// memory was exhausted on the nodes of the task's cpuset
+	ConstraintCpuset = "CONSTRAINT_CPUSET"
+	// memory was exhausted on the nodes of the task's mempolicy
+	ConstraintMemoryPolicy = "CONSTRAINT_MEMORY_POLICY"
+	// a memory cgroup hit its limit
+	ConstraintMemcg = "CONSTRAINT_MEMCG"
+)
+
+// OomScope is the memory that an OOM ran out of, as reported in
+// OomInstance.Scope.
+type OomScope string
+
+const (
+	// the memory of the whole system, or of the nodes of a task's mempolicy
+	ScopeGlobal OomScope = "global"
+	// the memory of a memory cgroup that hit its limit
+	ScopeMemcg OomScope = "memcg"
+	// the memory of the nodes of a task's cpuset
+	ScopeCpuset OomScope = "cpuset"
+)
+
+// Limits at or above this many bytes are the kernel's way of printing
+// "unlimited" (e.g. 18014398509481983kB or 9007199254740988kB).
+const unlimitedMemoryBytes = 1 << 62
+
+// The longest message the kernel logs, LOG_LINE_MAX less the terminating NUL.
+// Longer messages are cut short.
+const kernelMaxMessageLen = 1024 - 32 - 1
+
+// struct to hold file from which we obtain OomInstances
+type OomParser struct {
+	// the EventSeq of the last OomInstance sent, the number of Historical
+	// OOMs skipped for being older than MaxHistoryAge, how many kmsg records
+	// have been lost, and what Stats reports. They are accessed atomically,
+	// so they come first, as on 32-bit platforms only the start of an
+	// allocated struct is 64-bit aligned, see sync/atomic.
+	eventSeq          uint64
+	skippedHistorical uint64
+	lostKmsgRecs      uint64
+	counters          parserCounters
+
+	ioreader *bufio.Reader
+	// the source ioreader reads from
+	source io.Reader
+	// closer releases the source behind ioreader, if it can be released.
+	// It is guarded by sourceLock, as reconnecting replaces it.
+	closer     io.Closer
+	closed     bool
+	closeErr   error
+	sourceLock sync.Mutex
+	// reopen returns a parser over a fresh copy of the source, for sources
+	// that can be reopened after a read error. Nil for the others.
+	reopen func() (*OomParser, error)
+	// the name of the source NewAuto chose, and those after it to fail over
+	// to, guarded by sourceLock
+	sourceName  string
+	nextSources []autoSource
+	// lmkd is set when lines are from Android's low memory killer, see
+	// NewFromLmkd.
+	lmkd bool
+	// kmsg is set when lines are /dev/kmsg records, whose timestamps are
+	// microseconds since bootTime.
+	kmsg     bool
+	bootTime time.Time
+	// the sequence number of the last kmsg record read, used to spot
+	// records lost to ring buffer overruns
+	lastKmsgSeq uint64
+	haveKmsgSeq bool
+	// the level of the last kmsg message returned by splitKmsgLine, or -1 if
+	// its header had none
+	lastKmsgLevel int
+	// the OOMs logged up to historyEnd were already in the kmsg ring buffer
+	// when it was opened. Zero unless the buffer is being replayed.
+	historyEnd time.Time
+	// the OOM whose dump parseLines is reading, if any, for
+	// FlushPartialAfter. Only used by the goroutine parsing lines.
+	inProgress *OomInstance
+	// returns the current time, which the years that syslog dates leave out
+	// are worked out from. Replaced in tests.
+	nowFunc func() time.Time
+	// how many times faster than they were logged lines are replayed, if
+	// positive, see ReplayWithTiming
+	replaySpeed float64
+	// the id of the current boot, read once if AnnotateBootID is set
+	bootID     string
+	bootIDOnce sync.Once
+	// follow is set for sources that may grow after reaching their end, so
+	// that reaching it waits for more rather than ending the stream.
+	follow bool
+	// the error that ended the last stream, and whether one is running
+	streamErr  error
+	streaming  bool
+	streamLock sync.Mutex
+	// the stream Next reads from, started by its first call, and the error
+	// that ended it once nextDone is closed
+	nextStream chan *OomInstance
+	nextDone   chan struct{}
+	nextErr    error
+	nextOnce   sync.Once
+	// the subscribers that the stream started by the first call to
+	// Subscribe fans its OOMs out to, and whether it has ended, guarded by
+	// subscribersLock
+	subscribers     map[*subscriber]bool
+	subscribersDone bool
+	subscribersLock sync.Mutex
+	subscribeOnce   sync.Once
+
+	// CloseStreamOnExit makes StreamOoms and its variants close their output
+	// channel when they return, so that callers ranging over it see the end of
+	// the stream. Err then reports why the stream ended. It is off by default
+	// since the caller owns the channel. With it set, a parser can only be
+	// streamed from once, as a second stream would close the channel again.
+	CloseStreamOnExit bool
+	// ReadTimeout, if not zero, is how long reading the source may block
+	// before it is given up on: /dev/kmsg is then reopened, and other sources
+	// end the stream with an error. The kernel log can go quiet for a long
+	// time without anything being wrong, which also times out, so this should
+	// be generous. Sources that support read deadlines, such as pollable
+	// files, are given one. /dev/kmsg may not be pollable, so reads from it
+	// are watched by another goroutine instead.
+	ReadTimeout time.Duration
+	// Failover, for parsers made by New or NewAuto, switches to the next source
+	// that can be opened when reading the current one fails and it cannot be
+	// reopened at the first attempt. The OOM being read when it failed is
+	// lost.
+	Failover bool
+	// MaxReconnectBackoff caps the wait between attempts to reopen /dev/kmsg
+	// after reading it fails. Defaults to defaultMaxReconnectBackoff if zero.
+	MaxReconnectBackoff time.Duration
+	// ParseCgroupStats fills in OomInstance.CgroupStats, and the swap and RAM
+	// totals from the kernel's Mem-Info block. It is off by default as the map
+	// costs an allocation per stat for every OOM.
+	ParseCgroupStats bool
+	// ParseZones fills in OomInstance.Zones from the free memory and
+	// watermarks the kernel dumps for each zone of each NUMA node. It is off
+	// by default as hosts with many nodes dump many zones.
+	ParseZones bool
+	// MaxHistoryAge, if positive, skips the Historical OOMs replayed by
+	// NewWithHistory that happened more than MaxHistoryAge before the parser
+	// was created. They are not sent, only counted, see
+	// SkippedHistoricalOoms.
+	MaxHistoryAge time.Duration
+	// AnnotateBootID sets the BootID of the OOMs sent to the id of the
+	// current boot, which is read once, when the parser is first streamed
+	// from. OOMs replayed from a saved log may be from an earlier boot.
+	AnnotateBootID bool
+	// DropGlobalOoms drops the OOMs whose IsGlobal is set rather than sending
+	// them.
+	DropGlobalOoms bool
+	// Metrics, if not nil, is told what StreamOoms and its variants parse.
+	Metrics Metrics
+	// ResolveCgroup looks up the VictimContainerName of each OOM sent in the
+	// cgroup hierarchies mounted at CgroupRoot, defaulting to
+	// defaultCgroupRoot if empty, to set its VictimCgroupExists and
+	// VictimCgroupUsageBytes. It is off by default as it reads the cgroup
+	// filesystem for every OOM.
+	ResolveCgroup bool
+	CgroupRoot    string
+	// NormalizeContainerName, if not nil, maps the ContainerName and
+	// VictimContainerName of each OOM sent, e.g. from a cgroup path to the id
+	// of a container. It is applied after filtering, so filters still see
+	// cgroup paths, and IsGlobal is still set from the path.
+	NormalizeContainerName func(string) string
+	// Matchers, if not nil, finds the lines of OOM dumps in place of
+	// DefaultMatchers.
+	Matchers *MatcherSet
+	// Heartbeat, if not nil, is called every HeartbeatInterval while
+	// StreamOoms or one of its variants is waiting for lines to parse, with
+	// the time of the call. It is not called while the stream is blocked on
+	// its consumer, so its calls stopping tell a stream that is stuck from
+	// one with nothing to report. Nothing is called once the stream ends.
+	Heartbeat         func(time.Time)
+	HeartbeatInterval time.Duration
+	// FlushPartialAfter, if positive, makes StreamOoms and its variants send
+	// what has been parsed of an OOM whose dump is still being read
+	// FlushPartialAfter after it started, such as its ContainerName, as a
+	// Partial OOM, e.g. for dashboards showing OOMs in progress. The OOM is
+	// sent again, complete, once its dump ends. Each OOM is flushed at most
+	// once.
+	FlushPartialAfter time.Duration
+	// MalformedKmsg is what is done with /dev/kmsg lines that have no valid
+	// record header.
+	MalformedKmsg MalformedKmsgPolicy
+	// Logger, if not nil, is where the parser's messages, warnings and
+	// errors are logged instead of glog.
+	Logger Logger
+	// Overflow is what StreamOoms and its variants do with an OOM while the
+	// output channel is full. Unless it is OverflowBlock, up to
+	// OverflowBufferSize OOMs are buffered, defaulting to
+	// defaultOverflowBufferSize if zero, and any more are dropped.
+	Overflow           OverflowPolicy
+	OverflowBufferSize int
+	// StagedLines is how many of the lines read from the source may wait to
+	// be parsed while StreamOoms and its variants are held up sending an OOM,
+	// defaulting to defaultStagedLines if zero and capped at
+	// maxStagedLines. The source, /dev/kmsg in particular, goes on being
+	// read through a stall of the consumer until they are full, without
+	// dropping anything.
+	StagedLines int
+	// RateLimit, if positive, caps the OOMs StreamOoms and its variants send
+	// to RateLimit a second on average, in bursts of up to RateLimitBurst,
+	// which defaults to RateLimit rounded up. The OOMs over the limit are
+	// suppressed, and counted if Metrics is also a RateLimitMetrics. If
+	// CoalesceRateLimited is set, the last OOM of each run suppressed is sent
+	// ahead of the next OOM the limit allows, or when the stream ends, with
+	// Coalesced set to the number of OOMs in the run.
+	RateLimit           float64
+	RateLimitBurst      int
+	CoalesceRateLimited bool
+	// MaxOomLines is how many lines of an OOM dump are read looking for the
+	// "Killed process" line before giving up and sending what was found as a
+	// Partial OOM. Defaults to defaultMaxOomLines if zero.
+	MaxOomLines int
+	// KeepRawLines fills in OomInstance.RawLines, keeping up to MaxRawLines
+	// lines of each dump, defaulting to defaultMaxRawLines if zero. It is off
+	// by default as the lines of a dump can take a lot of memory.
+	KeepRawLines bool
+	MaxRawLines  int
+	// TopConsumers, if positive, fills in OomInstance.TopConsumers with that
+	// many of the processes in each task dump with the highest RSS, capped
+	// at maxTopConsumers.
+	TopConsumers int
+	// ContextBefore and ContextAfter, if positive, fill in the OomInstance
+	// fields of the same names with that many of the lines logged before
+	// and after each OOM, capped at maxContextLines. They are every line
+	// read from the source, not only the kernel's. An OOM is not sent until
+	// ContextAfter lines have followed it, or the stream ends, so it is
+	// held back for as long as the log is quiet.
+	ContextBefore int
+	ContextAfter  int
+	// Location is the time zone that the dates in the log, which syslog
+	// writes without one, are in. Defaults to time.Local if nil, which is
+	// wrong when the host logs in a different zone to the one the parser
+	// runs in, e.g. in a container without the host's zoneinfo.
+	Location *time.Location
+}
+
+const defaultMaxRawLines = 100
+
+// Kept small, as every OOM sent carries its own list.
+const maxTopConsumers = 50
+
+// Each OOM sent carries its own context lines, and those before it are kept
+// for every line read, so they are bounded too.
+const maxContextLines = 200
+
+// Task dumps have a line per task in the OOMing cgroup, or on the host for a
+// global OOM, so this needs to be generous.
+const defaultMaxOomLines = 10000
+
+// OverflowPolicy is what an OomParser does with OOMs that its consumer is not
+// keeping up with.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock waits for the consumer, during which nothing more is read
+	// from the source. For /dev/kmsg this risks records being overwritten.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest drops the longest buffered OOM to make room.
+	OverflowDropOldest
+	// OverflowDropNewest drops the OOM that does not fit.
+	OverflowDropNewest
+)
+
+const defaultOverflowBufferSize = 100
+
+// By default, enough lines for a whole dump with a task dump of hundreds of
+// processes to be read while its OOM waits to be sent. The lines of a dump
+// are at most kernelMaxMessageLen long, so the staged lines take up to about
+// 1MB by default and 10MB at most.
+const (
+	defaultStagedLines = 1000
+	maxStagedLines     = 10000
+)
+
+// MalformedKmsgPolicy is what an OomParser reading /dev/kmsg does with lines
+// that have no valid record header, such as those missing the ';' before the
+// message.
+type MalformedKmsgPolicy int
+
+const (
+	// MalformedKmsgProcess warns, then parses the whole line as a message.
+	// Some embedded kernels log plain lines, but the header text of a
+	// corrupt record may then be matched too.
+	MalformedKmsgProcess MalformedKmsgPolicy = iota
+	// MalformedKmsgSkip warns, then skips the line.
+	MalformedKmsgSkip
+	// MalformedKmsgSkipSilently skips the line without warning.
+	MalformedKmsgSkipSilently
+)
+
+// Metrics counts what an OomParser parses, e.g. in a metrics registry. Its
+// methods are called from the goroutine streaming from the parser.
+type Metrics interface {
+	// IncParsed is called for each OOM parsed, including those then dropped
+	// rather than sent.
+	IncParsed()
+	// IncParseError is called for each line that fails to parse.
+	IncParseError()
+	// IncUnrecognizedLine is called for each line read that is not part of an
+	// OOM message group.
+	IncUnrecognizedLine()
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) IncParsed()           {}
+func (noopMetrics) IncParseError()       {}
+func (noopMetrics) IncUnrecognizedLine() {}
+
+// Logger logs an OomParser's messages, warnings and errors, e.g. to silence
+// them or send them to another logging library. Its methods take fmt.Printf
+// style arguments, and may be called from any goroutine.
+type Logger interface {
+	Infof(format string, args ...interface{})
+	Warningf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+type glogLogger struct{}
+
+func (glogLogger) Infof(format string, args ...interface{}) {
+	glog.InfoDepth(1, fmt.Sprintf(format, args...))
+}
+
+func (glogLogger) Warningf(format string, args ...interface{}) {
+	glog.WarningDepth(1, fmt.Sprintf(format, args...))
+}
+
+func (glogLogger) Errorf(format string, args ...interface{}) {
+	glog.ErrorDepth(1, fmt.Sprintf(format, args...))
+}
+
+func (self *OomParser) logger() Logger {
+	if self.Logger == nil {
+		return glogLogger{}
+	}
+	return self.Logger
+}
+
+const (
+	initialReconnectBackoff    = 100 * time.Millisecond
+	defaultMaxReconnectBackoff = 30 * time.Second
 )
 
-// struct to hold file from which we obtain OomInstances
-type OomParser struct {
-	ioreader *bufio.Reader
-}
+// struct that contains information related to an OOM kill instance. Its JSON
+// field names are a wire format and must not change; TimeOfDeath is encoded
+// in RFC 3339 format.
+type OomInstance struct {
+	// process id of the killed process, in its own pid namespace if the
+	// kernel reports it, which mainline kernels do not, and otherwise in the
+	// initial pid namespace like VictimGlobalPid. 0 if the pid logged was
+	// too large for an int, which only a corrupt log can make it, as the
+	// kernel's PID_MAX_LIMIT is 2^22.
+	Pid int `json:"pid"`
+	// the name of the killed process
+	ProcessName string `json:"process_name"`
+	// the time that the process was reported to be killed,
+	// accurate to the second. When read from /dev/kmsg it is instead
+	// derived from the kernel's timestamp and is accurate to the microsecond.
+	// Zero if the line had no date, as in dmesg output.
+	TimeOfDeath time.Time `json:"time_of_death"`
+	// the kernel's random id for the boot the OOM happened in, which the
+	// kernel's timestamps are relative to. Only set if the parser's
+	// AnnotateBootID is, and empty if the id could not be read.
+	BootID string `json:"boot_id"`
+	// the position of this event among those sent by its OomParser,
+	// starting at 1. Orders events whose TimeOfDeath is the same.
+	EventSeq uint64 `json:"event_seq"`
+	// the absolute name of the container that OOMed: the cgroup of the
+	// killed task, <x> in "Task in <x> killed as a result of limit of <y>",
+	// or task_memcg on an "oom-kill:" line. If neither reports anything but
+	// the root for an OOM in a memory cgroup, e.g. as the line was cut
+	// short, it falls back to the cpuset of the process that invoked the
+	// OOM killer, and ContainerNameFromCpuset is set.
+	ContainerName string `json:"container_name"`
+	// whether ContainerName is the cpuset of the process that invoked the
+	// OOM killer. The kernel only logs the last part of its path, so it is
+	// e.g. "/cri-containerd-<id>.scope", and it may not be the killed
+	// task's cgroup, though in a memcg OOM it is usually in the same one.
+	ContainerNameFromCpuset bool `json:"container_name_from_cpuset"`
+	// the absolute name of the container whose limit was hit, which is
+	// <y> in the legacy line above, or oom_memcg on an "oom-kill:" line.
+	// It is an ancestor of ContainerName, or the same cgroup.
+	VictimContainerName string `json:"victim_container_name"`
+	// whether ContainerName or VictimContainerName may have been cut short,
+	// as the kernel limits the length of the lines it logs. Long cgroup
+	// paths can reach the limit, so they should not be trusted to be exact.
+	Truncated bool `json:"truncated"`
+	// whether the VictimContainerName cgroup still existed when the OOM was
+	// parsed, and its memory usage in bytes then, which is 0 if it does not
+	// exist or does not report it. Only set if the parser's ResolveCgroup
+	// is. The cgroup is often gone, as killing the process can end the
+	// container.
+	VictimCgroupExists     bool   `json:"victim_cgroup_exists"`
+	VictimCgroupUsageBytes uint64 `json:"victim_cgroup_usage_bytes"`
+	// the oom_score_adj of the killed process. Only meaningful when
+	// HasOomScoreAdj is set, as older kernels do not report it.
+	OomScoreAdj int `json:"oom_score_adj"`
+	// whether the kernel reported the killed process's oom_score_adj
+	HasOomScoreAdj bool `json:"has_oom_score_adj"`
+	// the badness score the kernel picked the killed process by, which
+	// takes its oom_score_adj into account. Only meaningful when
+	// HasVictimOomScore is set: kernels from 5.1 do not report it unless
+	// their task table has an oom_score column, and it is not reported for
+	// a child killed in its parent's place.
+	VictimOomScore int `json:"victim_oom_score"`
+	// whether the kernel reported the killed process's badness score
+	HasVictimOomScore bool `json:"has_victim_oom_score"`
+	// the memory limit in bytes of the cgroup that OOMed, as reported by the
+	// kernel. 0 if the limit was not reported or is unlimited.
+	MemoryLimitBytes uint64 `json:"memory_limit_bytes"`
+	// the swap used by the cgroup that OOMed, and its swap limit, in bytes.
+	// Both are 0 if the kernel does not account swap to the cgroup. cgroup v1
+	// only reports memory and swap together, so they are what that adds to
+	// the memory usage and limit.
+	SwapUsageBytes uint64 `json:"swap_usage_bytes"`
+	SwapLimitBytes uint64 `json:"swap_limit_bytes"`
+	// whether the cgroup's swap is unlimited, in which case SwapLimitBytes
+	// is 0, which otherwise means it may not swap at all
+	SwapUnlimited bool `json:"swap_unlimited"`
+	// the resident set size, in pages, of the killed process as reported in
+	// the kernel's task dump. 0 if the killed process's row was not found.
+	VictimRSSPages uint64 `json:"victim_rss_pages"`
+	// the processes in the kernel's task dump with the highest RSS, from the
+	// highest, including the killed process if it is among them. Only set if
+	// the parser's TopConsumers is, and nil if there was no task dump.
+	TopConsumers []ProcessMemInfo `json:"top_consumers"`
+	// whether the container and process were read from the "oom-kill:"
+	// summary line of newer kernels rather than the legacy messages
+	FromOomKillLine bool `json:"from_oom_kill_line"`
+	// the constraint that caused the OOM, one of the Constraint* values, or
+	// empty if the kernel did not report it
+	Constraint string `json:"constraint"`
+	// the memory the OOM ran out of. Taken from the "global_oom" flag or
+	// "oom_memcg" field of the "oom-kill:" summary line where the kernel
+	// logs one, and otherwise inferred from Constraint, or failing that
+	// VictimContainerName. Empty for the kills of Android's lmkd.
+	Scope OomScope `json:"scope"`
+	// the NUMA nodes the allocation that invoked the OOM killer was limited
+	// to, e.g. "0-1", by its mempolicy. Empty if it was not limited or the
+	// kernel did not report it.
+	NodeMask string `json:"node_mask"`
+	// the NUMA nodes allowed by the cpuset of the process that invoked the
+	// OOM killer, e.g. "0" or "0,2". Empty if the kernel did not report them.
+	MemsAllowed string `json:"mems_allowed"`
+	// the name of the process whose allocation invoked the OOM killer, which
+	// need not be the one that was killed. Empty if it was not reported.
+	InvokingProcess string `json:"invoking_process"`
+	// the order of the allocation that invoked the OOM killer, i.e. it was
+	// for 2^AllocationOrder pages, or -1 if it was not reported
+	AllocationOrder int `json:"allocation_order"`
+	// the id of the thread whose allocation invoked the OOM killer, in the
+	// initial pid namespace, from the stack dump the kernel prints after the
+	// "invoked oom-killer" line. The kernel prints the thread's id rather
+	// than its process's, so it is only the pid of the process for its main
+	// thread. 0 if it was not reported.
+	InvokingTid int `json:"invoking_tid"`
+	// whether the process killed was the one that invoked the OOM killer,
+	// rather than a bystander, i.e. InvokingTid is its VictimGlobalPid. Only
+	// set if InvokingTid is known, and as that is a thread's id, only for
+	// processes that invoked it from their main thread, which is all of a
+	// single threaded process's but few of a JVM's or Go program's.
+	SelfKill bool `json:"self_kill"`
+	// the GFP flags of the allocation that invoked the OOM killer as printed
+	// by the kernel, e.g. "0x201da" or, on newer kernels,
+	// "0x6000c0(GFP_KERNEL)". Empty if it was not reported.
+	GfpMask string `json:"gfp_mask"`
+	// the uid that owned the killed process, or -1 if it was not reported
+	VictimUID int `json:"victim_uid"`
+	// the size, in pages, of the killed process's virtual memory as reported
+	// in the kernel's task dump. 0 if the killed process's row was not found.
+	VictimTotalVMPages uint64 `json:"victim_total_vm_pages"`
+	// the memory used by the killed process's page tables as reported in the
+	// kernel's task dump. 0 if it was not reported, as kernels before 4.15
+	// only print their nr_ptes count instead.
+	VictimPgTablesBytes uint64 `json:"victim_pgtables_bytes"`
+	// the stats the kernel dumped for the cgroup that hit its limit, e.g.
+	// "rss" on cgroup v1 or "anon" on cgroup v2, in bytes. The event counters
+	// of cgroup v2, such as "pgfault", are counts. Only set if the parser's
+	// ParseCgroupStats is, and nil if the kernel dumped no stats.
+	CgroupStats map[string]uint64 `json:"cgroup_stats"`
+	// the free and total swap, and the total pages of RAM, from the Mem-Info
+	// block the kernel dumps for OOMs that are not limited to a memory cgroup.
+	// Only set if the parser's ParseCgroupStats is, and TotalRAMPages is 0
+	// if the block was not dumped.
+	FreeSwapKB    uint64 `json:"free_swap_kb"`
+	TotalSwapKB   uint64 `json:"total_swap_kb"`
+	TotalRAMPages uint64 `json:"total_ram_pages"`
+	// the free memory and watermarks of each memory zone, in the order the
+	// kernel dumped them, which it does for OOMs that are not limited to a
+	// memory cgroup. Only set if the parser's ParseZones is.
+	Zones []ZoneMemInfo `json:"zones"`
+	// whether the OOM was not caused by a memory cgroup hitting its limit,
+	// i.e. no VictimContainerName was reported. This is not the same as
+	// ContainerName being "/": newer kernels still report the cgroup of a
+	// task killed by a global OOM, while older kernels report neither.
+	IsGlobal bool `json:"is_global"`
+	// whether the memory cgroup that hit its limit was the root cgroup, i.e.
+	// VictimContainerName is "/". ContainerName defaults to "/" too, so it
+	// cannot tell such an OOM from a global one on older kernels, but
+	// IsGlobal is not set for it.
+	RootMemcgKill bool `json:"root_memcg_kill"`
+	// whether the OOM happened before its parser was created, and was
+	// replayed from the kernel's ring buffer, see NewWithHistory
+	Historical bool `json:"historical"`
+	// process id of the killed process in the initial pid namespace, which
+	// is the pid the kernel logs even when the process was in a container
+	VictimGlobalPid int `json:"victim_global_pid"`
+	// whether the process was killed along with the rest of its cgroup, as
+	// memory.oom.group was set, rather than being chosen by the OOM killer.
+	// Each process killed is reported as its own OomInstance, after the one
+	// the OOM killer chose, with the group as its ContainerName. The kernel
+	// only logs that the group is being killed after the process it chose,
+	// which is sent as soon as it is parsed, so its GroupKill is not set.
+	// Only cgroup v2 has memory.oom.group.
+	GroupKill bool `json:"group_kill"`
+	// whether the "Killed process" line was not found within the parser's
+	// MaxOomLines or before another OOM started, or not yet read when the
+	// OOM was sent early because of the parser's FlushPartialAfter, so that
+	// only the details reported before it are set. In particular, Pid is 0 and TimeOfDeath is zero.
+	Partial bool `json:"partial"`
+	// whether the OOM killer found no process it could kill, as those it
+	// could choose from were all unkillable, e.g. with an oom_score_adj of
+	// -1000, or already exiting. The kernel logs "Out of memory and no
+	// killable processes..." instead of a "Killed process" line, so Pid is
+	// 0 and ProcessName is empty. The tasks of a memory cgroup are then left
+	// waiting for memory, while a global OOM panics the kernel.
+	NoKillableProcesses bool `json:"no_killable_processes"`
+	// the level, from 0 for KERN_EMERG to 7 for KERN_DEBUG, that the kernel
+	// logged the OOM's "invoked oom-killer" line at. Only /dev/kmsg records
+	// carry it, so it is -1 for other sources.
+	LogLevel int `json:"log_level"`
+	// the lines the OOM was parsed from, from its start line through the
+	// "Killed process" line, without the /dev/kmsg record headers. Only set
+	// if the parser's KeepRawLines is, and then only the first MaxRawLines.
+	RawLines []string `json:"raw_lines"`
+	// the lines logged before the OOM's start line, and after the
+	// "Killed process" line, oldest first and without the /dev/kmsg record
+	// headers. Only set if the parser's ContextBefore and ContextAfter are,
+	// and then hold up to that many lines, fewer at the start or end of the
+	// log.
+	ContextBefore []string `json:"context_before"`
+	ContextAfter  []string `json:"context_after"`
+	// for an OOM sent in place of those suppressed by the parser's
+	// RateLimit, how many OOMs it stands for, including itself, see
+	// CoalesceRateLimited. 0 for the OOMs sent individually.
+	Coalesced int `json:"coalesced"`
+}
+
+// String formats the OomInstance for logging as
+// "OOM: pid=<Pid> proc=<ProcessName> container=<ContainerName>
+// victim=<VictimContainerName> at <TimeOfDeath> limit=<MemoryLimitBytes>",
+// all on one line, with the time in RFC 3339 format. The format is stable so
+// that logs can be searched for it.
+func (self OomInstance) String() string {
+	return fmt.Sprintf("OOM: pid=%d proc=%s container=%s victim=%s at %s limit=%d",
+		self.Pid, self.ProcessName, self.ContainerName, self.VictimContainerName,
+		self.TimeOfDeath.Format(time.RFC3339Nano), self.MemoryLimitBytes)
+}
+
+// ProcessMemInfo is a process's row in the task table the kernel dumps during
+// an OOM.
+type ProcessMemInfo struct {
+	Pid  int    `json:"pid"`
+	Name string `json:"name"`
+	// the resident set size of the process, in pages
+	RSSPages    uint64 `json:"rss_pages"`
+	OomScoreAdj int    `json:"oom_score_adj"`
+}
+
+// ZoneMemInfo is the free memory of a zone of a NUMA node, such as "DMA32" or
+// "Normal", and the watermarks the kernel reclaims it by, in kB. The kernel
+// invokes the OOM killer for an allocation when reclaim cannot bring a zone it
+// may allocate from above its min watermark.
+type ZoneMemInfo struct {
+	Node   int    `json:"node"`
+	Zone   string `json:"zone"`
+	FreeKB uint64 `json:"free_kb"`
+	MinKB  uint64 `json:"min_kb"`
+	LowKB  uint64 `json:"low_kb"`
+	HighKB uint64 `json:"high_kb"`
+}
+
+// adds the zone a line of the Mem-Info block reports the free memory of, if
+// it reports one, to the oomInstance.
+func getZone(line string, currentOomInstance *OomInstance) {
+	if !strings.Contains(line, " free:") {
+		return
+	}
+	parsedLine := zoneRegexp.FindStringSubmatch(line)
+	if parsedLine == nil {
+		return
+	}
+	node, err := strconv.Atoi(parsedLine[1])
+	if err != nil {
+		return
+	}
+	zone := ZoneMemInfo{Node: node, Zone: parsedLine[2]}
+	for _, field := range zoneFieldRegexp.FindAllStringSubmatch(line, -1) {
+		value, err := strconv.ParseUint(field[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch field[1] {
+		case "free":
+			zone.FreeKB = value
+		case "min":
+			zone.MinKB = value
+		case "low":
+			zone.LowKB = value
+		case "high":
+			zone.HighKB = value
+		}
+	}
+	currentOomInstance.Zones = append(currentOomInstance.Zones, zone)
+}
+
+// taskTable holds the per-task table the kernel dumps during an OOM, so that
+// the killed process's row can be found once its pid is known. Rows are kept
+// by column name since the columns vary between kernel versions.
+type taskTable struct {
+	columns []string
+	rows    map[int]map[string]string
+	// the scores of the processes the kernel said it was killing, by pid
+	scores map[int]int
+}
+
+// adds a line to the table if it is the table header or one of its rows.
+func (self *taskTable) addLine(line string) {
+	if kill := killScoreRegexp.FindStringSubmatch(line); kill != nil {
+		pid, pidErr := strconv.Atoi(kill[1])
+		score, scoreErr := strconv.Atoi(kill[2])
+		if pidErr == nil && scoreErr == nil {
+			if self.scores == nil {
+				self.scores = make(map[int]int)
+			}
+			self.scores[pid] = score
+		}
+		return
+	}
+	if header := taskHeaderRegexp.FindStringSubmatch(line); header != nil {
+		self.columns = strings.Fields(header[1])
+		self.rows = make(map[int]map[string]string)
+		if len(self.columns) == 0 {
+			// Not a header after all, so there is no name column.
+			self.columns = nil
+		}
+		return
+	}
+	if self.columns == nil {
+		return
+	}
+	parsedLine := taskRowRegexp.FindStringSubmatch(line)
+	if parsedLine == nil {
+		return
+	}
+	pid, err := strconv.Atoi(parsedLine[1])
+	if err != nil {
+		return
+	}
+	fields := strings.Fields(parsedLine[2])
+	if len(fields) < len(self.columns) {
+		return
+	}
+	row := make(map[string]string, len(self.columns))
+	for i, column := range self.columns {
+		row[column] = fields[i]
+	}
+	// The name is the last column and may itself contain spaces.
+	row[self.columns[len(self.columns)-1]] = strings.Join(fields[len(self.columns)-1:], " ")
+	self.rows[pid] = row
+}
+
+// fills in the details of the killed process that the task table reports.
+func (self *taskTable) fillVictim(currentOomInstance *OomInstance) {
+	if score, ok := self.scores[currentOomInstance.Pid]; ok {
+		currentOomInstance.VictimOomScore = score
+		currentOomInstance.HasVictimOomScore = true
+	}
+	row, ok := self.rows[currentOomInstance.Pid]
+	if !ok {
+		return
+	}
+	if score, err := strconv.Atoi(row["oom_score"]); err == nil {
+		currentOomInstance.VictimOomScore = score
+		currentOomInstance.HasVictimOomScore = true
+	}
+	if rss, err := strconv.ParseUint(row["rss"], 10, 64); err == nil {
+		currentOomInstance.VictimRSSPages = rss
+	}
+	if totalVM, err := strconv.ParseUint(row["total_vm"], 10, 64); err == nil {
+		currentOomInstance.VictimTotalVMPages = totalVM
+	}
+	if pgTables, err := strconv.ParseUint(row["pgtables_bytes"], 10, 64); err == nil {
+		currentOomInstance.VictimPgTablesBytes = pgTables
+	}
+	// The "oom-kill:" line's uid is preferred, if there was one.
+	if currentOomInstance.VictimUID < 0 {
+		if uid, err := strconv.Atoi(row["uid"]); err == nil {
+			currentOomInstance.VictimUID = uid
+		}
+	}
+}
+
+// returns up to n of the processes in the table with the highest RSS, from the
+// highest, or nil if there are none.
+func (self *taskTable) topConsumers(n int) []ProcessMemInfo {
+	var processes []ProcessMemInfo
+	for pid, row := range self.rows {
+		rss, err := strconv.ParseUint(row["rss"], 10, 64)
+		if err != nil {
+			continue
+		}
+		oomScoreAdj, _ := strconv.Atoi(row["oom_score_adj"])
+		processes = append(processes, ProcessMemInfo{
+			Pid:         pid,
+			Name:        row[self.columns[len(self.columns)-1]],
+			RSSPages:    rss,
+			OomScoreAdj: oomScoreAdj,
+		})
+	}
+	sort.Slice(processes, func(i, j int) bool {
+		if processes[i].RSSPages != processes[j].RSSPages {
+			return processes[i].RSSPages > processes[j].RSSPages
+		}
+		return processes[i].Pid < processes[j].Pid
+	})
+	if len(processes) > n {
+		processes = processes[:n]
+	}
+	return processes
+}
+
+// gets the cgroup from the line that starts the rest of a group kill, e.g.
+// "Tasks in /foo are going to be killed due to memory.oom.group set".
+func getOomGroup(line string) (string, bool) {
+	if !mightMatch(oomGroupRegexp, line) {
+		return "", false
+	}
+	parsedLine := oomGroupRegexp.FindStringSubmatch(line)
+	if parsedLine == nil {
+		return "", false
+	}
+	return path.Join("/", parsedLine[1]), true
+}
+
+// returns the OOM for a process killed as part of the group of the last OOM
+// sent, if line reports one. It shares the details of the last OOM, apart from
+// those of the killed process and its ContainerName, which is the group's.
+// Returns nil for other lines, and for the last OOM's victim, which the kernel
+// may report again.
+func (self *OomParser) getGroupMember(line string, lineTime time.Time, lastOom *OomInstance, groupName string, table *taskTable) (*OomInstance, error) {
+	member := &OomInstance{VictimUID: -1, LogLevel: lastOom.LogLevel}
+	finished, err := self.findProcessNamePid(line, lineTime, member)
+	if !finished || member.Pid == lastOom.Pid {
+		return nil, err
+	}
+	member.ContainerName = groupName
+	member.VictimContainerName = lastOom.VictimContainerName
+	member.Truncated = lastOom.Truncated
+	member.MemoryLimitBytes = lastOom.MemoryLimitBytes
+	member.SwapUsageBytes = lastOom.SwapUsageBytes
+	member.SwapLimitBytes = lastOom.SwapLimitBytes
+	member.SwapUnlimited = lastOom.SwapUnlimited
+	member.FromOomKillLine = lastOom.FromOomKillLine
+	member.Constraint = lastOom.Constraint
+	member.Scope = lastOom.Scope
+	member.InvokingProcess = lastOom.InvokingProcess
+	member.AllocationOrder = lastOom.AllocationOrder
+	member.InvokingTid = lastOom.InvokingTid
+	member.GfpMask = lastOom.GfpMask
+	member.NodeMask = lastOom.NodeMask
+	member.MemsAllowed = lastOom.MemsAllowed
+	member.CgroupStats = lastOom.CgroupStats
+	member.FreeSwapKB = lastOom.FreeSwapKB
+	member.TotalSwapKB = lastOom.TotalSwapKB
+	member.TotalRAMPages = lastOom.TotalRAMPages
+	member.TopConsumers = lastOom.TopConsumers
+	member.ContextBefore = lastOom.ContextBefore
+	member.Zones = lastOom.Zones
+	member.IsGlobal = lastOom.IsGlobal
+	member.RootMemcgKill = lastOom.RootMemcgKill
+	member.Historical = lastOom.Historical
+	member.GroupKill = true
+	table.fillVictim(member)
+	member.SelfKill = isSelfKill(member)
+	return member, err
+}
+
+// the "Memory cgroup stats" block a memcg OOM dumps. Only the first block is
+// kept, which is for the cgroup that hit its limit; cgroup v1 goes on to dump
+// a block for each of its descendants.
+type cgroupStats struct {
+	stats map[string]uint64
+	// whether the lines of a cgroup v2 block are being read
+	inBlock bool
+}
+
+// adds a line to the stats if it is a header or one of its stats.
+func (self *cgroupStats) addLine(line string) {
+	if header := cgroupStatsRegexp.FindStringSubmatch(line); header != nil {
+		self.inBlock = false
+		if self.stats != nil {
+			return
+		}
+		self.stats = make(map[string]uint64)
+		v1Stats := cgroupV1StatRegexp.FindAllStringSubmatch(header[1], -1)
+		for _, stat := range v1Stats {
+			if value, err := strconv.ParseUint(stat[2], 10, 64); err == nil {
+				self.stats[stat[1]] = value * 1024
+			}
+		}
+		self.inBlock = len(v1Stats) == 0
+		return
+	}
+	if !self.inBlock {
+		return
+	}
+	stat := cgroupV2StatRegexp.FindStringSubmatch(line)
+	if stat == nil {
+		self.inBlock = false
+		return
+	}
+	if value, err := strconv.ParseUint(stat[2], 10, 64); err == nil {
+		self.stats[stat[1]] = value
+	}
+}
+
+// gets the swap and RAM totals from a line of the Mem-Info block and adds them
+// to the oomInstance.
+func getMemInfo(line string, currentOomInstance *OomInstance) {
+	if !strings.Contains(line, " swap") && !strings.Contains(line, "pages RAM") {
+		return
+	}
+	for _, field := range []struct {
+		re    *regexp.Regexp
+		value *uint64
+	}{
+		{freeSwapRegexp, &currentOomInstance.FreeSwapKB},
+		{totalSwapRegexp, &currentOomInstance.TotalSwapKB},
+		{totalRAMRegexp, &currentOomInstance.TotalRAMPages},
+	} {
+		if parsedLine := field.re.FindStringSubmatch(line); parsedLine != nil {
+			if value, err := strconv.ParseUint(parsedLine[1], 10, 64); err == nil {
+				*field.value = value
+			}
+		}
+	}
+}
+
+// splits the fields of an "oom-kill:" line, e.g.
+// "oom-kill:constraint=CONSTRAINT_MEMCG,nodemask=(null),cpuset=/,mems_allowed=0,
+// oom_memcg=/foo,task_memcg=/foo/bar,task=stress,pid=123,uid=0", into a map.
+// Values such as mems_allowed may themselves contain commas, and flags such as
+// global_oom have no value. Returns nil if the line is not an oom-kill line.
+func (self *MatcherSet) parseOomKillLine(line string) map[string]string {
+	if !mightMatch(self.OomKill, line) {
+		return nil
+	}
+	parsedLine := self.OomKill.FindStringSubmatch(line)
+	if parsedLine == nil {
+		return nil
+	}
+	fields := make(map[string]string)
+	lastKey := ""
+	for _, field := range strings.Split(strings.TrimSpace(parsedLine[1]), ",") {
+		if i := strings.Index(field, "="); i >= 0 {
+			lastKey = field[:i]
+			fields[lastKey] = field[i+1:]
+		} else if oomKillFlagRegexp.MatchString(field) {
+			lastKey = ""
+			fields[field] = ""
+		} else if lastKey != "" {
+			fields[lastKey] += "," + field
+		}
+	}
+	return fields
+}
+
+// gets the container and process details from an "oom-kill:" line and adds
+// them to the oomInstance. Returns whether the line was an oom-kill line. Its
+// task_memcg and oom_memcg correspond to the cgroups of the legacy
+// "Task in <task_memcg> killed as a result of limit of <oom_memcg>" line.
+func (self *MatcherSet) getOomKillSummary(line string, currentOomInstance *OomInstance) (bool, error) {
+	fields := self.parseOomKillLine(line)
+	if fields == nil {
+		return false, nil
+	}
+	currentOomInstance.FromOomKillLine = true
+	if constraint, ok := fields["constraint"]; ok {
+		currentOomInstance.Constraint = constraint
+	}
+	if _, ok := fields["global_oom"]; ok {
+		currentOomInstance.Scope = ScopeGlobal
+	}
+	if nodeMask, ok := fields["nodemask"]; ok && nodeMask != "(null)" {
+		currentOomInstance.NodeMask = nodeMask
+	}
+	if memsAllowed, ok := fields["mems_allowed"]; ok {
+		currentOomInstance.MemsAllowed = memsAllowed
+	}
+	taskMemcg, hasTaskMemcg := fields["task_memcg"]
+	if hasTaskMemcg {
+		currentOomInstance.ContainerName = path.Join("/", taskMemcg)
+	}
+	oomMemcg, hasOomMemcg := fields["oom_memcg"]
+	if hasOomMemcg {
+		currentOomInstance.VictimContainerName = path.Join("/", oomMemcg)
+		currentOomInstance.Scope = ScopeMemcg
+	}
+	// The kernel prints task_memcg after oom_memcg, then the task, so a
+	// missing field means the one before it was cut off.
+	_, hasTask := fields["task"]
+	if (hasTaskMemcg && !hasTask) || (hasOomMemcg && !hasTaskMemcg) || reachesMessageLimit(self.OomKill, line) {
+		currentOomInstance.Truncated = true
+	}
+	if task, ok := fields["task"]; ok {
+		currentOomInstance.ProcessName = task
+	}
+	// A pid that cannot be parsed does not stop the uid from being read.
+	var pidErr error
+	if pidString, ok := fields["pid"]; ok {
+		pid, err := strconv.Atoi(pidString)
+		if err == nil {
+			currentOomInstance.Pid = pid
+			currentOomInstance.VictimGlobalPid = pid
+		}
+		pidErr = err
+	}
+	if uidString, ok := fields["uid"]; ok {
+		uid, err := strconv.Atoi(uidString)
+		if err != nil {
+			return true, err
+		}
+		currentOomInstance.VictimUID = uid
+	}
+	return true, pidErr
+}
+
+// gets the container name from a line and adds it to the oomInstance. The
+// "oom-kill:" summary line is preferred, falling back to the legacy message.
+func (self *MatcherSet) getContainerName(line string, currentOomInstance *OomInstance) error {
+	if matched, err := self.getOomKillSummary(line, currentOomInstance); matched {
+		return err
+	}
+	if !mightMatch(self.Container, line) {
+		return nil
+	}
+	parsedLine := self.Container.FindStringSubmatch(line)
+	if parsedLine == nil {
+		return nil
+	}
+	// Trailing spaces are not part of the names, e.g. from lines ending in
+	// "\r\n".
+	currentOomInstance.ContainerName = path.Join("/", strings.TrimSpace(parsedLine[1]))
+	currentOomInstance.VictimContainerName = path.Join("/", strings.TrimSpace(parsedLine[2]))
+	currentOomInstance.Truncated = reachesMessageLimit(self.Container, line)
+	return nil
+}
+
+// reports whether the kernel message that re matched in line, taken to start
+// where the match does, is as long as the kernel logs, so is likely to have
+// been cut short.
+func reachesMessageLimit(re *regexp.Regexp, line string) bool {
+	loc := re.FindStringIndex(line)
+	return loc != nil && len(strings.TrimRight(line[loc[0]:], "\r\n")) >= kernelMaxMessageLen
+}
+
+// gets the OOM constraint from a line, such as the "invoked oom-killer" line,
+// and adds it to the oomInstance.
+func getConstraint(line string, currentOomInstance *OomInstance) {
+	parsedLine := constraintRegexp.FindStringSubmatch(line)
+	if parsedLine == nil {
+		return
+	}
+	currentOomInstance.Constraint = parsedLine[1]
+}
+
+// sets the Scope of an OOM that the kernel did not classify, from its
+// constraint or, on kernels that do not report one, whether a memory cgroup
+// hit its limit. The kernel only tells global OOMs from memcg ones, so a
+// global OOM constrained by a cpuset is then narrowed down to ScopeCpuset.
+func inferScope(currentOomInstance *OomInstance) {
+	if currentOomInstance.Scope == ScopeMemcg {
+		return
+	}
+	if currentOomInstance.Scope == "" {
+		switch {
+		case currentOomInstance.Constraint == ConstraintMemcg:
+			currentOomInstance.Scope = ScopeMemcg
+			return
+		case currentOomInstance.Constraint == "" && currentOomInstance.VictimContainerName != "":
+			currentOomInstance.Scope = ScopeMemcg
+			return
+		}
+		currentOomInstance.Scope = ScopeGlobal
+	}
+	if currentOomInstance.Constraint == ConstraintCpuset {
+		currentOomInstance.Scope = ScopeCpuset
+	}
+}
+
+// returns the name of the cpuset reported by line, if it reports one.
+func getCpuset(line string) (string, bool) {
+	if !strings.Contains(line, "cpuset=") {
+		return "", false
+	}
+	parsedLine := cpusetRegexp.FindStringSubmatch(line)
+	if parsedLine == nil {
+		return "", false
+	}
+	return parsedLine[1], true
+}
+
+// sets the ContainerName of a memcg OOM whose killed task's cgroup was not
+// reported, or was reported as the root, to the cpuset the OOM reported.
+func fallBackToCpuset(cpuset string, currentOomInstance *OomInstance) {
+	if currentOomInstance.ContainerName != "/" || cpuset == "" || cpuset == "/" {
+		return
+	}
+	if currentOomInstance.VictimContainerName == "" && currentOomInstance.Constraint != ConstraintMemcg {
+		// The invoking process of a global OOM may be anywhere.
+		return
+	}
+	currentOomInstance.ContainerName = path.Join("/", cpuset)
+	currentOomInstance.ContainerNameFromCpuset = true
+}
+
+// gets the NUMA nodes reported by kernels older than 4.19 and adds them to the
+// oomInstance: the nodemask from the "invoked oom-killer" line, and the nodes
+// allowed from the "cpuset=" line after it. Newer kernels report both on the
+// "oom-kill:" line instead.
+func getNodes(line string, currentOomInstance *OomInstance) {
+	if parsedLine := nodeMaskRegexp.FindStringSubmatch(line); parsedLine != nil {
+		currentOomInstance.NodeMask = parsedLine[1]
+	}
+	if parsedLine := memsAllowedRegexp.FindStringSubmatch(line); parsedLine != nil {
+		currentOomInstance.MemsAllowed = parsedLine[1]
+	}
+}
+
+// gets the invoking process and allocation details from the "invoked
+// oom-killer" line and adds them to the oomInstance, leaving them unset if the
+// line does not report them.
+func getInvokingTask(line string, currentOomInstance *OomInstance) {
+	currentOomInstance.AllocationOrder = -1
+	for _, invokingProcessRegexp := range invokingProcessRegexps {
+		if parsedLine := invokingProcessRegexp.FindStringSubmatch(line); parsedLine != nil {
+			currentOomInstance.InvokingProcess = parsedLine[1]
+			break
+		}
+	}
+	if parsedLine := allocationOrderRegexp.FindStringSubmatch(line); parsedLine != nil {
+		if order, err := strconv.Atoi(parsedLine[1]); err == nil {
+			currentOomInstance.AllocationOrder = order
+		}
+	}
+	if parsedLine := gfpMaskRegexp.FindStringSubmatch(line); parsedLine != nil {
+		currentOomInstance.GfpMask = parsedLine[1]
+	}
+}
+
+// gets the invoking thread's id from the header of its stack dump, keeping
+// the first found as the kernel may dump other stacks later.
+func getInvokingTid(line string, currentOomInstance *OomInstance) {
+	if currentOomInstance.InvokingTid != 0 || !strings.Contains(line, "CPU: ") {
+		return
+	}
+	if parsedLine := invokingTidRegexp.FindStringSubmatch(line); parsedLine != nil {
+		if pid, err := strconv.Atoi(parsedLine[1]); err == nil {
+			currentOomInstance.InvokingTid = pid
+		}
+	}
+}
+
+func isSelfKill(oomInstance *OomInstance) bool {
+	return oomInstance.InvokingTid != 0 && oomInstance.InvokingTid == oomInstance.VictimGlobalPid
+}
+
+// the usage and limit counters a memcg OOM dumps, in bytes, from which its
+// swap is worked out
+type memcgCounters struct {
+	memoryUsage, memoryLimit uint64
+	haveMemory               bool
+}
+
+// adds a line to the counters if it is one of them, and fills in the swap of
+// the oomInstance once it is known.
+func (self *memcgCounters) addLine(line string, currentOomInstance *OomInstance) {
+	if !strings.Contains(line, ": usage ") {
+		return
+	}
+	parsedLine := memcgCounterRegexp.FindStringSubmatch(line)
+	if parsedLine == nil {
+		return
+	}
+	usage, err := strconv.ParseUint(parsedLine[2], 10, 64)
+	if err != nil {
+		return
+	}
+	limit, err := strconv.ParseUint(parsedLine[4], 10, 64)
+	if err != nil {
+		return
+	}
+	unit := uint64(os.Getpagesize())
+	if parsedLine[3] == "kB" {
+		unit = 1024
+	}
+	unlimited := limit >= unlimitedMemoryBytes/unit
+	usage *= unit
+	limit *= unit
+	switch parsedLine[1] {
+	case "memory":
+		self.memoryUsage = usage
+		self.memoryLimit = limit
+		self.haveMemory = true
+	case "memory+swap":
+		// Without swap accounting the memory+swap counter is not charged.
+		if !self.haveMemory || usage < self.memoryUsage {
+			return
+		}
+		currentOomInstance.SwapUsageBytes = usage - self.memoryUsage
+		currentOomInstance.SwapUnlimited = unlimited
+		if !unlimited && limit > self.memoryLimit {
+			currentOomInstance.SwapLimitBytes = limit - self.memoryLimit
+		}
+	case "swap":
+		currentOomInstance.SwapUsageBytes = usage
+		currentOomInstance.SwapUnlimited = unlimited
+		if !unlimited {
+			currentOomInstance.SwapLimitBytes = limit
+		}
+	}
+}
+
+// gets the memory cgroup limit from a line and adds it to the oomInstance.
+// Depending on the kernel version the limit is printed in kB or in pages.
+// Page counts are converted using the page size of the host reading the log,
+// which is wrong for logs copied from a host with a different page size.
+func getMemoryLimit(line string, currentOomInstance *OomInstance) error {
+	if !mightMatch(memoryLimitRegexp, line) {
+		return nil
+	}
+	parsedLine := memoryLimitRegexp.FindStringSubmatch(line)
+	if parsedLine == nil {
+		return nil
+	}
+	limit, err := strconv.ParseUint(parsedLine[1], 10, 64)
+	if err != nil {
+		return err
+	}
+	unit := uint64(os.Getpagesize())
+	if parsedLine[2] == "kB" {
+		unit = 1024
+	}
+	if limit >= unlimitedMemoryBytes/unit {
+		currentOomInstance.MemoryLimitBytes = 0
+	} else {
+		currentOomInstance.MemoryLimitBytes = limit * unit
+	}
+	return nil
+}
+
+// parses a syslog timestamp such as "Jan  5 15:19:27", which has no year. The
+// year is assumed to be that of now, unless that would put the timestamp more
+// than a day in the future, in which case the message must be from the year
+// before, e.g. a December message being read in January. The same goes for a
+// Feb 29 message when the current year is not a leap year. The timestamp is
+// taken to be in now's location. Its month may be abbreviated in one of the
+// languages of localMonths.
+func parseSyslogTime(timestamp string, now time.Time) (time.Time, error) {
+	if i := strings.IndexByte(timestamp, ' '); i > 0 {
+		if month, ok := localMonths[strings.ToLower(strings.TrimSuffix(timestamp[:i], "."))]; ok {
+			timestamp = month + timestamp[i:]
+		}
+	}
+	return parseYearlessTime("Jan _2 15:04:05", timestamp, now)
+}
+
+// The abbreviated month names that syslog daemons and journalctl write in
+// German, French, Spanish, Italian, Dutch and Portuguese locales, where they
+// differ from the English ones that time.Parse knows, and which month each
+// is. None of them means a different month in another of the languages.
+var localMonths = map[string]string{
+	"ene": "Jan", "janv": "Jan", "gen": "Jan",
+	"févr": "Feb", "fev": "Feb",
+	"mär": "Mar", "mrz": "Mar", "mars": "Mar", "mrt": "Mar",
+	"avr": "Apr", "abr": "Apr",
+	"mai": "May", "mag": "May", "mei": "May",
+	"juin": "Jun", "giu": "Jun",
+	"juil": "Jul", "lug": "Jul",
+	"août": "Aug", "ago": "Aug",
+	"sept": "Sep", "set": "Sep",
+	"okt": "Oct", "ott": "Oct", "out": "Oct",
+	"dez": "Dec", "déc": "Dec", "dic": "Dec",
+}
+
+// parses a timestamp without a year in the given layout, guessing the year and
+// location as parseSyslogTime does.
+func parseYearlessTime(layout string, timestamp string, now time.Time) (time.Time, error) {
+	longForm := layout + " 2006"
+	linetime, err := time.ParseInLocation(longForm, timestamp+" "+strconv.Itoa(now.Year()), now.Location())
+	if err != nil || linetime.After(now.Add(24*time.Hour)) {
+		return time.ParseInLocation(longForm, timestamp+" "+strconv.Itoa(now.Year()-1), now.Location())
+	}
+	return linetime, nil
+}
+
+// parses a journalctl short-iso timestamp such as "2016-03-01T10:11:12+0000".
+// Newer versions of systemd print the zone as "+00:00" instead.
+func parseIsoTime(timestamp string) (time.Time, error) {
+	linetime, err := time.Parse("2006-01-02T15:04:05Z0700", timestamp)
+	if err != nil {
+		return time.Parse(time.RFC3339, timestamp)
+	}
+	return linetime, nil
+}
+
+// gets the pid, name, and date from a line and adds it to oomInstance
+func (self *MatcherSet) getProcessNamePid(line string, now time.Time, currentOomInstance *OomInstance) (bool, error) {
+	var linetime time.Time
+	var err error
+	reList := self.LastLine.FindStringSubmatch(line)
+	if reList != nil {
+		linetime, err = parseSyslogTime(reList[1], now)
+	} else if reList = self.IsoLastLine.FindStringSubmatch(line); reList != nil {
+		linetime, err = parseIsoTime(reList[1])
+	} else {
+		return false, nil
+	}
+	if err != nil {
+		// The OOM is still sent, without its time of death, rather than
+		// being lost to a date in an unknown language.
+		linetime = time.Time{}
+	}
+
+	currentOomInstance.TimeOfDeath = linetime
+	if pidErr := setProcessNamePid(line, reList[2], reList[3], currentOomInstance); err == nil {
+		err = pidErr
+	}
+	return true, err
+}
+
+// gets the pid and name from a /dev/kmsg message, or another line without a
+// date, and adds them to the oomInstance. The message itself has no date, so
+// the time of death is the timestamp from the record's header, if any.
+func (self *MatcherSet) getKmsgProcessNamePid(line string, timestamp time.Time, currentOomInstance *OomInstance) (bool, error) {
+	reList := self.UndatedLastLine.FindStringSubmatch(line)
+	if reList == nil {
+		return false, nil
+	}
+	currentOomInstance.TimeOfDeath = timestamp
+	return true, setProcessNamePid(line, reList[1], reList[2], currentOomInstance)
+}
+
+// adds the pid and name matched from a "Killed process" line, along with the
+// other details the line reports, to the oomInstance. A pid too large to
+// parse is returned as an error, but the rest are still added, leaving the
+// Pid 0, so that the OOM is not lost.
+func setProcessNamePid(line string, pidString string, processName string, currentOomInstance *OomInstance) error {
+	pid, pidErr := strconv.Atoi(pidString)
+	if pidErr != nil {
+		pid = 0
+	}
+	currentOomInstance.Pid = pid
+	currentOomInstance.VictimGlobalPid = pid
+	currentOomInstance.ProcessName = processName
+
+	if adjList := oomScoreAdjRegexp.FindStringSubmatch(line); adjList != nil {
+		oomScoreAdj, err := strconv.Atoi(adjList[1])
+		if err != nil {
+			return err
+		}
+		currentOomInstance.OomScoreAdj = oomScoreAdj
+		currentOomInstance.HasOomScoreAdj = true
+	}
+	return pidErr
+}
+
+// splits a /dev/kmsg record such as
+// "6,1930,5864708440,-;memorymonster invoked oom-killer: ..." into its message
+// and the time it was logged. Lines without a valid header are returned as
+// they are, with a zero time. Returns false for the continuation lines that follow some records, which
+// start with a space and carry key/value metadata rather than a message, and
+// for records written to /dev/kmsg from userspace, which the kernel never
+// gives the kernel's facility. The message's level is left in lastKmsgLevel.
+func (self *OomParser) splitKmsgLine(line string) (string, time.Time, bool) {
+	if strings.HasPrefix(line, " ") {
+		return "", time.Time{}, false
+	}
+	self.lastKmsgLevel = -1
+	record, err := ParseKmsgRecord(line)
+	if err != nil {
+		switch self.MalformedKmsg {
+		case MalformedKmsgSkip:
+			self.logger().Warningf("%v, skipping it", err)
+			return "", time.Time{}, false
+		case MalformedKmsgSkipSilently:
+			return "", time.Time{}, false
+		}
+		self.logger().Warningf("%v, continuing to parse it as is", err)
+		return line, time.Time{}, true
+	}
+	self.checkKmsgSeq(record.Seq)
+	if record.Facility() != kernelFacility {
+		return "", time.Time{}, false
+	}
+	self.lastKmsgLevel = record.Level()
+	return record.Message, self.bootTime.Add(record.Timestamp), true
+}
+
+// replaces each "\xNN" in s with the byte it stands for.
+func unescapeHex(s string) string {
+	if !strings.Contains(s, "\\x") {
+		return s
+	}
+	var unescaped []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) && s[i+1] == 'x' {
+			if b, err := strconv.ParseUint(s[i+2:i+4], 16, 8); err == nil {
+				unescaped = append(unescaped, byte(b))
+				i += 3
+				continue
+			}
+		}
+		unescaped = append(unescaped, s[i])
+	}
+	return string(unescaped)
+}
+
+// the KERN_SOH byte that starts the level of a printk, e.g. "\x013" for
+// KERN_ERR, which some pipelines deliver with the message
+const kernSOH = '\x01'
+
+// removes the printk levels from line: a KERN_SOH followed by a level digit,
+// or "c" for KERN_CONT or "d" for KERN_DEFAULT. A stray KERN_SOH is removed
+// on its own.
+func stripKernSOH(line string) string {
+	if strings.IndexByte(line, kernSOH) < 0 {
+		return line
+	}
+	stripped := make([]byte, 0, len(line))
+	for i := 0; i < len(line); i++ {
+		if line[i] != kernSOH {
+			stripped = append(stripped, line[i])
+			continue
+		}
+		if i+1 < len(line) && (line[i+1] >= '0' && line[i+1] <= '7' || line[i+1] == 'c' || line[i+1] == 'd') {
+			i++
+		}
+	}
+	return string(stripped)
+}
+
+// UnescapeSystemdPath undoes systemd's escaping of the unit names in a cgroup
+// path, e.g. "/machine.slice/machine-qemu\x2d1\x2dvm.scope" becomes
+// "/machine.slice/machine-qemu-1-vm.scope". OOMs report the paths escaped, as
+// that is how they are named in the cgroup filesystem; this can be used as an
+// OomParser's NormalizeContainerName to report unit names instead.
+func UnescapeSystemdPath(cgroupPath string) string {
+	return unescapeHex(cgroupPath)
+}
+
+// LOG_KERN from <syslog.h>
+const kernelFacility = 0
+
+// reports whether a syslog line was logged by a program other than the kernel,
+// e.g. "Jan  5 15:20:01 host CRON[14608]: ...". Programs can log anything, so
+// such lines must not be mistaken for part of an OOM. Lines without a syslog
+// tag, such as dmesg's, are assumed to be the kernel's.
+func isFromOtherProgram(line string) bool {
+	parsedLine := syslogTagRegexp.FindStringSubmatch(line)
+	return parsedLine != nil && parsedLine[1] != "kernel"
+}
+
+// notes the sequence number of a kmsg record, warning if records before it
+// were overwritten in the ring buffer before they could be read.
+func (self *OomParser) checkKmsgSeq(seq uint64) {
+	if self.haveKmsgSeq && seq > self.lastKmsgSeq+1 {
+		lost := seq - self.lastKmsgSeq - 1
+		total := atomic.AddUint64(&self.lostKmsgRecs, lost)
+		self.logger().Warningf("kernel log records lost: count=%d after_seq=%d next_seq=%d total=%d. OOM events may have been missed.", lost, self.lastKmsgSeq, seq, total)
+	}
+	self.lastKmsgSeq = seq
+	self.haveKmsgSeq = true
+}
+
+// gets the pid, name, and time of death from a line, preferring the kmsg
+// header's timestamp over the line's own date when there is one. Lines with
+// neither, such as dmesg's, leave the time of death zero.
+func (self *OomParser) findProcessNamePid(line string, lineTime time.Time, currentOomInstance *OomInstance) (bool, error) {
+	matchers := self.matchers()
+	if !mightMatch(matchers.UndatedLastLine, line) {
+		return false, nil
+	}
+	if self.kmsg && !lineTime.IsZero() {
+		return matchers.getKmsgProcessNamePid(line, lineTime, currentOomInstance)
+	}
+	finished, err := matchers.getProcessNamePid(line, self.logNow(), currentOomInstance)
+	if finished || err != nil {
+		return finished, err
+	}
+	return matchers.getKmsgProcessNamePid(line, time.Time{}, currentOomInstance)
+}
+
+// reports whether line says that the OOM killer found no process to kill,
+// setting the oomInstance's NoKillableProcesses and its time of death from
+// the line's date, or the timestamp of its /dev/kmsg record.
+func (self *OomParser) findNoKillableProcesses(line string, lineTime time.Time, currentOomInstance *OomInstance) (bool, error) {
+	if !strings.Contains(line, "no killable processes") {
+		return false, nil
+	}
+	parsedLine := noKillableRegexp.FindStringSubmatch(line)
+	if parsedLine == nil {
+		return false, nil
+	}
+	currentOomInstance.NoKillableProcesses = true
+	var err error
+	switch {
+	case self.kmsg && !lineTime.IsZero():
+		currentOomInstance.TimeOfDeath = lineTime
+	case parsedLine[1] != "":
+		currentOomInstance.TimeOfDeath, err = parseSyslogTime(parsedLine[1], self.logNow())
+	case parsedLine[2] != "":
+		currentOomInstance.TimeOfDeath, err = parseIsoTime(parsedLine[2])
+	}
+	if err != nil {
+		currentOomInstance.TimeOfDeath = time.Time{}
+	}
+	return true, err
+}
+
+// returns the current time in the parser's Location, which the dates in the
+// log are parsed in.
+func (self *OomParser) logNow() time.Time {
+	if self.Location == nil {
+		return self.nowFunc().In(time.Local)
+	}
+	return self.nowFunc().In(self.Location)
+}
+
+func (self *OomParser) matchers() *MatcherSet {
+	if self.Matchers == nil {
+		return &DefaultMatchers
+	}
+	return self.Matchers
+}
+
+// uses regex to see if line is the start of a kernel oom log
+func (self *MatcherSet) checkIfStartOfOomMessages(line string) bool {
+	return mightMatch(self.FirstLine, line) && self.FirstLine.MatchString(line)
+}
+
+// reports whether re might match line, by whether line contains the literal
+// text every match of re starts with. It is much cheaper than matching re, so
+// is checked first as most lines of the kernel log are not part of an OOM.
+func mightMatch(re *regexp.Regexp, line string) bool {
+	prefix, _ := re.LiteralPrefix()
+	return strings.Contains(line, prefix)
+}
+
+// reads the file and sends only complete lines over a channel to analyzeLines.
+// Should prevent EOF errors that occur when lines are read before being fully
+// written to the log. It reads line by line splitting on
+// the "\n" character. Reaching the end of the file only stops reading if follow
+// is false; otherwise it waits for more to be written. Returns why reading
+// stopped: a read error, io.EOF, or ctx's error if it was cancelled. lineRead
+// is called as each line is read, whole or not.
+func readLinesFromFile(ctx context.Context, lineChannel chan<- string, ioreader *bufio.Reader, follow bool, logger Logger, lineRead func()) error {
+	linefragment := ""
+	var line string
+	var err error
+	for {
+		line, err = ioreader.ReadString('\n')
+		if isKmsgOverrun(err) {
+			// The kernel has moved the reader on to the oldest record it
+			// still has, so carry on from there.
+			logger.Warningf("kernel log messages were overwritten before they were read, OOM events may have been missed")
+			linefragment = ""
+			continue
+		}
+		if err != nil && err != io.EOF {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			logger.Errorf("exiting analyzeLinesHelper with error %v", err)
+			return err
+		}
+		if line != "" {
+			lineRead()
+		}
+		if err == io.EOF && !follow {
+			if linefragment+line != "" {
+				select {
+				case lineChannel <- linefragment + line:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			return err
+		}
+		if line == "" {
+			select {
+			case <-time.After(100 * time.Millisecond):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			continue
+		}
+		if err == nil {
+			select {
+			case lineChannel <- linefragment + line:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			linefragment = ""
+		} else { // err == io.EOF
+			linefragment += line
+		}
+	}
+}
+
+// reports whether err is the EPIPE returned by reads of /dev/kmsg when records
+// were overwritten in the ring buffer before they could be read.
+func isKmsgOverrun(err error) bool {
+	if pathErr, ok := err.(*os.PathError); ok {
+		err = pathErr.Err
+	}
+	return err == syscall.EPIPE
+}
+
+// Calls goroutine for readLinesFromFile, which feeds it complete lines.
+// Lines are checked against a regexp to check for the pid, process name, etc.
+// At the end of an oom message group, StreamOoms adds the new oomInstance to
+// oomLog. StreamOoms returns when the source ends or fails, see Err. Read
+// errors on /dev/kmsg instead cause it to be reopened, see MaxReconnectBackoff.
+//
+// Each OOM is sent as soon as it is parsed, waiting for outStream to take it,
+// as Overflow defaults to OverflowBlock. While it waits, up to StagedLines
+// more lines are read from the source, and then reading stops too, which
+// risks /dev/kmsg overwriting records before they are read. A consumer that
+// may stall should buffer outStream, see NewBufferedOomChannel, or set
+// Overflow to drop OOMs rather than wait once its buffer is full.
+func (self *OomParser) StreamOoms(outStream chan *OomInstance) {
+	self.StreamOomsContext(context.Background(), outStream)
+}
+
+// NewBufferedOomChannel returns a channel for StreamOoms that buffers size
+// OOMs, so that the stream is not held up while its consumer is busy with up
+// to that many. OOMs come in bursts: a memory.oom.group kill sends an OOM
+// for every process in the group at once, and a workload restarting into its
+// limit is killed again within seconds, so size it for the most processes a
+// group kill may take, or tens if there are none.
+func NewBufferedOomChannel(size int) chan *OomInstance {
+	return make(chan *OomInstance, size)
+}
+
+// StreamOomsContext behaves like StreamOoms, but returns once ctx is
+// cancelled. Cancelling ctx closes the parser's underlying source to unblock
+// any pending read, so the parser cannot be streamed from again afterwards.
+// Nothing is written to outStream once ctx is done, and outStream is not
+// closed by the parser unless CloseStreamOnExit is set. It returns the error
+// that ended the stream, as reported by Err.
+func (self *OomParser) StreamOomsContext(ctx context.Context, outStream chan<- *OomInstance) error {
+	return self.streamOoms(ctx, outStream, nil, nil, nil)
+}
+
+// StreamOomsWithErrors behaves like StreamOoms, but also sends the errors hit
+// while parsing individual lines to errs, and carries on scanning. Parsing
+// blocks until each error is received, so errs should be drained or buffered.
+// errs may be nil, in which case errors are only logged, as with StreamOoms.
+// It returns the error that ended the stream, as reported by Err.
+func (self *OomParser) StreamOomsWithErrors(outStream chan<- *OomInstance, errs chan<- error) error {
+	return self.streamOoms(context.Background(), outStream, errs, nil, nil)
+}
+
+// ContainerFilter selects the OOMs that StreamOomsFiltered sends by the
+// container they happened in.
+type ContainerFilter struct {
+	// Prefixes of the ContainerNames to send, matched a path component at a
+	// time, so "/kubepods" matches "/kubepods/pod1" but not "/kubepodsx".
+	Prefixes []string
+	// IncludeGlobal sends the OOMs not attributed to any container, whose
+	// ContainerName is "/", whatever the Prefixes.
+	IncludeGlobal bool
+}
+
+// matches reports whether the filter selects oomInstance.
+func (self ContainerFilter) matches(oomInstance *OomInstance) bool {
+	if oomInstance.ContainerName == "/" {
+		return self.IncludeGlobal
+	}
+	for _, prefix := range self.Prefixes {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if oomInstance.ContainerName == prefix || strings.HasPrefix(oomInstance.ContainerName, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// StreamOomsFiltered behaves like StreamOoms, but only sends the OOMs selected
+// by filter. It returns the error that ended the stream, as reported by Err.
+func (self *OomParser) StreamOomsFiltered(outStream chan<- *OomInstance, filter ContainerFilter) error {
+	return self.streamOoms(context.Background(), outStream, nil, filter.matches, nil)
+}
+
+// Next returns the next OOM read, or the error that ended the stream, see Err.
+// If ctx is done first it returns ctx's error, and the OOM is returned by the
+// next call instead. The first call starts streaming from the parser in the
+// background, so Next cannot be used along with StreamOoms or its variants,
+// which return an error if called while a stream is running.
+func (self *OomParser) Next(ctx context.Context) (*OomInstance, error) {
+	self.nextOnce.Do(func() {
+		self.nextStream = make(chan *OomInstance)
+		self.nextDone = make(chan struct{})
+		go func() {
+			self.nextErr = self.streamOoms(context.Background(), self.nextStream, nil, nil, nil)
+			close(self.nextDone)
+		}()
+	})
+	select {
+	case oomInstance, ok := <-self.nextStream:
+		if ok {
+			return oomInstance, nil
+		}
+		// Closed as CloseStreamOnExit is set.
+		<-self.nextDone
+		return nil, self.nextErr
+	case <-self.nextDone:
+		return nil, self.nextErr
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// OnOom streams from the parser like StreamOomsContext, but calls handler with
+// each OOM rather than sending it to a channel, until ctx is done or the
+// stream ends. handler is called from the goroutine OnOom was called from, one
+// OOM at a time, and the stream waits for it as it would for a consumer of
+// its channel. It returns the error that ended the stream, as reported by Err.
+func (self *OomParser) OnOom(ctx context.Context, handler func(*OomInstance)) error {
+	outStream := make(chan *OomInstance)
+	done := make(chan error, 1)
+	go func() {
+		done <- self.streamOoms(ctx, outStream, nil, nil, nil)
+	}()
+	for {
+		select {
+		case oomInstance, ok := <-outStream:
+			if !ok {
+				// Closed as CloseStreamOnExit is set.
+				return <-done
+			}
+			if ctx.Err() != nil {
+				// The stream may still send an OOM after ctx is done, as
+				// the send does not wait for ctx first.
+				return <-done
+			}
+			handler(oomInstance)
+		case err := <-done:
+			// Every OOM sent was received before the stream ended.
+			return err
+		}
+	}
+}
+
+// Err returns the error that ended the most recent stream: io.EOF if the source
+// ran out, the read error if reading it failed, or the context's error if the
+// stream was cancelled. It returns nil while a stream is running.
+func (self *OomParser) Err() error {
+	self.streamLock.Lock()
+	defer self.streamLock.Unlock()
+	return self.streamErr
+}
+
+// streams the OOMs read until the source ends or ctx is done. Parse errors go
+// to errs if it is not nil, and only the OOMs accepted by filter are sent if it
+// is not nil.
+func (self *OomParser) streamOoms(ctx context.Context, outStream chan<- *OomInstance, errs chan<- error, filter func(*OomInstance) bool, events chan<- OomEvent) (err error) {
+	// When streaming events, the OOMs are sent to events rather than
+	// outStream.
+	closeStream := func() {
+		if events != nil {
+			close(events)
+		} else {
+			close(outStream)
+		}
+	}
+	self.streamLock.Lock()
+	if self.streaming {
+		self.streamLock.Unlock()
+		err = fmt.Errorf("the parser is already being streamed from")
+		self.logger().Errorf("%v", err)
+		if self.CloseStreamOnExit {
+			closeStream()
+		}
+		return err
+	}
+	self.streaming = true
+	self.streamErr = nil
+	self.streamLock.Unlock()
+	if self.AnnotateBootID {
+		self.bootIDOnce.Do(func() {
+			bootID, err := readBootID()
+			if err != nil {
+				self.logger().Warningf("unable to read the boot id to annotate OOMs with: %v", err)
+				return
+			}
+			self.bootID = bootID
+		})
+	}
+
+	var lineChannel chan string
+	var readErr error
+	// starts reading lines from the current source into lineChannel, which
+	// is closed once reading it ends.
+	stagedLines := self.StagedLines
+	if stagedLines <= 0 {
+		stagedLines = defaultStagedLines
+	} else if stagedLines > maxStagedLines {
+		stagedLines = maxStagedLines
+	}
+	startReading := func() {
+		lineChannel = make(chan string, stagedLines)
+		go func(lineChannel chan<- string) {
+			for {
+				ioreader := self.ioreader
+				if self.ReadTimeout > 0 {
+					ioreader = bufio.NewReader(newTimeoutReader(self.ioreader, self.source, self.ReadTimeout))
+				}
+				readErr = readLinesFromFile(ctx, lineChannel, ioreader, self.follow, self.logger(), self.markRead)
+				if readErr == io.EOF || ctx.Err() != nil || !self.reconnect(ctx, readErr) {
+					break
+				}
+			}
+			close(lineChannel)
+		}(lineChannel)
+	}
+	startReading()
+	defer func() {
+		if ctx.Err() != nil {
+			// Close here too rather than relying on the watcher below, so
+			// that the source is closed by the time we return.
+			self.Close()
+			err = ctx.Err()
+		} else {
+			// lineChannel was closed, so the reader is done.
+			err = readErr
+		}
+		self.logger().Infof("exiting analyzeLines with %v. OOM events will not be reported.", err)
+		self.streamLock.Lock()
+		self.streamErr = err
+		self.streaming = false
+		self.streamLock.Unlock()
+		if self.CloseStreamOnExit {
+			closeStream()
+		}
+	}()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			self.Close()
+		case <-done:
+		}
+	}()
+
+	sendEvent := func(event OomEvent) {
+		select {
+		case events <- event:
+		case <-ctx.Done():
+		}
+	}
+	sendOom := func(oomInstance *OomInstance) {
+		select {
+		case outStream <- oomInstance:
+		case <-ctx.Done():
+		}
+	}
+	if events != nil {
+		sendOom = func(oomInstance *OomInstance) {
+			sendEvent(oomInstance)
+		}
+	} else if self.Overflow != OverflowBlock {
+		// Dropped OOMs still take an EventSeq, so consumers can tell that
+		// they are missing.
+		queue := newOverflowQueue(self.Overflow, self.OverflowBufferSize, self.logger())
+		queue.dropped = &self.counters.dropped
+		sent := make(chan struct{})
+		go func() {
+			queue.send(ctx, outStream)
+			close(sent)
+		}()
+		defer func() {
+			queue.close()
+			<-sent
+		}()
+		sendOom = queue.add
+	}
+	send := func(oomInstance *OomInstance) {
+		atomic.AddUint64(&self.counters.sent, 1)
+		atomic.StoreInt64(&self.counters.lastSent, self.nowFunc().UnixNano())
+		sendOom(oomInstance)
+	}
+
+	var heartbeat <-chan time.Time
+	if self.Heartbeat != nil && self.HeartbeatInterval > 0 {
+		ticker := time.NewTicker(self.HeartbeatInterval)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+
+	var pacer *replayPacer
+	if self.replaySpeed > 0 {
+		pacer = newReplayPacer(self.replaySpeed)
+	}
+	// holds line back until it is due, when replaying with timing, returning
+	// false if ctx is done first.
+	pace := func(line string) bool {
+		if pacer == nil {
+			return true
+		}
+		timestamp, ok := lineTimestamp(line)
+		if !ok {
+			return true
+		}
+		delay := pacer.delay(timestamp)
+		if delay <= 0 {
+			return true
+		}
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		for {
+			select {
+			case now := <-heartbeat:
+				self.Heartbeat(now)
+			case <-timer.C:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+	}
+
+	// The OOM in progress that a Partial copy of is to be sent when
+	// partialDue fires, if FlushPartialAfter is set. Set up by nextLine, as
+	// it is called by parseLines, so sees the OOM it is parsing.
+	var flushing *OomInstance
+	var partialTimer *time.Timer
+	var partialDue <-chan time.Time
+	defer func() {
+		if partialTimer != nil {
+			partialTimer.Stop()
+		}
+	}()
+	var flushPartial func(*OomInstance)
+	nextLine := func() (string, time.Time, bool) {
+		if self.FlushPartialAfter > 0 && self.inProgress != flushing {
+			flushing = self.inProgress
+			if partialTimer != nil {
+				partialTimer.Stop()
+			}
+			partialDue = nil
+			if flushing != nil {
+				partialTimer = time.NewTimer(self.FlushPartialAfter)
+				partialDue = partialTimer.C
+			}
+		}
+		for {
+			select {
+			case <-partialDue:
+				partialDue = nil
+				partial := *flushing
+				partial.Partial = true
+				// The dump goes on appending to its own RawLines.
+				partial.RawLines = partial.RawLines[:len(partial.RawLines):len(partial.RawLines)]
+				partial.IsGlobal = partial.VictimContainerName == ""
+				partial.RootMemcgKill = partial.VictimContainerName == "/"
+				inferScope(&partial)
+				flushPartial(&partial)
+			case now := <-heartbeat:
+				self.Heartbeat(now)
+			case line, ok := <-lineChannel:
+				if ok && !pace(line) {
+					return "", time.Time{}, false
+				}
+				if !ok || !self.kmsg {
+					return line, time.Time{}, ok
+				}
+				message, lineTime, isMessage := self.splitKmsgLine(line)
+				if isMessage {
+					return message, lineTime, true
+				}
+			case <-ctx.Done():
+				return "", time.Time{}, false
+			}
+		}
+	}
+
+	var metrics Metrics = noopMetrics{}
+	if self.Metrics != nil {
+		metrics = self.Metrics
+	}
+	rateLimitMetrics, _ := metrics.(RateLimitMetrics)
+	metrics = countingMetrics{metrics, &self.counters}
 
-// struct that contains information related to an OOM kill instance
-type OomInstance struct {
-	// process id of the killed process
-	Pid int
-	// the name of the killed process
-	ProcessName string
-	// the time that the process was reported to be killed,
-	// accurate to the minute
-	TimeOfDeath time.Time
-	// the absolute name of the container that OOMed
-	ContainerName string
-	// the absolute name of the container that was killed
-	// due to the OOM.
-	VictimContainerName string
-}
-
-// gets the container name from a line and adds it to the oomInstance.
-func getContainerName(line string, currentOomInstance *OomInstance) error {
-	parsedLine := containerRegexp.FindStringSubmatch(line)
-	if parsedLine == nil {
-		return nil
+	reportError := func(line string, err error) {
+		metrics.IncParseError()
+		if events != nil {
+			sendEvent(&OomParseError{Line: strings.TrimSuffix(line, "\n"), Err: err})
+			return
+		}
+		err = fmt.Errorf("failed to parse %q: %v", line, err)
+		if errs == nil {
+			self.logger().Errorf("%v", err)
+			return
+		}
+		select {
+		case errs <- err:
+		case <-ctx.Done():
+		}
+	}
+
+	var limiter *rateLimiter
+	if self.RateLimit > 0 {
+		limiter = newRateLimiter(self.RateLimit, self.RateLimitBurst)
+		limiter.now = self.nowFunc
+	}
+	// The number of OOMs suppressed since one was last sent, and the last of
+	// them if they are being coalesced.
+	suppressed := 0
+	var coalesced *OomInstance
+	flushCoalesced := func() {
+		if suppressed > 0 {
+			self.logger().Warningf("suppressed %d OOMs over the rate limit", suppressed)
+		}
+		if coalesced != nil {
+			send(coalesced)
+		}
+		suppressed = 0
+		coalesced = nil
+	}
+
+	// sends the OOMs parsed that get past the filters, and Partial copies of
+	// the OOMs in progress.
+	process := func(oomInstance *OomInstance) {
+		if self.MaxHistoryAge > 0 && oomInstance.Historical && oomInstance.TimeOfDeath.Before(self.historyEnd.Add(-self.MaxHistoryAge)) {
+			atomic.AddUint64(&self.skippedHistorical, 1)
+			return
+		}
+		if self.DropGlobalOoms && oomInstance.IsGlobal {
+			return
+		}
+		if filter != nil && !filter(oomInstance) {
+			return
+		}
+		if self.ResolveCgroup {
+			cgroupRoot := self.CgroupRoot
+			if cgroupRoot == "" {
+				cgroupRoot = defaultCgroupRoot
+			}
+			resolveCgroup(cgroupRoot, oomInstance)
+		}
+		if self.NormalizeContainerName != nil {
+			// The OOM itself is kept as parsed, as the members of its
+			// group are made from it.
+			normalized := *oomInstance
+			normalized.ContainerName = self.NormalizeContainerName(oomInstance.ContainerName)
+			normalized.VictimContainerName = self.NormalizeContainerName(oomInstance.VictimContainerName)
+			oomInstance = &normalized
+		}
+		if self.AnnotateBootID {
+			oomInstance.BootID = self.bootID
+		}
+		// Suppressed OOMs still take an EventSeq, like dropped ones.
+		oomInstance.EventSeq = atomic.AddUint64(&self.eventSeq, 1)
+		if limiter != nil {
+			if !limiter.allow() {
+				atomic.AddUint64(&self.counters.rateLimited, 1)
+				if rateLimitMetrics != nil {
+					rateLimitMetrics.IncRateLimited()
+				}
+				if suppressed == 0 {
+					self.logger().Warningf("suppressing OOMs over the rate limit of %v a second, starting with %v", self.RateLimit, oomInstance)
+				}
+				suppressed++
+				if self.CoalesceRateLimited {
+					oomInstance.Coalesced = suppressed
+					coalesced = oomInstance
+				}
+				return
+			}
+			flushCoalesced()
+		}
+		send(oomInstance)
+	}
+	flushPartial = process
+	emit := func(oomInstance *OomInstance) {
+		metrics.IncParsed()
+		process(oomInstance)
+	}
+
+	var started func(*OomStart)
+	if events != nil {
+		started = func(oomStart *OomStart) {
+			sendEvent(oomStart)
+		}
+	}
+
+	for {
+		self.parseLines(nextLine, started, emit, reportError, metrics)
+		// Unless ctx is done, lineChannel was closed, so nothing is reading
+		// the source.
+		if ctx.Err() != nil || readErr == io.EOF || !self.failOver(readErr) {
+			break
+		}
+		startReading()
 	}
-	currentOomInstance.ContainerName = path.Join("/", parsedLine[1])
-	currentOomInstance.VictimContainerName = path.Join("/", parsedLine[2])
+	flushCoalesced()
 	return nil
 }
 
-// gets the pid, name, and date from a line and adds it to oomInstance
-func getProcessNamePid(line string, currentOomInstance *OomInstance) (bool, error) {
-	reList := lastLineRegexp.FindStringSubmatch(line)
+// ParseAll returns every OOM in the kernel log lines read from in, such as a
+// saved dmesg or syslog dump, reading until it ends. The lines are parsed just
+// as StreamOoms would, but without starting any goroutines. Lines that fail to
+// parse are logged and skipped; the error is only for failing to read in.
+func ParseAll(in io.Reader) ([]*OomInstance, error) {
+	parser := NewFromReader(in)
+	nextLine, readErr := parser.readAll()
+	var oomInstances []*OomInstance
+	emit := func(oomInstance *OomInstance) {
+		oomInstance.EventSeq = uint64(len(oomInstances) + 1)
+		oomInstances = append(oomInstances, oomInstance)
+	}
+	reportError := func(line string, err error) {
+		parser.logger().Errorf("failed to parse %q: %v", line, err)
+	}
+	parser.parseLines(nextLine, nil, emit, reportError, noopMetrics{})
+	if err := readErr(); err != io.EOF {
+		return oomInstances, err
+	}
+	return oomInstances, nil
+}
 
-	if reList == nil {
-		return false, nil
+// ParseOomBlock parses the lines of a single OOM, from the "invoked
+// oom-killer" line through the one reporting the process killed, as
+// StreamOoms would, e.g. a dump captured for the tests of a consumer. The
+// lines are as logged to syslog, journald or dmesg, with or without their
+// newlines. If the OOM was a memory.oom.group kill, the process it was
+// invoked for is returned, and the rest of its group ignored.
+//
+// An error is returned if the lines do not hold exactly one OOM, or if it
+// ends before the process killed is reported. If a line after the start
+// fails to parse, the error is returned along with the OOM parsed from the
+// lines that did.
+func ParseOomBlock(lines []string) (*OomInstance, error) {
+	parser := &OomParser{nowFunc: time.Now}
+	matchers := parser.matchers()
+	block := make([]string, len(lines))
+	start := -1
+	starts := 0
+	for i, line := range lines {
+		block[i] = stripKernSOH(strings.TrimSuffix(line, "\n") + "\n")
+		if matchers.checkIfStartOfOomMessages(block[i]) {
+			if start < 0 {
+				start = i
+			}
+			starts++
+		}
 	}
-	const longForm = "Jan _2 15:04:05 2006"
-	stringYear := strconv.Itoa(time.Now().Year())
-	linetime, err := time.ParseInLocation(longForm, reList[1]+" "+stringYear, time.Local)
-	if err != nil {
-		return false, err
+	switch {
+	case starts == 0:
+		return nil, fmt.Errorf("no OOM found in %d lines", len(lines))
+	case starts > 1:
+		return nil, fmt.Errorf("expected the lines of one OOM, found %d", starts)
+	}
+	next := start + 1
+	nextLine := func() (string, time.Time, bool) {
+		if next >= len(block) {
+			return "", time.Time{}, false
+		}
+		next++
+		return block[next-1], time.Time{}, true
+	}
+	var parseErr error
+	reportError := func(line string, err error) {
+		if parseErr == nil {
+			parseErr = fmt.Errorf("failed to parse %q: %v", strings.TrimSuffix(line, "\n"), err)
+		}
+	}
+	// With only the one start, the dump can only end early at MaxOomLines,
+	// and the lines after it are ignored like the rest of a group kill.
+	pushBack := func(string, time.Time) {}
+	oomInstance, _ := parser.parseOomBlock(block[start], time.Time{}, nextLine, pushBack, nil, nil, reportError)
+	if oomInstance == nil {
+		return nil, fmt.Errorf("the OOM ended before the process killed was reported")
 	}
+	oomInstance.EventSeq = 1
+	return oomInstance, parseErr
+}
 
-	currentOomInstance.TimeOfDeath = linetime
-	pid, err := strconv.Atoi(reList[2])
-	if err != nil {
-		return false, err
+// returns a nextLine for parseLines that reads the lines of the parser's
+// source in the calling goroutine until it ends, and a func returning the
+// error that ended it.
+func (self *OomParser) readAll() (func() (string, time.Time, bool), func() error) {
+	var readErr error
+	nextLine := func() (string, time.Time, bool) {
+		if readErr != nil {
+			return "", time.Time{}, false
+		}
+		line, err := self.ioreader.ReadString('\n')
+		if line != "" {
+			self.markRead()
+		}
+		if err != nil {
+			readErr = err
+			return line, time.Time{}, line != ""
+		}
+		return line, time.Time{}, true
 	}
-	currentOomInstance.Pid = pid
-	currentOomInstance.ProcessName = reList[3]
-	return true, nil
+	return nextLine, func() error { return readErr }
 }
 
-// uses regex to see if line is the start of a kernel oom log
-func checkIfStartOfOomMessages(line string) bool {
-	potential_oom_start := firstLineRegexp.MatchString(line)
-	if potential_oom_start {
-		return true
+// parses the OOMs in the lines returned by nextLine until it returns false,
+// passing each to emit, and the lines that fail to parse to reportError.
+// started, if not nil, is called as each OOM starts.
+func (self *OomParser) parseLines(nextLine func() (string, time.Time, bool), started func(*OomStart), emit func(*OomInstance), reportError func(string, error), metrics Metrics) {
+	// The matchers do not expect printk levels in the lines.
+	readLine := nextLine
+	nextLine = func() (string, time.Time, bool) {
+		line, lineTime, ok := readLine()
+		return stripKernSOH(line), lineTime, ok
+	}
+
+	contextBefore := self.ContextBefore
+	if contextBefore > maxContextLines {
+		contextBefore = maxContextLines
+	}
+	contextAfter := self.ContextAfter
+	if contextAfter > maxContextLines {
+		contextAfter = maxContextLines
+	}
+	// The last contextBefore lines read, along with the current one.
+	var recent []string
+	// returns a copy of the lines read before the current one.
+	linesBefore := func() []string {
+		if len(recent) <= 1 {
+			return nil
+		}
+		return append([]string(nil), recent[:len(recent)-1]...)
+	}
+	// The OOMs emitted that are held back until contextAfter lines have
+	// followed them, in the order they were emitted.
+	var held []*OomInstance
+	if contextAfter > 0 {
+		emitNow := emit
+		emit = func(oomInstance *OomInstance) {
+			held = append(held, oomInstance)
+		}
+		// Once the lines run out, the OOMs still held are sent with those
+		// that followed them.
+		defer func() {
+			for _, oomInstance := range held {
+				emitNow(oomInstance)
+			}
+		}()
+		readContext := nextLine
+		nextLine = func() (string, time.Time, bool) {
+			line, lineTime, ok := readContext()
+			if !ok {
+				return line, lineTime, ok
+			}
+			for _, oomInstance := range held {
+				oomInstance.ContextAfter = append(oomInstance.ContextAfter, strings.TrimSuffix(line, "\n"))
+			}
+			for len(held) > 0 && len(held[0].ContextAfter) >= contextAfter {
+				emitNow(held[0])
+				held = held[1:]
+			}
+			return line, lineTime, ok
+		}
+	}
+	if contextBefore > 0 {
+		readContext := nextLine
+		nextLine = func() (string, time.Time, bool) {
+			line, lineTime, ok := readContext()
+			if !ok {
+				return line, lineTime, ok
+			}
+			if len(recent) > contextBefore {
+				recent = append(recent[:0], recent[1:]...)
+			}
+			recent = append(recent, strings.TrimSuffix(line, "\n"))
+			return line, lineTime, ok
+		}
+	}
+
+	matchers := self.matchers()
+	// The last OOM sent, and the rest of its group if it was a group kill.
+	var lastOom *OomInstance
+	var lastTable taskTable
+	groupName := ""
+	// A line that ended an unfinished dump, to be parsed again as the start
+	// of the next OOM, or as a line outside any dump.
+	var pushedBack bool
+	var pushedLine string
+	var pushedTime time.Time
+	readUnparsed := nextLine
+	nextLine = func() (string, time.Time, bool) {
+		if pushedBack {
+			pushedBack = false
+			return pushedLine, pushedTime, true
+		}
+		return readUnparsed()
+	}
+	pushBack := func(line string, lineTime time.Time) {
+		pushedBack = true
+		pushedLine = line
+		pushedTime = lineTime
+	}
+	for line, lineTime, ok := nextLine(); ok; line, lineTime, ok = nextLine() {
+		if self.lmkd {
+			oomInstance, err := getLmkdKill(line, lineTime, self.logNow())
+			if err != nil {
+				reportError(line, err)
+			}
+			if oomInstance != nil {
+				self.keepRawLine(line, oomInstance)
+				oomInstance.ContextBefore = linesBefore()
+				emit(oomInstance)
+			} else {
+				metrics.IncUnrecognizedLine()
+			}
+			continue
+		}
+		in_oom_kernel_log := matchers.checkIfStartOfOomMessages(line)
+		if in_oom_kernel_log {
+			groupName = ""
+			oomInstance, table := self.parseOomBlock(line, lineTime, nextLine, pushBack, linesBefore(), started, reportError)
+			if oomInstance == nil {
+				// The source ended partway through the dump, before the
+				// victim was reported.
+				break
+			}
+			lastOom = oomInstance
+			lastTable = table
+			emit(oomInstance)
+		} else if name, ok := getOomGroup(line); ok && lastOom != nil {
+			groupName = name
+		} else if groupName != "" {
+			member, err := self.getGroupMember(line, lineTime, lastOom, groupName, &lastTable)
+			if err != nil {
+				reportError(line, err)
+			}
+			if member != nil {
+				emit(member)
+			} else {
+				metrics.IncUnrecognizedLine()
+			}
+		} else {
+			metrics.IncUnrecognizedLine()
+		}
 	}
-	return false
 }
 
-// reads the file and sends only complete lines over a channel to analyzeLines.
-// Should prevent EOF errors that occur when lines are read before being fully
-// written to the log. It reads line by line splitting on
-// the "\n" character.
-func readLinesFromFile(lineChannel chan string, ioreader *bufio.Reader) error {
-	linefragment := ""
-	var line string
-	var err error
-	for true {
-		line, err = ioreader.ReadString('\n')
-		if err != nil && err != io.EOF {
-			glog.Errorf("exiting analyzeLinesHelper with error %v", err)
-			close(lineChannel)
+// parses the dump of the OOM started by startLine, reading the rest of its
+// lines with nextLine and passing those that fail to parse to reportError.
+// nil is returned if the lines run out before the process killed is
+// reported. A line read that ends the dump without being part of it is
+// passed to pushBack, to be parsed again. The victim's table of tasks is
+// returned along with the OOM, for the rest of a memory.oom.group kill.
+func (self *OomParser) parseOomBlock(startLine string, startTime time.Time, nextLine func() (string, time.Time, bool), pushBack func(string, time.Time), contextBefore []string, started func(*OomStart), reportError func(string, error)) (*OomInstance, taskTable) {
+	maxOomLines := self.MaxOomLines
+	if maxOomLines <= 0 {
+		maxOomLines = defaultMaxOomLines
+	}
+	topConsumers := self.TopConsumers
+	if topConsumers > maxTopConsumers {
+		topConsumers = maxTopConsumers
+	}
+	matchers := self.matchers()
+	var table taskTable
+	oomInstance := &OomInstance{
+		ContainerName: "/",
+		VictimUID:     -1,
+		LogLevel:      -1,
+		ContextBefore: contextBefore,
+	}
+	if self.kmsg {
+		oomInstance.LogLevel = self.lastKmsgLevel
+	}
+	self.inProgress = oomInstance
+	self.keepRawLine(startLine, oomInstance)
+	getConstraint(startLine, oomInstance)
+	getInvokingTask(startLine, oomInstance)
+	getNodes(startLine, oomInstance)
+	if started != nil {
+		started(&OomStart{
+			InvokingProcess: oomInstance.InvokingProcess,
+			AllocationOrder: oomInstance.AllocationOrder,
+			GfpMask:         oomInstance.GfpMask,
+			Time:            startTime,
+		})
+	}
+	var stats cgroupStats
+	var counters memcgCounters
+	cpuset := ""
+	finished := false
+	linesRead := 0
+	for line, lineTime, ok := nextLine(); ok; line, lineTime, ok = nextLine() {
+		if !self.kmsg && isFromOtherProgram(line) {
+			continue
+		}
+		if matchers.checkIfStartOfOomMessages(line) {
+			// The rest of the dump was lost, e.g. to /dev/kmsg records
+			// being overwritten.
+			self.logger().Warningf("another OOM started before a killed process was found after %q, sending what was found", startLine)
+			oomInstance.Partial = true
+			finished = true
+			pushBack(line, lineTime)
 			break
 		}
-		if line == "" {
-			time.Sleep(100 * time.Millisecond)
-			continue
+		linesRead++
+		if linesRead > maxOomLines {
+			self.logger().Warningf("no killed process found in the %d lines after %q, sending what was found", maxOomLines, startLine)
+			oomInstance.Partial = true
+			finished = true
+			pushBack(line, lineTime)
+			break
 		}
-		if err == nil {
-			lineChannel <- linefragment + line
-			linefragment = ""
-		} else { // err == io.EOF
-			linefragment += line
+		self.keepRawLine(line, oomInstance)
+		err := matchers.getContainerName(line, oomInstance)
+		if err != nil {
+			reportError(line, err)
+		}
+		err = getMemoryLimit(line, oomInstance)
+		if err != nil {
+			reportError(line, err)
+		}
+		getNodes(line, oomInstance)
+		if name, ok := getCpuset(line); ok {
+			cpuset = name
+		}
+		getInvokingTid(line, oomInstance)
+		counters.addLine(line, oomInstance)
+		table.addLine(line)
+		if self.ParseCgroupStats {
+			stats.addLine(line)
+			getMemInfo(line, oomInstance)
+		}
+		if self.ParseZones {
+			getZone(line, oomInstance)
+		}
+		finished, err = self.findNoKillableProcesses(line, lineTime, oomInstance)
+		if !finished {
+			finished, err = self.findProcessNamePid(line, lineTime, oomInstance)
+		}
+		if err != nil {
+			reportError(line, err)
+		}
+		if finished {
+			break
 		}
 	}
-	return err
+	self.inProgress = nil
+	if !finished {
+		return nil, table
+	}
+	fallBackToCpuset(cpuset, oomInstance)
+	table.fillVictim(oomInstance)
+	if topConsumers > 0 {
+		oomInstance.TopConsumers = table.topConsumers(topConsumers)
+	}
+	oomInstance.SelfKill = isSelfKill(oomInstance)
+	oomInstance.CgroupStats = stats.stats
+	oomInstance.IsGlobal = oomInstance.VictimContainerName == ""
+	oomInstance.RootMemcgKill = oomInstance.VictimContainerName == "/"
+	inferScope(oomInstance)
+	oomInstance.Historical = !self.historyEnd.IsZero() && !oomInstance.TimeOfDeath.IsZero() && !oomInstance.TimeOfDeath.After(self.historyEnd)
+	return oomInstance, table
 }
 
-// Calls goroutine for readLinesFromFile, which feeds it complete lines.
-// Lines are checked against a regexp to check for the pid, process name, etc.
-// At the end of an oom message group, StreamOoms adds the new oomInstance to
-// oomLog
-func (self *OomParser) StreamOoms(outStream chan *OomInstance) {
-	lineChannel := make(chan string, 10)
-	go func() {
-		readLinesFromFile(lineChannel, self.ioreader)
-	}()
+// keeps line in the raw lines of oomInstance, if they are kept and there is
+// room.
+func (self *OomParser) keepRawLine(line string, oomInstance *OomInstance) {
+	maxRawLines := self.MaxRawLines
+	if maxRawLines <= 0 {
+		maxRawLines = defaultMaxRawLines
+	}
+	if self.KeepRawLines && len(oomInstance.RawLines) < maxRawLines {
+		oomInstance.RawLines = append(oomInstance.RawLines, strings.TrimSuffix(line, "\n"))
+	}
+}
 
-	for line := range lineChannel {
-		in_oom_kernel_log := checkIfStartOfOomMessages(line)
-		if in_oom_kernel_log {
-			oomCurrentInstance := &OomInstance{
-				ContainerName: "/",
+// overflowQueue buffers the OOMs waiting to be sent to a consumer that is not
+// keeping up. OOMs stay queued until they are sent, so that the oldest can
+// still be dropped while waiting for the consumer.
+type overflowQueue struct {
+	policy     OverflowPolicy
+	bufferSize int
+	lock       sync.Mutex
+	queued     []*OomInstance
+	closed     bool
+	// the count of OOMs dropped, shared with the parser's Stats and
+	// accessed atomically
+	dropped *uint64
+	logger  Logger
+	// changed is signalled whenever the queue changes.
+	changed chan struct{}
+}
+
+func newOverflowQueue(policy OverflowPolicy, bufferSize int, logger Logger) *overflowQueue {
+	if bufferSize <= 0 {
+		bufferSize = defaultOverflowBufferSize
+	}
+	return &overflowQueue{
+		policy:     policy,
+		bufferSize: bufferSize,
+		dropped:    new(uint64),
+		logger:     logger,
+		changed:    make(chan struct{}, 1),
+	}
+}
+
+func (self *overflowQueue) signal() {
+	select {
+	case self.changed <- struct{}{}:
+	default:
+	}
+}
+
+// queues an OOM to be sent, dropping one as the policy says if the buffer is
+// full.
+func (self *overflowQueue) add(oomInstance *OomInstance) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if len(self.queued) >= self.bufferSize {
+		dropped := oomInstance
+		if self.policy == OverflowDropOldest {
+			dropped = self.queued[0]
+			self.queued = append(self.queued[1:], oomInstance)
+		}
+		total := atomic.AddUint64(self.dropped, 1)
+		self.logger.Warningf("dropped %v as the consumer is not keeping up, %d dropped so far", dropped, total)
+	} else {
+		self.queued = append(self.queued, oomInstance)
+	}
+	self.signal()
+}
+
+// stops any more OOMs being queued.
+func (self *overflowQueue) close() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.closed = true
+	self.signal()
+}
+
+// sends the queued OOMs to outStream until the queue is closed and empty, or
+// ctx is done.
+func (self *overflowQueue) send(ctx context.Context, outStream chan<- *OomInstance) {
+	for {
+		self.lock.Lock()
+		if len(self.queued) == 0 {
+			closed := self.closed
+			self.lock.Unlock()
+			if closed {
+				return
 			}
-			for line := range lineChannel {
-				err := getContainerName(line, oomCurrentInstance)
-				if err != nil {
-					glog.Errorf("%v", err)
-				}
-				finished, err := getProcessNamePid(line, oomCurrentInstance)
-				if err != nil {
-					glog.Errorf("%v", err)
-				}
-				if finished {
-					break
-				}
+			select {
+			case <-self.changed:
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+		oldest := self.queued[0]
+		self.lock.Unlock()
+		select {
+		case outStream <- oldest:
+			self.lock.Lock()
+			if len(self.queued) > 0 && self.queued[0] == oldest {
+				self.queued = self.queued[1:]
+			}
+			self.lock.Unlock()
+		case <-self.changed:
+			// oldest may have been dropped, so look again.
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Source returns the name of the source that a parser made by New or NewAuto
+// is reading, which changes if it fails over, or "" for other parsers.
+func (self *OomParser) Source() string {
+	self.sourceLock.Lock()
+	defer self.sourceLock.Unlock()
+	return self.sourceName
+}
+
+// SkippedHistoricalOoms returns how many of the OOMs replayed from the
+// kernel's ring buffer were skipped for being older than MaxHistoryAge.
+func (self *OomParser) SkippedHistoricalOoms() uint64 {
+	return atomic.LoadUint64(&self.skippedHistorical)
+}
+
+// Reset rewinds the parser's source to its start, so that it can be streamed
+// from again as if the parser were new, by Next as well as StreamOoms. It
+// returns an error if the source is not an io.Seeker, and must not be called
+// while a stream is running, including one started by Next.
+func (self *OomParser) Reset() error {
+	seeker, ok := self.source.(io.Seeker)
+	if !ok {
+		return fmt.Errorf("cannot rewind a source of type %T", self.source)
+	}
+	if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	self.ioreader.Reset(self.source)
+	atomic.StoreUint64(&self.eventSeq, 0)
+	self.haveKmsgSeq = false
+	self.streamLock.Lock()
+	self.streamErr = nil
+	self.streamLock.Unlock()
+	self.nextOnce = sync.Once{}
+	self.nextStream = nil
+	self.nextDone = nil
+	self.nextErr = nil
+	return nil
+}
+
+// Close releases the source the parser reads from, which causes any in-flight
+// StreamOoms to return. It is a no-op returning nil for sources that cannot be
+// closed, and is safe to call more than once.
+func (self *OomParser) Close() error {
+	self.sourceLock.Lock()
+	defer self.sourceLock.Unlock()
+	if self.closed {
+		return self.closeErr
+	}
+	self.closed = true
+	if self.closer != nil {
+		self.closeErr = self.closer.Close()
+	}
+	return self.closeErr
+}
+
+// reconnect closes the source after reading it failed with readErr and
+// reopens it, backing off exponentially between failed attempts. Reopened
+// sources start at their current end, so any OOM logged while reconnecting is
+// lost. Returns false if the source cannot be reopened, or if the parser was
+// closed or ctx cancelled first.
+func (self *OomParser) reconnect(ctx context.Context, readErr error) bool {
+	if self.reopen == nil {
+		return false
+	}
+	self.sourceLock.Lock()
+	if self.closed {
+		self.sourceLock.Unlock()
+		return false
+	}
+	if self.closer != nil {
+		self.closer.Close()
+	}
+	self.sourceLock.Unlock()
+
+	// Failing over is preferred to waiting for the source to come back.
+	failover := self.Failover && len(self.nextSources) > 0
+	maxBackoff := self.MaxReconnectBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultMaxReconnectBackoff
+	}
+	backoff := initialReconnectBackoff
+	for {
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+		self.logger().Warningf("reading the kernel log failed with %v, reopening it in %v. OOM events until then will be lost.", readErr, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return false
+		}
+		parser, err := self.reopen()
+		if err == nil {
+			self.sourceLock.Lock()
+			defer self.sourceLock.Unlock()
+			if self.closed {
+				parser.Close()
+				return false
 			}
-			outStream <- oomCurrentInstance
+			self.ioreader = parser.ioreader
+			self.source = parser.source
+			self.closer = parser.closer
+			atomic.AddUint64(&self.counters.reconnects, 1)
+			return true
+		}
+		readErr = err
+		if failover {
+			return false
+		}
+		backoff *= 2
+	}
+}
+
+// switches the parser to the next source that can be opened after reading
+// the current one failed with readErr, if Failover is set. Only called while
+// nothing is reading the source.
+func (self *OomParser) failOver(readErr error) bool {
+	if !self.Failover {
+		return false
+	}
+	for {
+		self.sourceLock.Lock()
+		if self.closed || len(self.nextSources) == 0 {
+			self.sourceLock.Unlock()
+			return false
 		}
+		next := self.nextSources[0]
+		self.nextSources = self.nextSources[1:]
+		previous := self.sourceName
+		self.sourceLock.Unlock()
+
+		parser, err := next.open()
+		if err != nil {
+			self.logger().Warningf("unable to fail over from %s to %s: %v", previous, next.name, err)
+			continue
+		}
+		self.logger().Warningf("reading %s failed with %v, failing over to %s. OOM events until then may have been lost.", previous, readErr, next.name)
+		self.sourceLock.Lock()
+		defer self.sourceLock.Unlock()
+		if self.closed {
+			parser.Close()
+			return false
+		}
+		if self.closer != nil {
+			self.closer.Close()
+		}
+		self.ioreader = parser.ioreader
+		self.source = parser.source
+		self.closer = parser.closer
+		self.reopen = parser.reopen
+		self.sourceName = next.name
+		self.lmkd = parser.lmkd
+		self.kmsg = parser.kmsg
+		self.bootTime = parser.bootTime
+		self.haveKmsgSeq = false
+		self.historyEnd = parser.historyEnd
+		self.follow = parser.follow
+		atomic.AddUint64(&self.counters.failovers, 1)
+		return true
 	}
-	glog.Infof("exiting analyzeLines. OOM events will not be reported.")
 }
 
-func callJournalctl() (io.ReadCloser, error) {
-	cmd := exec.Command("journalctl", "-k", "-f")
+// journalctl wraps the stdout of a running journalctl process so that closing
+// it also stops the process.
+type journalctl struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+func (self *journalctl) Close() error {
+	err := self.ReadCloser.Close()
+	self.cmd.Process.Kill()
+	self.cmd.Wait()
+	return err
+}
+
+func callJournalctl(args ...string) (io.ReadCloser, error) {
+	cmd := exec.Command("journalctl", args...)
 	readcloser, err := cmd.StdoutPipe()
 	if err != nil {
 		return nil, err
@@ -174,18 +2820,140 @@ func callJournalctl() (io.ReadCloser, error) {
 	if err := cmd.Start(); err != nil {
 		return nil, err
 	}
-	return readcloser, err
+	return &journalctl{ReadCloser: readcloser, cmd: cmd}, err
+}
+
+// returns an OomParser reading /dev/kmsg records from in, whose timestamps are
+// relative to bootTime.
+func newKmsgOomParser(in io.Reader, bootTime time.Time) *OomParser {
+	parser := NewFromReader(in)
+	parser.kmsg = true
+	parser.bootTime = bootTime
+	return parser
+}
+
+// NewFromJournald returns an OomParser that follows the kernel messages in the
+// systemd journal, starting from the newest. It returns an error if journald
+// is not running or journalctl is not installed.
+func NewFromJournald() (*OomParser, error) {
+	if !utils.FileExists("/run/systemd/journal") {
+		return nil, fmt.Errorf("journald is not running")
+	}
+	if _, err := exec.LookPath("journalctl"); err != nil {
+		return nil, err
+	}
+	readcloser, err := callJournalctl("-k", "-f", "--lines=0", "-o", "short-iso")
+	if err != nil {
+		return nil, err
+	}
+	return NewFromReader(readcloser), nil
+}
+
+// a source of kernel log lines that NewAuto probes
+type autoSource struct {
+	name string
+	open func() (*OomParser, error)
+}
+
+// returns a parser for the first of sources that can be opened, and its name.
+// If none can, the error wraps ErrNoKernelLog and the KmsgError if there was
+// one, as /dev/kmsg is the source that containers are expected to use, and
+// otherwise the last source's error.
+func newAuto(sources []autoSource) (*OomParser, string, error) {
+	var err, kmsgErr error
+	for i, source := range sources {
+		var parser *OomParser
+		parser, err = source.open()
+		if err == nil {
+			parser.sourceName = source.name
+			parser.nextSources = sources[i+1:]
+			return parser, source.name, nil
+		}
+		if _, ok := err.(*KmsgError); ok {
+			kmsgErr = err
+		}
+	}
+	if kmsgErr != nil {
+		glog.Warningf("unable to find a kernel log to read: %v", err)
+		err = kmsgErr
+	}
+	return nil, "", &noKernelLogError{err}
+}
+
+// the error newAuto returns, which errors.Is matches against ErrNoKernelLog,
+// and unwraps to why the source it reports failed. A type rather than an
+// error wrapping both, as only Go 1.20 and later wrap more than one.
+type noKernelLogError struct {
+	err error
+}
+
+func (self *noKernelLogError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrNoKernelLog, self.err)
+}
+
+func (self *noKernelLogError) Is(target error) bool {
+	return target == ErrNoKernelLog
+}
+
+func (self *noKernelLogError) Unwrap() error {
+	return self.err
+}
+
+var (
+	// ErrNoKernelLog is wrapped by the error New and NewAuto return when none
+	// of the kernel's logs can be read, so that OOMs cannot be watched for.
+	ErrNoKernelLog = errors.New("no kernel log can be read to watch for OOMs")
+	// ErrUnsupported is wrapped by the error New and its variants return on
+	// platforms other than Linux, where there is no kernel log to read.
+	ErrUnsupported = errors.New("OOM parsing not supported on this platform")
+	// ErrKmsgNotFound is the Reason for a KmsgError when /dev/kmsg does not
+	// exist, e.g. as it was not mounted into the container.
+	ErrKmsgNotFound = errors.New("/dev/kmsg does not exist, it may need to be mounted into the container")
+	// ErrKmsgPermission is the Reason for a KmsgError when the file
+	// permissions of /dev/kmsg do not let it be read.
+	ErrKmsgPermission = errors.New("permission denied reading /dev/kmsg, run as a user that can read it")
+	// ErrKmsgNotPermitted is the Reason for a KmsgError when the kernel or
+	// the devices cgroup does not let the process read /dev/kmsg.
+	ErrKmsgNotPermitted = errors.New("not permitted to read /dev/kmsg, grant CAP_SYSLOG or set kernel.dmesg_restrict=0, and allow the device in the container")
+)
+
+// KmsgError is returned when /dev/kmsg cannot be opened for one of the
+// reasons above. errors.Is matches it against its Reason, and errors.As
+// against the underlying *os.PathError.
+type KmsgError struct {
+	// one of ErrKmsgNotFound, ErrKmsgPermission and ErrKmsgNotPermitted
+	Reason error
+	Err    error
+}
+
+func (self *KmsgError) Error() string {
+	return fmt.Sprintf("%v: %v", self.Err, self.Reason)
+}
+
+func (self *KmsgError) Is(target error) bool {
+	return target == self.Reason
+}
+
+func (self *KmsgError) Unwrap() error {
+	return self.Err
 }
 
 func trySystemd() (*OomParser, error) {
-	readcloser, err := callJournalctl()
+	parser, err := NewFromJournald()
 	if err != nil {
 		return nil, err
 	}
 	glog.Infof("oomparser using systemd")
-	return &OomParser{
-		ioreader: bufio.NewReader(readcloser),
-	}, nil
+	return parser, nil
+}
+
+// returns the id the kernel generated for the current boot. Replaced in tests.
+var readBootID = func() (string, error) {
+	bootID, err := ioutil.ReadFile("/proc/sys/kernel/random/boot_id")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(bootID)), nil
 }
 
 // List of possible kernel log files. These are prioritized in order so that
@@ -209,24 +2977,20 @@ func tryLogFile() (*OomParser, error) {
 	if err != nil {
 		return nil, err
 	}
-	tail, err := tail.NewTail(logFile)
-	if err != nil {
-		return nil, err
-	}
-	return &OomParser{
-		ioreader: bufio.NewReader(tail),
-	}, nil
+	return NewFromLogFile(logFile)
 }
 
-// initializes an OomParser object. Returns an OomParser object and an error.
-func New() (*OomParser, error) {
-	parser, err := trySystemd()
-	if err == nil {
-		return parser, nil
+// NewFromReader returns an OomParser that reads kernel log lines from in rather
+// than from the system's kernel log. If in is an io.Closer, closing the parser
+// closes it.
+func NewFromReader(in io.Reader) *OomParser {
+	parser := &OomParser{
+		ioreader: bufio.NewReader(in),
+		source:   in,
+		nowFunc:  time.Now,
 	}
-	parser, err = tryLogFile()
-	if err == nil {
-		return parser, nil
+	if closer, ok := in.(io.Closer); ok {
+		parser.closer = closer
 	}
-	return nil, err
+	return parser
 }
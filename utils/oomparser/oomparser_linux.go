@@ -0,0 +1,190 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package oomparser
+
+import (
+	"io"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/golang/glog"
+)
+
+// returns the reading of the kernel's monotonic clock, which /dev/kmsg
+// timestamps are taken from.
+func getMonotonicTime() (time.Duration, error) {
+	var ts syscall.Timespec
+	_, _, errno := syscall.Syscall(syscall.SYS_CLOCK_GETTIME, clockMonotonic, uintptr(unsafe.Pointer(&ts)), 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	return time.Duration(ts.Nano()), nil
+}
+
+// CLOCK_MONOTONIC from <time.h>
+const clockMonotonic = 1
+
+// opens /dev/kmsg, returning its file descriptor. Replaced in tests.
+var openKmsg = func() (int, error) {
+	return syscall.Open("/dev/kmsg", syscall.O_RDONLY|syscall.O_CLOEXEC|syscall.O_NONBLOCK, 0)
+}
+
+// wraps the errno that opening /dev/kmsg failed with in a KmsgError saying
+// what it means.
+func newKmsgError(err error) error {
+	kmsgErr := &KmsgError{Err: &os.PathError{Op: "open", Path: "/dev/kmsg", Err: err}}
+	switch err {
+	case syscall.ENOENT:
+		kmsgErr.Reason = ErrKmsgNotFound
+	case syscall.EACCES:
+		kmsgErr.Reason = ErrKmsgPermission
+	case syscall.EPERM:
+		kmsgErr.Reason = ErrKmsgNotPermitted
+	default:
+		return kmsgErr.Err
+	}
+	return kmsgErr
+}
+
+func newDevKmsgOomParser() (*OomParser, error) {
+	return openDevKmsg(false)
+}
+
+// opens /dev/kmsg. If replay is set, it starts from the oldest record still in
+// the ring buffer, and the OOMs logged before it was opened are Historical.
+// Otherwise it starts from the newest record, so that old OOMs are not reported
+// again every time we restart.
+func openDevKmsg(replay bool) (*OomParser, error) {
+	// /dev/kmsg timestamps are taken from the monotonic clock, which does
+	// not advance while the system is suspended, so the time they are
+	// relative to is found from it rather than from the uptime.
+	monotonicTime, err := getMonotonicTime()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	bootTime := now.Add(-monotonicTime)
+	var historyEnd time.Time
+	if replay {
+		historyEnd = now
+	}
+	// Opened nonblocking so that skipping the backlog can tell when it is
+	// done. The runtime then waits for reads, as it does for os.Open.
+	fd, err := openKmsg()
+	if err != nil {
+		return nil, newKmsgError(err)
+	}
+	if !replay {
+		if err := skipKmsgBacklog(rawFile(fd)); err != nil {
+			syscall.Close(fd)
+			return nil, err
+		}
+	}
+	kmsg := os.NewFile(uintptr(fd), "/dev/kmsg")
+	glog.Infof("oomparser using /dev/kmsg")
+	parser := newKmsgOomParser(kmsg, bootTime)
+	parser.historyEnd = historyEnd
+	parser.reopen = newDevKmsgOomParser
+	return parser, nil
+}
+
+// the largest /dev/kmsg record, which has to be read whole
+const kmsgRecordSize = 8192
+
+// a /dev/kmsg whose backlog is to be skipped. Its reads must not block.
+type kmsgBacklog interface {
+	Seek(offset int64, whence int) (int64, error)
+	Read(p []byte) (int, error)
+}
+
+// moves kmsg past the records already in it. Seeking to its end does this,
+// but some kernels fail the seek with ESPIPE, in which case the records are
+// read and discarded until EAGAIN says there are no more.
+func skipKmsgBacklog(kmsg kmsgBacklog) error {
+	_, err := kmsg.Seek(0, io.SeekEnd)
+	if err != syscall.ESPIPE {
+		return err
+	}
+	glog.Warningf("unable to seek to the end of /dev/kmsg, reading past the messages already in it instead")
+	record := make([]byte, kmsgRecordSize)
+	for {
+		n, err := kmsg.Read(record)
+		switch err {
+		case nil:
+			if n == 0 {
+				return nil
+			}
+		case syscall.EPIPE:
+			// Records were overwritten before they were read.
+		case syscall.EAGAIN:
+			return nil
+		default:
+			return err
+		}
+	}
+}
+
+// a file descriptor read without the runtime's poller, so that reads of a
+// nonblocking descriptor fail with EAGAIN rather than waiting.
+type rawFile int
+
+func (self rawFile) Seek(offset int64, whence int) (int64, error) {
+	return syscall.Seek(int(self), offset, whence)
+}
+
+func (self rawFile) Read(p []byte) (int, error) {
+	return syscall.Read(int(self), p)
+}
+
+// NewWithHistory behaves like New, but first replays the OOMs still in the
+// kernel's ring buffer, marking them Historical, before carrying on with new
+// ones. Only /dev/kmsg keeps this history; if it cannot be read, the parser
+// falls back to New's other sources, which only report new OOMs.
+func NewWithHistory() (*OomParser, error) {
+	parser, err := openDevKmsg(true)
+	if err == nil {
+		return parser, nil
+	}
+	glog.Warningf("unable to replay OOMs from /dev/kmsg: %v", err)
+	return New()
+}
+
+// The sources New and NewAuto probe, in order. Replaced in tests.
+var autoSources = []autoSource{
+	{"kmsg", newDevKmsgOomParser},
+	{"journald", trySystemd},
+	{"logfile", tryLogFile},
+}
+
+// initializes an OomParser object. Returns an OomParser object and an error.
+// If no source can be read, the error wraps ErrNoKernelLog, and the KmsgError
+// if /dev/kmsg could not be opened for one, as it is the source that
+// containers are expected to use; see errors.Is and errors.As.
+func New() (*OomParser, error) {
+	parser, _, err := newAuto(autoSources)
+	return parser, err
+}
+
+// NewAuto behaves like New, but also returns the name of the source it chose:
+// "kmsg", "journald" or "logfile". If the parser's Failover is set, it moves
+// on to the sources after that one if reading it fails.
+func NewAuto() (*OomParser, string, error) {
+	return newAuto(autoSources)
+}
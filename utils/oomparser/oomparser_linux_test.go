@@ -0,0 +1,109 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package oomparser
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// a /dev/kmsg holding records records, which fails seeks with seekErr.
+type fakeKmsgBacklog struct {
+	seekErr error
+	records int
+	whence  int
+	read    int
+}
+
+func (self *fakeKmsgBacklog) Seek(offset int64, whence int) (int64, error) {
+	self.whence = whence
+	return 0, self.seekErr
+}
+
+func (self *fakeKmsgBacklog) Read(p []byte) (int, error) {
+	if self.read == self.records {
+		return -1, syscall.EAGAIN
+	}
+	self.read++
+	if self.read == 2 {
+		return -1, syscall.EPIPE
+	}
+	return copy(p, fmt.Sprintf("6,%d,0,-;message\n", self.read)), nil
+}
+
+func TestSkipKmsgBacklog(t *testing.T) {
+	kmsg := &fakeKmsgBacklog{records: 5}
+	if err := skipKmsgBacklog(kmsg); err != nil {
+		t.Errorf("expected seeking to succeed, got %v", err)
+	}
+	if kmsg.whence != io.SeekEnd || kmsg.read != 0 {
+		t.Errorf("expected a seek to the end and no reads, got whence %d and %d reads", kmsg.whence, kmsg.read)
+	}
+
+	kmsg = &fakeKmsgBacklog{seekErr: syscall.ESPIPE, records: 5}
+	if err := skipKmsgBacklog(kmsg); err != nil {
+		t.Errorf("expected ESPIPE to fall back to reading, got %v", err)
+	}
+	if kmsg.read != 5 {
+		t.Errorf("expected all 5 records to be read past, got %d", kmsg.read)
+	}
+
+	kmsg = &fakeKmsgBacklog{seekErr: syscall.EINVAL, records: 5}
+	if err := skipKmsgBacklog(kmsg); err != syscall.EINVAL {
+		t.Errorf("expected %v, got %v", syscall.EINVAL, err)
+	}
+}
+
+func TestOpenDevKmsgErrors(t *testing.T) {
+	defer func(original func() (int, error)) {
+		openKmsg = original
+	}(openKmsg)
+	testCases := []struct {
+		errno  syscall.Errno
+		reason error
+	}{
+		{syscall.ENOENT, ErrKmsgNotFound},
+		{syscall.EACCES, ErrKmsgPermission},
+		{syscall.EPERM, ErrKmsgNotPermitted},
+		{syscall.EIO, nil},
+	}
+	for _, testCase := range testCases {
+		openKmsg = func() (int, error) {
+			return -1, testCase.errno
+		}
+		_, err := newDevKmsgOomParser()
+		var pathErr *os.PathError
+		if !errors.As(err, &pathErr) || pathErr.Err != testCase.errno {
+			t.Errorf("%v: expected the errno to be kept, got %v", testCase.errno, err)
+		}
+		kmsgErr, ok := err.(*KmsgError)
+		if testCase.reason == nil {
+			if ok {
+				t.Errorf("%v: expected no KmsgError, got %v", testCase.errno, err)
+			}
+			continue
+		}
+		if !ok || !errors.Is(err, testCase.reason) || kmsgErr.Reason != testCase.reason {
+			t.Errorf("%v: expected a KmsgError for %v, got %v", testCase.errno, testCase.reason, err)
+		}
+	}
+}
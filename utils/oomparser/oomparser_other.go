@@ -0,0 +1,44 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+// +build !linux
+
+package oomparser
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// The kernel's OOM messages are only parsed on Linux. Elsewhere the parser can
+// still be used on saved logs through NewFromReader.
+func errUnsupported() error {
+	return fmt.Errorf("%w (%s)", ErrUnsupported, runtime.GOOS)
+}
+
+// NewWithHistory always fails on this platform.
+func NewWithHistory() (*OomParser, error) {
+	return nil, errUnsupported()
+}
+
+// NewAuto always fails on this platform.
+func NewAuto() (*OomParser, string, error) {
+	return nil, "", errUnsupported()
+}
+
+// New always fails on this platform.
+func New() (*OomParser, error) {
+	return nil, errUnsupported()
+}
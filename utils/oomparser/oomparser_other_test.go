@@ -0,0 +1,35 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+// +build !linux
+
+package oomparser
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestUnsupported(t *testing.T) {
+	if _, err := New(); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected New to fail with %v, got %v", ErrUnsupported, err)
+	}
+	if _, _, err := NewAuto(); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected NewAuto to fail with %v, got %v", ErrUnsupported, err)
+	}
+	if _, err := NewWithHistory(); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected NewWithHistory to fail with %v, got %v", ErrUnsupported, err)
+	}
+}
@@ -15,20 +15,40 @@
 package oomparser
 
 import (
-	"bufio"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
 	"os"
 	"reflect"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
+
+	oomtesting "github.com/google/cadvisor/utils/oomparser/testing"
+
+	"golang.org/x/net/context"
 )
 
 const startLine = "Jan 21 22:01:49 localhost kernel: [62278.816267] ruby invoked oom-killer: gfp_mask=0x201da, order=0, oom_score_adj=0"
 const endLine = "Jan 21 22:01:49 localhost kernel: [62279.421192] Killed process 19667 (evilprogram2) total-vm:1460016kB, anon-rss:1414008kB, file-rss:4kB"
+const endLineWithScoreAdj = "Jan 21 22:01:49 localhost kernel: [62279.421192] Killed process 19667 (evilprogram2) total-vm:1460016kB, anon-rss:1414008kB, file-rss:4kB, shmem-rss:0kB, UID:0 pgtables:2828kB oom_score_adj:-998"
 const containerLine = "Jan 26 14:10:07 kateknister0.mtv.corp.google.com kernel: [1814368.465205] Task in /mem2 killed as a result of limit of /mem3"
+const oomKillLine = "Sep  2 14:31:05 worker-1 kernel: [ 9012.345086] oom-kill:constraint=CONSTRAINT_MEMCG,nodemask=(null),cpuset=stress.scope,mems_allowed=0,oom_memcg=/mem3,task_memcg=/mem3/mem2,task=stress,pid=48213,uid=0"
 const containerLogFile = "containerOomExampleLog.txt"
 const systemLogFile = "systemOomExampleLog.txt"
+const kubepodsLogFile = "kubepodsOomExampleLog.txt"
+const cgroupv2LogFile = "cgroupv2OomExampleLog.txt"
+const pagesLogFile = "pagesOomExampleLog.txt"
+const groupKillLogFile = "groupKillOomExampleLog.txt"
+const kmsgLogFile = "kmsgOomExampleLog.txt"
 
 func createExpectedContainerOomInstance(t *testing.T) *OomInstance {
 	const longForm = "Jan _2 15:04:05 2006"
@@ -64,14 +84,14 @@ func createExpectedSystemOomInstance(t *testing.T) *OomInstance {
 
 func TestGetContainerName(t *testing.T) {
 	currentOomInstance := new(OomInstance)
-	err := getContainerName(startLine, currentOomInstance)
+	err := DefaultMatchers.getContainerName(startLine, currentOomInstance)
 	if err != nil {
 		t.Errorf("bad line fed to getContainerName should yield no error, but had error %v", err)
 	}
 	if currentOomInstance.ContainerName != "" {
 		t.Errorf("bad line fed to getContainerName yielded no container name but set it to %s", currentOomInstance.ContainerName)
 	}
-	err = getContainerName(containerLine, currentOomInstance)
+	err = DefaultMatchers.getContainerName(containerLine, currentOomInstance)
 	if err != nil {
 		t.Errorf("container line fed to getContainerName should yield no error, but had error %v", err)
 	}
@@ -83,9 +103,412 @@ func TestGetContainerName(t *testing.T) {
 	}
 }
 
+func TestGetContainerNameSystemd(t *testing.T) {
+	testCases := []struct {
+		line     string
+		expected string
+	}{
+		{
+			"Jan 26 14:10:07 localhost kernel: [1814368.465205] Task in /system.slice/docker-4a9c8e7fd1b2.scope killed as a result of limit of /system.slice/docker-4a9c8e7fd1b2.scope \r",
+			"/system.slice/docker-4a9c8e7fd1b2.scope",
+		},
+		{
+			"Jan 26 14:10:07 localhost kernel: [1814368.465205] Task in /machine.slice/machine-qemu\\x2d1\\x2dvm.scope killed as a result of limit of /machine.slice/machine-qemu\\x2d1\\x2dvm.scope",
+			"/machine.slice/machine-qemu\\x2d1\\x2dvm.scope",
+		},
+	}
+	for _, testCase := range testCases {
+		currentOomInstance := new(OomInstance)
+		if err := DefaultMatchers.getContainerName(testCase.line, currentOomInstance); err != nil {
+			t.Errorf("%q: unexpected error %v", testCase.line, err)
+		}
+		// The names are left escaped, as they are in the cgroup filesystem.
+		if currentOomInstance.ContainerName != testCase.expected || currentOomInstance.VictimContainerName != testCase.expected {
+			t.Errorf("%q: expected container %q, got %q and %q", testCase.line, testCase.expected, currentOomInstance.ContainerName, currentOomInstance.VictimContainerName)
+		}
+	}
+
+	// /dev/kmsg escapes the backslashes themselves.
+	oomLog := newKmsgOomParser(strings.NewReader(""), time.Unix(0, 0))
+	message, _, _ := oomLog.splitKmsgLine("6,1,100,-;Task in /machine.slice/machine-qemu\\x5cx2d1\\x5cx2dvm.scope killed as a result of limit of /machine.slice")
+	if expected := "Task in /machine.slice/machine-qemu\\x2d1\\x2dvm.scope killed as a result of limit of /machine.slice"; message != expected {
+		t.Errorf("expected the kmsg message to be unescaped to %q, got %q", expected, message)
+	}
+
+	if unescaped := UnescapeSystemdPath("/machine.slice/machine-qemu\\x2d1\\x2dvm.scope"); unescaped != "/machine.slice/machine-qemu-1-vm.scope" {
+		t.Errorf("expected the unit name to be unescaped, got %q", unescaped)
+	}
+}
+
+func TestGetContainerNameOomKillLine(t *testing.T) {
+	currentOomInstance := new(OomInstance)
+	err := DefaultMatchers.getContainerName(oomKillLine, currentOomInstance)
+	if err != nil {
+		t.Errorf("oom-kill line fed to getContainerName should yield no error, but had error %v", err)
+	}
+	if currentOomInstance.ContainerName != "/mem3/mem2" {
+		t.Errorf("getContainerName should have set containerName to /mem3/mem2, not %s", currentOomInstance.ContainerName)
+	}
+	if currentOomInstance.VictimContainerName != "/mem3" {
+		t.Errorf("getContainerName should have set victimContainerName to /mem3, not %s", currentOomInstance.VictimContainerName)
+	}
+	if currentOomInstance.Pid != 48213 || currentOomInstance.ProcessName != "stress" {
+		t.Errorf("getContainerName should have set pid 48213 and process stress, not %d and %s", currentOomInstance.Pid, currentOomInstance.ProcessName)
+	}
+	if !currentOomInstance.FromOomKillLine {
+		t.Errorf("getContainerName should have recorded that the oom-kill line was used")
+	}
+}
+
+func TestParseOomKillLine(t *testing.T) {
+	fields := DefaultMatchers.parseOomKillLine("oom-kill:constraint=CONSTRAINT_NONE,nodemask=0-1,3,cpuset=/,mems_allowed=0,2,global_oom,task_memcg=/system.slice/foo.service,task=foo,pid=812,uid=0")
+	expected := map[string]string{
+		"constraint":   "CONSTRAINT_NONE",
+		"nodemask":     "0-1,3",
+		"cpuset":       "/",
+		"mems_allowed": "0,2",
+		"global_oom":   "",
+		"task_memcg":   "/system.slice/foo.service",
+		"task":         "foo",
+		"pid":          "812",
+		"uid":          "0",
+	}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Errorf("expected oom-kill fields %v, got %v", expected, fields)
+	}
+	if fields := DefaultMatchers.parseOomKillLine(containerLine); fields != nil {
+		t.Errorf("a line without oom-kill should not be parsed, but got %v", fields)
+	}
+}
+
+func TestConstraint(t *testing.T) {
+	constraints := []string{ConstraintNone, ConstraintCpuset, ConstraintMemoryPolicy, ConstraintMemcg}
+	for _, constraint := range constraints {
+		currentOomInstance := new(OomInstance)
+		line := "oom-kill:constraint=" + constraint + ",nodemask=(null),cpuset=/,mems_allowed=0,task_memcg=/,task=stress,pid=48213,uid=0"
+		if err := DefaultMatchers.getContainerName(line, currentOomInstance); err != nil {
+			t.Errorf("oom-kill line fed to getContainerName should yield no error, but had error %v", err)
+		}
+		if currentOomInstance.Constraint != constraint {
+			t.Errorf("expected constraint %s from the oom-kill line, got %q", constraint, currentOomInstance.Constraint)
+		}
+
+		currentOomInstance = new(OomInstance)
+		getConstraint("stress invoked oom-killer: gfp_mask=0xcc0(GFP_KERNEL), order=0, oom_score_adj=0, constraint="+constraint, currentOomInstance)
+		if currentOomInstance.Constraint != constraint {
+			t.Errorf("expected constraint %s from the invoked oom-killer line, got %q", constraint, currentOomInstance.Constraint)
+		}
+	}
+
+	currentOomInstance := new(OomInstance)
+	getConstraint(startLine, currentOomInstance)
+	if currentOomInstance.Constraint != "" {
+		t.Errorf("a line without a constraint should leave it empty, not %q", currentOomInstance.Constraint)
+	}
+	if oomInstance := readOneOom(cgroupv2LogFile, t); oomInstance.Constraint != ConstraintMemcg {
+		t.Errorf("expected constraint %s from %s, got %q", ConstraintMemcg, cgroupv2LogFile, oomInstance.Constraint)
+	}
+}
+
+func TestNodes(t *testing.T) {
+	testCases := []struct {
+		lines       []string
+		nodeMask    string
+		memsAllowed string
+	}{
+		// 4.19 and later, limited to node 1 by a mempolicy
+		{
+			[]string{
+				"Mar  4 09:12:01 numa-1 kernel: [ 3601.000001] stress invoked oom-killer: gfp_mask=0x6280ca(GFP_HIGHUSER_MOVABLE|__GFP_ZERO), order=0, oom_score_adj=0",
+				"Mar  4 09:12:01 numa-1 kernel: [ 3601.000090] oom-kill:constraint=CONSTRAINT_MEMORY_POLICY,nodemask=1,cpuset=/,mems_allowed=0-1,global_oom,task_memcg=/user.slice,task=stress,pid=5120,uid=1000",
+			},
+			"1", "0-1",
+		},
+		// 4.19 and later, not limited by a mempolicy
+		{
+			[]string{
+				"Mar  4 09:12:01 numa-1 kernel: [ 3601.000001] stress invoked oom-killer: gfp_mask=0x6280ca(GFP_HIGHUSER_MOVABLE|__GFP_ZERO), order=0, oom_score_adj=0",
+				"Mar  4 09:12:01 numa-1 kernel: [ 3601.000090] oom-kill:constraint=CONSTRAINT_CPUSET,nodemask=(null),cpuset=numa0,mems_allowed=0,2,global_oom,task_memcg=/user.slice,task=stress,pid=5120,uid=1000",
+			},
+			"", "0,2",
+		},
+		// 4.9 to 4.18
+		{
+			[]string{
+				"Mar  4 09:12:01 numa-1 kernel: [ 3601.000001] stress invoked oom-killer: gfp_mask=0x24280ca(GFP_HIGHUSER_MOVABLE|__GFP_ZERO), nodemask=0-1,3, order=0, oom_score_adj=0",
+				"Mar  4 09:12:01 numa-1 kernel: [ 3601.000002] stress cpuset=/ mems_allowed=0-3",
+			},
+			"0-1,3", "0-3",
+		},
+		// not NUMA
+		{[]string{startLine, containerLine}, "", ""},
+	}
+	for _, testCase := range testCases {
+		lines := append(testCase.lines, "Mar  4 09:12:01 numa-1 kernel: [ 3601.000100] Out of memory: Killed process 5120 (stress) total-vm:1051708kB")
+		oomLog := NewFromReader(strings.NewReader(strings.Join(lines, "\n") + "\n"))
+		outStream := make(chan *OomInstance)
+		go oomLog.StreamOoms(outStream)
+		select {
+		case oomInstance := <-outStream:
+			if oomInstance.NodeMask != testCase.nodeMask || oomInstance.MemsAllowed != testCase.memsAllowed {
+				t.Errorf("%q: expected nodemask %q and mems_allowed %q, got %q and %q", testCase.lines, testCase.nodeMask, testCase.memsAllowed, oomInstance.NodeMask, oomInstance.MemsAllowed)
+			}
+		case <-time.After(1 * time.Second):
+			t.Errorf("%q: timeout happened before oomInstance was found", testCase.lines)
+		}
+	}
+}
+
+func TestMatchers(t *testing.T) {
+	matchers := DefaultMatchers
+	matchers.FirstLine = regexp.MustCompile(`oom-killer invoked by`)
+	matchers.Container = regexp.MustCompile(`victim cgroup (\S+) under limit of (\S+)`)
+	input := strings.Join([]string{
+		"Jan 21 22:01:49 localhost kernel: [62278.816267] oom-killer invoked by ruby",
+		"Jan 21 22:01:49 localhost kernel: [62278.816300] victim cgroup /mem2 under limit of /mem3",
+		endLine,
+		// The default wording is no longer recognized.
+		startLine, containerLine, endLine,
+	}, "\n") + "\n"
+	oomLog := NewFromReader(strings.NewReader(input))
+	oomLog.Matchers = &matchers
+	oomLog.CloseStreamOnExit = true
+	outStream := make(chan *OomInstance)
+	go oomLog.StreamOoms(outStream)
+	var oomInstances []*OomInstance
+	for oomInstance := range outStream {
+		oomInstances = append(oomInstances, oomInstance)
+	}
+	if len(oomInstances) != 1 {
+		t.Fatalf("expected one OOM in the custom format, got %v", oomInstances)
+	}
+	if oomInstance := oomInstances[0]; oomInstance.Pid != 19667 || oomInstance.ContainerName != "/mem2" || oomInstance.VictimContainerName != "/mem3" {
+		t.Errorf("expected evilprogram2 (19667) in /mem2 under /mem3, got %v", oomInstance)
+	}
+}
+
+func TestGetInvokingTask(t *testing.T) {
+	testCases := []struct {
+		line            string
+		invokingProcess string
+		allocationOrder int
+		gfpMask         string
+	}{
+		// Linux 3.13 syslog
+		{startLine, "ruby", 0, "0x201da"},
+		// Linux 2.6, which printed oom_adj
+		{"Mar  3 11:04:21 db-3 kernel: [88112.102932] postgres invoked oom-killer: gfp_mask=0x201da, order=0, oom_adj=0, oom_score_adj=0", "postgres", 0, "0x201da"},
+		// Linux 2.6.18, which separated the fields with spaces
+		{"Mar  3 11:04:21 db-3 kernel: java invoked oom-killer: gfp_mask=0x280d2 order=9 oomkilladj=0", "java", 9, "0x280d2"},
+		// Linux 5.10 /dev/kmsg, with gfp flags and a name containing a space
+		{"Web Content invoked oom-killer: gfp_mask=0x100cca(GFP_HIGHUSER_MOVABLE), order=3, oom_score_adj=167", "Web Content", 3, "0x100cca(GFP_HIGHUSER_MOVABLE)"},
+		// syslog without a printk timestamp
+		{"Mar  3 11:04:21 db-3 kernel: kworker/u8:2 invoked oom-killer: gfp_mask=0x2dc2, order=2, oom_score_adj=0", "kworker/u8:2", 2, "0x2dc2"},
+		// no allocation details
+		{"Mar  3 11:04:21 db-3 kernel: sh invoked oom-killer: oom_score_adj=0", "sh", -1, ""},
+		// not an invoked oom-killer line
+		{containerLine, "", -1, ""},
+	}
+	for _, testCase := range testCases {
+		currentOomInstance := new(OomInstance)
+		getInvokingTask(testCase.line, currentOomInstance)
+		if currentOomInstance.InvokingProcess != testCase.invokingProcess || currentOomInstance.AllocationOrder != testCase.allocationOrder {
+			t.Errorf("expected invoking process %q with order %d from %q, got %q with order %d", testCase.invokingProcess, testCase.allocationOrder, testCase.line, currentOomInstance.InvokingProcess, currentOomInstance.AllocationOrder)
+		}
+		if currentOomInstance.GfpMask != testCase.gfpMask {
+			t.Errorf("expected gfp_mask %q from %q, got %q", testCase.gfpMask, testCase.line, currentOomInstance.GfpMask)
+		}
+	}
+}
+
+func TestOomInstanceString(t *testing.T) {
+	oomInstance := &OomInstance{
+		Pid:                 19667,
+		ProcessName:         "evilprogram2",
+		TimeOfDeath:         time.Date(2016, time.January, 21, 22, 1, 49, 500000000, time.UTC),
+		ContainerName:       "/mem2",
+		VictimContainerName: "/mem3",
+		MemoryLimitBytes:    1003520,
+	}
+	expected := "OOM: pid=19667 proc=evilprogram2 container=/mem2 victim=/mem3 at 2016-01-21T22:01:49.5Z limit=1003520"
+	if actual := fmt.Sprintf("%v", oomInstance); actual != expected {
+		t.Errorf("expected %q, got %q", expected, actual)
+	}
+}
+
+func TestOomInstanceMarshalJSON(t *testing.T) {
+	oomInstance := &OomInstance{
+		Pid:         19667,
+		TimeOfDeath: time.Date(2016, time.January, 21, 22, 1, 49, 0, time.UTC),
+	}
+	data, err := json.Marshal(oomInstance)
+	if err != nil {
+		t.Fatalf("failed to marshal %v: %v", oomInstance, err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatalf("failed to unmarshal %s: %v", data, err)
+	}
+	var keys []string
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	expected := []string{
+		"allocation_order",
+		"boot_id",
+		"cgroup_stats",
+		"coalesced",
+		"constraint",
+		"container_name",
+		"container_name_from_cpuset",
+		"context_after",
+		"context_before",
+		"event_seq",
+		"free_swap_kb",
+		"from_oom_kill_line",
+		"gfp_mask",
+		"group_kill",
+		"has_oom_score_adj",
+		"has_victim_oom_score",
+		"historical",
+		"invoking_process",
+		"invoking_tid",
+		"is_global",
+		"log_level",
+		"memory_limit_bytes",
+		"mems_allowed",
+		"no_killable_processes",
+		"node_mask",
+		"oom_score_adj",
+		"partial",
+		"pid",
+		"process_name",
+		"raw_lines",
+		"root_memcg_kill",
+		"scope",
+		"self_kill",
+		"swap_limit_bytes",
+		"swap_unlimited",
+		"swap_usage_bytes",
+		"time_of_death",
+		"top_consumers",
+		"total_ram_pages",
+		"total_swap_kb",
+		"truncated",
+		"victim_cgroup_exists",
+		"victim_cgroup_usage_bytes",
+		"victim_container_name",
+		"victim_global_pid",
+		"victim_oom_score",
+		"victim_pgtables_bytes",
+		"victim_rss_pages",
+		"victim_total_vm_pages",
+		"victim_uid",
+		"zones",
+	}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected JSON keys %v, got %v", expected, keys)
+	}
+	if fields["time_of_death"] != "2016-01-21T22:01:49Z" {
+		t.Errorf("expected time_of_death in RFC 3339 format, got %v", fields["time_of_death"])
+	}
+	if fields["pid"] != float64(19667) {
+		t.Errorf("expected pid 19667, got %v", fields["pid"])
+	}
+}
+
+func TestStreamOomsCgroupVersions(t *testing.T) {
+	testCases := []struct {
+		logFile             string
+		pid                 int
+		processName         string
+		containerName       string
+		victimContainerName string
+		fromOomKillLine     bool
+	}{
+		{containerLogFile, 13536, "memorymonster", "/mem2", "/mem2", false},
+		{kubepodsLogFile, 30211, "stress", "/kubepods/burstable/pod6c1f9bd3-4607-11e9-8e6a-42010a800002/5b7f0cd34578", "/kubepods/burstable/pod6c1f9bd3-4607-11e9-8e6a-42010a800002", false},
+		{cgroupv2LogFile, 48213, "stress", "/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod0f1e2d3c.slice/cri-containerd-a1b2c3d4e5f6.scope", "/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod0f1e2d3c.slice", true},
+	}
+	for _, testCase := range testCases {
+		oomInstance := readOneOom(testCase.logFile, t)
+		if oomInstance.FromOomKillLine != testCase.fromOomKillLine {
+			t.Errorf("%s: expected FromOomKillLine to be %v", testCase.logFile, testCase.fromOomKillLine)
+		}
+		if oomInstance.Pid != testCase.pid || oomInstance.ProcessName != testCase.processName {
+			t.Errorf("%s: expected pid %d and process %s, got pid %d and process %s", testCase.logFile, testCase.pid, testCase.processName, oomInstance.Pid, oomInstance.ProcessName)
+		}
+		if oomInstance.ContainerName != testCase.containerName {
+			t.Errorf("%s: expected container %s, got %s", testCase.logFile, testCase.containerName, oomInstance.ContainerName)
+		}
+		if oomInstance.VictimContainerName != testCase.victimContainerName {
+			t.Errorf("%s: expected victim container %s, got %s", testCase.logFile, testCase.victimContainerName, oomInstance.VictimContainerName)
+		}
+	}
+}
+
+func TestGetMemoryLimit(t *testing.T) {
+	pageSize := uint64(os.Getpagesize())
+	testCases := []struct {
+		line     string
+		expected uint64
+	}{
+		// Linux 3.13, cgroup v1.
+		{"Jan  5 15:19:27 kernel: [ 5864.708495] memory: usage 980kB, limit 980kB, failcnt 4152239", 980 * 1024},
+		// Linux 4.14, cgroup v1.
+		{"Jun 10 09:12:31 node1 kernel: [ 1234.567891] memory: usage 524288kB, limit 524288kB, failcnt 1520", 524288 * 1024},
+		// Kernels that print page counts.
+		{"Jun 10 09:12:31 node1 kernel: [ 1234.567891] memory: usage 2048, limit 2048, failcnt 3", 2048 * pageSize},
+		// Unlimited, as printed by res_counter and page_counter kernels.
+		{"Jan  5 15:19:27 kernel: [ 5864.708495] memory: usage 980kB, limit 18014398509481983kB, failcnt 0", 0},
+		{"Jun 10 09:12:31 node1 kernel: [ 1234.567891] memory: usage 524288kB, limit 9007199254740988kB, failcnt 0", 0},
+		{"Jun 10 09:12:31 node1 kernel: [ 1234.567891] memory: usage 2048, limit 2251799813685247, failcnt 0", 0},
+	}
+	for _, testCase := range testCases {
+		currentOomInstance := new(OomInstance)
+		if err := getMemoryLimit(testCase.line, currentOomInstance); err != nil {
+			t.Errorf("memory line %q fed to getMemoryLimit should yield no error, but had error %v", testCase.line, err)
+		}
+		if currentOomInstance.MemoryLimitBytes != testCase.expected {
+			t.Errorf("getMemoryLimit should have set MemoryLimitBytes for %q to %d, not %d", testCase.line, testCase.expected, currentOomInstance.MemoryLimitBytes)
+		}
+	}
+
+	currentOomInstance := new(OomInstance)
+	swapLine := "Jan  5 15:19:27 kernel: [ 5864.708495] memory+swap: usage 0kB, limit 2048kB, failcnt 0"
+	if err := getMemoryLimit(swapLine, currentOomInstance); err != nil {
+		t.Errorf("memory+swap line fed to getMemoryLimit should yield no error, but had error %v", err)
+	}
+	if currentOomInstance.MemoryLimitBytes != 0 {
+		t.Errorf("memory+swap line should not set MemoryLimitBytes, but it was set to %d", currentOomInstance.MemoryLimitBytes)
+	}
+}
+
+func TestStreamOomsMemoryLimit(t *testing.T) {
+	testCases := []struct {
+		logFile  string
+		expected uint64
+	}{
+		// kB limits, from Linux 3.13, 4.15 and 5.10.
+		{containerLogFile, 980 * 1024},
+		{kubepodsLogFile, 262144 * 1024},
+		{cgroupv2LogFile, 131072 * 1024},
+		// A limit printed as a page count.
+		{pagesLogFile, 65536 * uint64(os.Getpagesize())},
+	}
+	for _, testCase := range testCases {
+		oomInstance := readOneOom(testCase.logFile, t)
+		if oomInstance.MemoryLimitBytes != testCase.expected {
+			t.Errorf("%s: expected memory limit %d, got %d", testCase.logFile, testCase.expected, oomInstance.MemoryLimitBytes)
+		}
+	}
+}
+
 func TestGetProcessNamePid(t *testing.T) {
 	currentOomInstance := new(OomInstance)
-	couldParseLine, err := getProcessNamePid(startLine, currentOomInstance)
+	now := time.Date(2016, time.March, 1, 12, 0, 0, 0, time.Local)
+	couldParseLine, err := DefaultMatchers.getProcessNamePid(startLine, now, currentOomInstance)
 	if err != nil {
 		t.Errorf("bad line fed to getProcessNamePid should yield no error, but had error %v", err)
 	}
@@ -93,10 +516,8 @@ func TestGetProcessNamePid(t *testing.T) {
 		t.Errorf("bad line fed to getProcessNamePid should return false but returned %v", couldParseLine)
 	}
 
-	const longForm = "Jan _2 15:04:05 2006"
-	stringYear := strconv.Itoa(time.Now().Year())
-	correctTime, err := time.ParseInLocation(longForm, fmt.Sprintf("Jan 21 22:01:49 %s", stringYear), time.Local)
-	couldParseLine, err = getProcessNamePid(endLine, currentOomInstance)
+	correctTime := time.Date(2016, time.January, 21, 22, 1, 49, 0, time.Local)
+	couldParseLine, err = DefaultMatchers.getProcessNamePid(endLine, now, currentOomInstance)
 	if err != nil {
 		t.Errorf("good line fed to getProcessNamePid should yield no error, but had error %v", err)
 	}
@@ -114,56 +535,2764 @@ func TestGetProcessNamePid(t *testing.T) {
 	}
 }
 
-func TestCheckIfStartOfMessages(t *testing.T) {
-	couldParseLine := checkIfStartOfOomMessages(endLine)
-	if couldParseLine {
-		t.Errorf("bad line fed to checkIfStartOfMessages should return false but returned %v", couldParseLine)
+func TestLargePid(t *testing.T) {
+	testCases := []struct {
+		pid         string
+		expected    int
+		parseErrors int
+	}{
+		{fmt.Sprint(math.MaxInt), math.MaxInt, 0},
+		// Too large to parse, but the OOM is still sent.
+		{fmt.Sprint(uint64(math.MaxInt) + 1), 0, 1},
+		{"99999999999999999999999999", 0, 1},
 	}
-	couldParseLine = checkIfStartOfOomMessages(startLine)
-	if !couldParseLine {
-		t.Errorf("start line fed to checkIfStartOfMessages should return true but returned %v", couldParseLine)
+	for _, testCase := range testCases {
+		lastLine := strings.Replace(endLineWithScoreAdj, "19667", testCase.pid, 1)
+		metrics := &fakeMetrics{}
+		oomLog := NewFromReader(strings.NewReader(startLine + "\n" + containerLine + "\n" + lastLine + "\n"))
+		oomLog.Metrics = metrics
+		oomLog.CloseStreamOnExit = true
+		outStream := make(chan *OomInstance)
+		go oomLog.StreamOoms(outStream)
+		var oomInstances []*OomInstance
+		for oomInstance := range outStream {
+			oomInstances = append(oomInstances, oomInstance)
+		}
+		if len(oomInstances) != 1 {
+			t.Errorf("expected an OOM from %q, got %d", lastLine, len(oomInstances))
+			continue
+		}
+		oomInstance := oomInstances[0]
+		if oomInstance.Pid != testCase.expected || oomInstance.VictimGlobalPid != testCase.expected {
+			t.Errorf("expected pid %d from %q, got %d", testCase.expected, lastLine, oomInstance.Pid)
+		}
+		if oomInstance.ProcessName != "evilprogram2" || !oomInstance.HasOomScoreAdj || oomInstance.TimeOfDeath.IsZero() {
+			t.Errorf("expected the rest of %q to be parsed, got %+v", lastLine, oomInstance)
+		}
+		if metrics.parseErrors != testCase.parseErrors {
+			t.Errorf("expected %d parse errors from %q, got %d", testCase.parseErrors, lastLine, metrics.parseErrors)
+		}
+
+		currentOomInstance := &OomInstance{VictimUID: -1}
+		summary := oomKillLine[:strings.Index(oomKillLine, "pid=")] + "pid=" + testCase.pid + ",uid=2000"
+		err := DefaultMatchers.getContainerName(summary, currentOomInstance)
+		if (err != nil) != (testCase.parseErrors != 0) || currentOomInstance.Pid != testCase.expected || currentOomInstance.VictimUID != 2000 {
+			t.Errorf("expected pid %d and uid 2000 from %q, got %d and %d with error %v", testCase.expected, summary, currentOomInstance.Pid, currentOomInstance.VictimUID, err)
+		}
 	}
 }
 
-func TestStreamOomsContainer(t *testing.T) {
-	expectedContainerOomInstance := createExpectedContainerOomInstance(t)
-	helpTestStreamOoms(expectedContainerOomInstance, containerLogFile, t)
+func TestNoKillableProcesses(t *testing.T) {
+	testCases := []struct {
+		line  string
+		dated bool
+	}{
+		{"Jan 21 22:01:49 localhost kernel: [62279.421192] Out of memory and no killable processes...", true},
+		{"2016-01-21T22:01:49+0000 localhost kernel: Out of memory and no killable processes...", true},
+		{"[62279.421192] Out of memory and no killable processes...", false},
+	}
+	for _, testCase := range testCases {
+		lines := []string{
+			startLine,
+			containerLine,
+			"Jan 21 22:01:49 localhost kernel: [62279.421190] [ pid ]   uid  tgid total_vm      rss nr_ptes swapents oom_score_adj name",
+			"Jan 21 22:01:49 localhost kernel: [62279.421191] [19667]  2000 19667   365004   353502     701        0         -1000 evilprogram2",
+			testCase.line,
+			// The next OOM is not taken for the same one.
+			startLine,
+			containerLine,
+			endLine,
+		}
+		oomInstances, err := ParseAll(strings.NewReader(strings.Join(lines, "\n") + "\n"))
+		if err != nil || len(oomInstances) != 2 {
+			t.Fatalf("%q: expected 2 OOMs, got %v and %v", testCase.line, oomInstances, err)
+		}
+		oomInstance := oomInstances[0]
+		if !oomInstance.NoKillableProcesses || oomInstance.Pid != 0 || oomInstance.ProcessName != "" || oomInstance.ContainerName != "/mem2" || oomInstance.Partial {
+			t.Errorf("%q: expected an OOM in /mem2 with no process killed, got %+v", testCase.line, oomInstance)
+		}
+		if !testCase.dated && !oomInstance.TimeOfDeath.IsZero() {
+			t.Errorf("%q: expected no time of death from an undated line, got %v", testCase.line, oomInstance.TimeOfDeath)
+		}
+		if when := oomInstance.TimeOfDeath.Format("Jan _2 15:04:05"); testCase.dated && when != "Jan 21 22:01:49" {
+			t.Errorf("%q: expected the time of death to be Jan 21 22:01:49, got %v", testCase.line, oomInstance.TimeOfDeath)
+		}
+		if oomInstance := oomInstances[1]; oomInstance.NoKillableProcesses || oomInstance.Pid != 19667 {
+			t.Errorf("%q: expected the next OOM to kill pid 19667, got %+v", testCase.line, oomInstance)
+		}
+	}
 }
 
-func TestStreamOomsSystem(t *testing.T) {
-	expectedSystemOomInstance := createExpectedSystemOomInstance(t)
-	helpTestStreamOoms(expectedSystemOomInstance, systemLogFile, t)
+func TestNowFunc(t *testing.T) {
+	newYear := time.Date(2017, time.January, 1, 0, 5, 0, 0, time.Local)
+	testCases := []struct {
+		now      time.Time
+		date     string
+		expected time.Time
+	}{
+		// Just after midnight on New Year's Day, a line from just before
+		// it is from the year before.
+		{newYear, "Dec 31 23:59:58", time.Date(2016, time.December, 31, 23, 59, 58, 0, time.Local)},
+		{newYear, "Jan  1 00:04:59", time.Date(2017, time.January, 1, 0, 4, 59, 0, time.Local)},
+		// A clock a little behind the log's is allowed for.
+		{newYear, "Jan  1 12:00:00", time.Date(2017, time.January, 1, 12, 0, 0, 0, time.Local)},
+		{newYear, "Jan  3 00:00:00", time.Date(2016, time.January, 3, 0, 0, 0, 0, time.Local)},
+	}
+	for _, testCase := range testCases {
+		input := startLine + "\n" + containerLine + "\n" + strings.Replace(endLine, "Jan 21 22:01:49", testCase.date, 1) + "\n"
+		oomLog := NewFromReader(strings.NewReader(input))
+		oomLog.nowFunc = func() time.Time { return testCase.now }
+		outStream := make(chan *OomInstance, 1)
+		if err := oomLog.StreamOomsContext(context.Background(), outStream); err != io.EOF {
+			t.Fatalf("expected the stream to end with %v, got %v", io.EOF, err)
+		}
+		oomInstance := <-outStream
+		if !oomInstance.TimeOfDeath.Equal(testCase.expected) {
+			t.Errorf("expected %q at %v to be %v, got %v", testCase.date, testCase.now, testCase.expected, oomInstance.TimeOfDeath)
+		}
+		if stats := oomLog.Stats(); !stats.LastSent.Equal(testCase.now) {
+			t.Errorf("expected the OOM to have been sent at %v, got %v", testCase.now, stats.LastSent)
+		}
+	}
+
+	oomLog := NewFromLmkd(strings.NewReader("12-31 23:59:58.250  1203  1203 I lowmemorykiller: Kill 'com.android.chrome' (7609), uid 10085, oom_score_adj 900 to free 45000kB\n"))
+	oomLog.nowFunc = func() time.Time { return newYear }
+	outStream := make(chan *OomInstance, 1)
+	oomLog.StreamOomsContext(context.Background(), outStream)
+	select {
+	case oomInstance := <-outStream:
+		if expected := time.Date(2016, time.December, 31, 23, 59, 58, 250000000, time.Local); !oomInstance.TimeOfDeath.Equal(expected) {
+			t.Errorf("expected the low memory killer's kill at %v, got %v", expected, oomInstance.TimeOfDeath)
+		}
+	default:
+		t.Error("expected a kill from the low memory killer")
+	}
 }
 
-func helpTestStreamOoms(oomCheckInstance *OomInstance, sysFile string, t *testing.T) {
-	outStream := make(chan *OomInstance)
-	oomLog := mockOomParser(sysFile, t)
-	timeout := make(chan bool, 1)
-	go func() {
-		time.Sleep(1 * time.Second)
-		timeout <- true
-	}()
+func TestGetProcessNamePidOomScoreAdj(t *testing.T) {
+	currentOomInstance := new(OomInstance)
+	if _, err := DefaultMatchers.getProcessNamePid(endLine, time.Now(), currentOomInstance); err != nil {
+		t.Errorf("good line fed to getProcessNamePid should yield no error, but had error %v", err)
+	}
+	if currentOomInstance.HasOomScoreAdj {
+		t.Errorf("line without oom_score_adj should leave HasOomScoreAdj unset, but OomScoreAdj was %d", currentOomInstance.OomScoreAdj)
+	}
+
+	currentOomInstance = new(OomInstance)
+	if _, err := DefaultMatchers.getProcessNamePid(endLineWithScoreAdj, time.Now(), currentOomInstance); err != nil {
+		t.Errorf("good line fed to getProcessNamePid should yield no error, but had error %v", err)
+	}
+	if !currentOomInstance.HasOomScoreAdj {
+		t.Errorf("line with oom_score_adj should set HasOomScoreAdj")
+	}
+	if currentOomInstance.OomScoreAdj != -998 {
+		t.Errorf("getProcessNamePid should have set OomScoreAdj to -998, not %d", currentOomInstance.OomScoreAdj)
+	}
+}
+
+func TestGetProcessNamePidIso(t *testing.T) {
+	testCases := []struct {
+		line     string
+		expected time.Time
+	}{
+		{"2016-01-21T22:01:49+0000 localhost kernel: Killed process 19667 (evilprogram2) total-vm:1460016kB", time.Date(2016, time.January, 21, 22, 1, 49, 0, time.UTC)},
+		{"2016-01-21T22:01:49-08:00 localhost kernel: Killed process 19667 (evilprogram2) total-vm:1460016kB", time.Date(2016, time.January, 22, 6, 1, 49, 0, time.UTC)},
+	}
+	for _, testCase := range testCases {
+		currentOomInstance := new(OomInstance)
+		finished, err := DefaultMatchers.getProcessNamePid(testCase.line, time.Now(), currentOomInstance)
+		if err != nil {
+			t.Errorf("unexpected error parsing %q: %v", testCase.line, err)
+			continue
+		}
+		if !finished || currentOomInstance.Pid != 19667 || currentOomInstance.ProcessName != "evilprogram2" {
+			t.Errorf("expected to find the killed process in %q, got %+v", testCase.line, currentOomInstance)
+		}
+		if !currentOomInstance.TimeOfDeath.Equal(testCase.expected) {
+			t.Errorf("expected the time of death in %q to be %v, got %v", testCase.line, testCase.expected, currentOomInstance.TimeOfDeath)
+		}
+	}
+}
+
+func TestProcessNames(t *testing.T) {
+	for _, name := range []string{"ruby-timer-thr", "java.util", "my app", "kworker/u8:2", "Web Content", "évilprogram"} {
+		lines := []string{
+			"Jan 21 22:01:49 localhost kernel: [62279.421192] Killed process 19667 (" + name + ") total-vm:1460016kB",
+			"2016-01-21T22:01:49+0000 localhost kernel: Killed process 19667 (" + name + ") total-vm:1460016kB",
+		}
+		for _, line := range lines {
+			currentOomInstance := new(OomInstance)
+			finished, err := DefaultMatchers.getProcessNamePid(line, time.Now(), currentOomInstance)
+			if err != nil || !finished || currentOomInstance.Pid != 19667 || currentOomInstance.ProcessName != name {
+				t.Errorf("expected to find the killed process %q in %q, got %+v and %v", name, line, currentOomInstance, err)
+			}
+		}
+		currentOomInstance := new(OomInstance)
+		line := "Out of memory: Killed process 19667 (" + name + ") total-vm:1460016kB"
+		finished, err := DefaultMatchers.getKmsgProcessNamePid(line, time.Time{}, currentOomInstance)
+		if err != nil || !finished || currentOomInstance.Pid != 19667 || currentOomInstance.ProcessName != name {
+			t.Errorf("expected to find the killed process %q in %q, got %+v and %v", name, line, currentOomInstance, err)
+		}
+
+		oomInstance, err := ParseOomBlock([]string{startLine, containerLine, lines[0]})
+		if err != nil || oomInstance.ProcessName != name || oomInstance.ContainerName != "/mem2" {
+			t.Errorf("expected the OOM of %q in /mem2, got %v and %v", name, oomInstance, err)
+		}
+	}
+}
+
+func TestLocalizedMonths(t *testing.T) {
+	now := time.Date(2016, time.December, 31, 12, 0, 0, 0, time.Local)
+	testCases := []struct {
+		date     string
+		expected time.Time
+	}{
+		{"Jan 21 22:01:49", time.Date(2016, time.January, 21, 22, 1, 49, 0, time.Local)},
+		// German
+		{"Mär  3 22:01:49", time.Date(2016, time.March, 3, 22, 1, 49, 0, time.Local)},
+		{"Okt 21 22:01:49", time.Date(2016, time.October, 21, 22, 1, 49, 0, time.Local)},
+		// French, whose abbreviations end in a dot.
+		{"déc. 21 22:01:49", time.Date(2016, time.December, 21, 22, 1, 49, 0, time.Local)},
+		{"févr. 21 22:01:49", time.Date(2016, time.February, 21, 22, 1, 49, 0, time.Local)},
+		// Spanish and Italian
+		{"ago 21 22:01:49", time.Date(2016, time.August, 21, 22, 1, 49, 0, time.Local)},
+		{"Gen 21 22:01:49", time.Date(2016, time.January, 21, 22, 1, 49, 0, time.Local)},
+		// An unknown month still sends the OOM, without its time of death.
+		{"Xyz 21 22:01:49", time.Time{}},
+	}
+	for _, testCase := range testCases {
+		input := startLine + "\n" + containerLine + "\n" + strings.Replace(endLine, "Jan 21 22:01:49", testCase.date, 1) + "\n"
+		metrics := &fakeMetrics{}
+		oomLog := NewFromReader(strings.NewReader(input))
+		oomLog.Metrics = metrics
+		oomLog.nowFunc = func() time.Time { return now }
+		outStream := make(chan *OomInstance, 1)
+		if err := oomLog.StreamOomsContext(context.Background(), outStream); err != io.EOF {
+			t.Fatalf("expected the stream to end with %v, got %v", io.EOF, err)
+		}
+		select {
+		case oomInstance := <-outStream:
+			if !oomInstance.TimeOfDeath.Equal(testCase.expected) || oomInstance.Pid != 19667 {
+				t.Errorf("expected pid 19667 killed at %v from %q, got pid %d at %v", testCase.expected, testCase.date, oomInstance.Pid, oomInstance.TimeOfDeath)
+			}
+		default:
+			t.Errorf("expected an OOM killed at %q", testCase.date)
+		}
+		if expected := testCase.expected.IsZero(); (metrics.parseErrors != 0) != expected {
+			t.Errorf("expected a parse error for %q to be %v, got %d", testCase.date, expected, metrics.parseErrors)
+		}
+	}
+}
+
+func TestLocation(t *testing.T) {
+	now := time.Date(2016, time.March, 1, 12, 0, 0, 0, time.UTC)
+	tokyo := time.FixedZone("JST", 9*60*60)
+	testCases := []struct {
+		location *time.Location
+		expected time.Time
+	}{
+		{nil, time.Date(2016, time.January, 21, 22, 1, 49, 0, time.Local)},
+		{time.UTC, time.Date(2016, time.January, 21, 22, 1, 49, 0, time.UTC)},
+		{tokyo, time.Date(2016, time.January, 21, 13, 1, 49, 0, time.UTC)},
+	}
+	var times []time.Time
+	for _, testCase := range testCases {
+		oomLog := NewFromReader(strings.NewReader(startLine + "\n" + containerLine + "\n" + endLine + "\n"))
+		oomLog.Location = testCase.location
+		oomLog.nowFunc = func() time.Time { return now }
+		outStream := make(chan *OomInstance, 1)
+		if err := oomLog.StreamOomsContext(context.Background(), outStream); err != io.EOF {
+			t.Fatalf("expected the stream to end with %v, got %v", io.EOF, err)
+		}
+		oomInstance := <-outStream
+		if !oomInstance.TimeOfDeath.Equal(testCase.expected) {
+			t.Errorf("expected the time of death in %v to be %v, got %v", testCase.location, testCase.expected, oomInstance.TimeOfDeath)
+		}
+		times = append(times, oomInstance.TimeOfDeath)
+	}
+	if difference := times[1].Sub(times[2]); difference != 9*time.Hour {
+		t.Errorf("expected the same date in UTC to be 9h after it is in %v, got %v", tokyo, difference)
+	}
+
+	// The low memory killer's dates are in the Location too.
+	oomLog := NewFromLmkd(strings.NewReader("02-28 09:12:01.123  1203  1203 I lowmemorykiller: Kill 'com.android.chrome' (7609), uid 10085, oom_score_adj 900 to free 45000kB\n"))
+	oomLog.Location = tokyo
+	oomLog.nowFunc = func() time.Time { return now }
+	outStream := make(chan *OomInstance, 1)
+	oomLog.StreamOomsContext(context.Background(), outStream)
+	select {
+	case oomInstance := <-outStream:
+		if expected := time.Date(2016, time.February, 28, 0, 12, 1, 123000000, time.UTC); !oomInstance.TimeOfDeath.Equal(expected) {
+			t.Errorf("expected the low memory killer's kill at %v, got %v", expected, oomInstance.TimeOfDeath)
+		}
+	default:
+		t.Error("expected a kill from the low memory killer")
+	}
+}
+
+func TestParseSyslogTimeYearBoundary(t *testing.T) {
+	const longForm = "Jan _2 15:04:05 2006"
+	testCases := []struct {
+		timestamp string
+		now       string
+		expected  string
+	}{
+		// A December message read just after New Year is from last year.
+		{"Dec 31 23:59:58", "Jan  1 00:00:03 2016", "Dec 31 23:59:58 2015"},
+		{"Dec 24 10:00:00", "Jan 20 09:00:00 2016", "Dec 24 10:00:00 2015"},
+		// Messages from earlier in the current year keep it.
+		{"Jan  1 00:00:01", "Jan  1 00:00:03 2016", "Jan  1 00:00:01 2016"},
+		{"Jun 30 12:00:00", "Dec 31 23:00:00 2015", "Jun 30 12:00:00 2015"},
+		// A slightly fast kernel clock does not put a message in last year.
+		{"Mar  8 12:00:30", "Mar  8 12:00:00 2016", "Mar  8 12:00:30 2016"},
+		// Leap days only parse in the right year.
+		{"Feb 29 08:00:00", "Jan  3 00:00:00 2017", "Feb 29 08:00:00 2016"},
+	}
+	for _, testCase := range testCases {
+		now, err := time.ParseInLocation(longForm, testCase.now, time.Local)
+		if err != nil {
+			t.Fatalf("could not parse now %q: %v", testCase.now, err)
+		}
+		expected, err := time.ParseInLocation(longForm, testCase.expected, time.Local)
+		if err != nil {
+			t.Fatalf("could not parse expected time %q: %v", testCase.expected, err)
+		}
+		parsed, err := parseSyslogTime(testCase.timestamp, now)
+		if err != nil {
+			t.Errorf("parseSyslogTime(%q) at %v had error %v", testCase.timestamp, now, err)
+			continue
+		}
+		if !parsed.Equal(expected) {
+			t.Errorf("parseSyslogTime(%q) at %v should be %v, not %v", testCase.timestamp, now, expected, parsed)
+		}
+	}
+}
 
+func TestStreamOomsKmsg(t *testing.T) {
+	input := strings.Join([]string{
+		"6,1929,5864708001,-;usb 1-1: new high-speed USB device number 2 using xhci_hcd",
+		" SUBSYSTEM=usb",
+		" DEVICE=c189:1",
+		"4,1930,5864708440,-;memorymonster invoked oom-killer: gfp_mask=0xd0, order=0, oom_score_adj=0",
+		"6,1931,5864708443,-;memorymonster cpuset=/ mems_allowed=0",
+		"4,1932,5864708446,-;CPU: 5 PID: 13536 Comm: memorymonster Tainted: P           OX 3.13.0-43-generic #72-Ubuntu",
+		"6,1933,5864708493,-;Task in /mem2 killed as a result of limit of /mem3",
+		"6,1934,5864708495,-;memory: usage 980kB, limit 980kB, failcnt 4152239",
+		"3,1935,5864708607,-;Memory cgroup out of memory: Kill process 13536 (memorymonster) score 996 or sacrifice child",
+		"3,1936,5864708608,-;Killed process 13536 (memorymonster) total-vm:33558652kB, anon-rss:920kB, file-rss:452kB",
+	}, "\n") + "\n"
+	bootTime := time.Date(2015, time.January, 5, 13, 41, 43, 0, time.Local)
+	oomLog := newKmsgOomParser(strings.NewReader(input), bootTime)
+	outStream := make(chan *OomInstance)
 	go oomLog.StreamOoms(outStream)
 
 	select {
 	case oomInstance := <-outStream:
-		if reflect.DeepEqual(*oomCheckInstance, *oomInstance) {
-			t.Errorf("wrong instance returned. Expected %v and got %v",
-				oomCheckInstance, oomInstance)
+		expectedTime := bootTime.Add(5864708608 * time.Microsecond)
+		if !oomInstance.TimeOfDeath.Equal(expectedTime) {
+			t.Errorf("expected time of death %v from the kmsg timestamp, got %v", expectedTime, oomInstance.TimeOfDeath)
 		}
-	case <-timeout:
-		t.Error(
-			"timeout happened before oomInstance was found in test file")
+		if oomInstance.Pid != 13536 || oomInstance.ProcessName != "memorymonster" {
+			t.Errorf("expected pid 13536 and process memorymonster, got %v", oomInstance)
+		}
+		if oomInstance.ContainerName != "/mem2" || oomInstance.VictimContainerName != "/mem3" {
+			t.Errorf("expected container /mem2 and victim /mem3, got %v", oomInstance)
+		}
+		if oomInstance.MemoryLimitBytes != 980*1024 {
+			t.Errorf("expected memory limit %d, got %d", 980*1024, oomInstance.MemoryLimitBytes)
+		}
+	case <-time.After(1 * time.Second):
+		t.Error("timeout happened before oomInstance was found in kmsg records")
 	}
 }
 
-func mockOomParser(sysFile string, t *testing.T) *OomParser {
-	file, err := os.Open(sysFile)
+func TestStreamOomsKmsgFile(t *testing.T) {
+	file, err := os.Open(kmsgLogFile)
 	if err != nil {
-		t.Errorf("had an error opening file: %v", err)
+		t.Fatalf("had an error opening file: %v", err)
+	}
+	bootTime := time.Date(2022, time.September, 2, 12, 0, 53, 0, time.UTC)
+	oomLog := newKmsgOomParser(file, bootTime)
+	oomLog.CloseStreamOnExit = true
+	outStream := make(chan *OomInstance)
+	go oomLog.StreamOoms(outStream)
+
+	var oomInstances []*OomInstance
+	for oomInstance := range outStream {
+		oomInstances = append(oomInstances, oomInstance)
+	}
+	if len(oomInstances) != 1 {
+		t.Fatalf("expected one OOM in %s, got %v", kmsgLogFile, oomInstances)
+	}
+	oomInstance := oomInstances[0]
+	expected := &OomInstance{
+		Pid:                 48213,
+		ProcessName:         "stress",
+		TimeOfDeath:         bootTime.Add(9012345110 * time.Microsecond),
+		EventSeq:            1,
+		ContainerName:       "/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod0f1e2d3c.slice/cri-containerd-a1b2c3d4e5f6.scope",
+		VictimContainerName: "/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod0f1e2d3c.slice",
+		OomScoreAdj:         984,
+		HasOomScoreAdj:      true,
+		MemoryLimitBytes:    131072 * 1024,
+		VictimRSSPages:      32174,
+		FromOomKillLine:     true,
+		Constraint:          ConstraintMemcg,
+		Scope:               ScopeMemcg,
+		MemsAllowed:         "0",
+		InvokingProcess:     "stress",
+		AllocationOrder:     0,
+		InvokingTid:         48213,
+		SelfKill:            true,
+		GfpMask:             "0xcc0(GFP_KERNEL)",
+		VictimUID:           0,
+		VictimTotalVMPages:  33597,
+		VictimPgTablesBytes: 307200,
+		VictimGlobalPid:     48213,
+		LogLevel:            4,
+	}
+	if !reflect.DeepEqual(oomInstance, expected) {
+		t.Errorf("expected %#v, got %#v", expected, oomInstance)
+	}
+	if oomLog.lostKmsgRecs != 0 {
+		t.Errorf("expected no records to be lost, got %d", oomLog.lostKmsgRecs)
+	}
+}
+
+func TestStreamOomsKilledProcessWording(t *testing.T) {
+	bootTime := time.Date(2021, time.March, 4, 9, 0, 0, 0, time.UTC)
+	for _, killedLine := range []string{
+		"Out of memory: Killed process 4711 (stress) total-vm:1051708kB, anon-rss:1048576kB, file-rss:4kB, shmem-rss:0kB, UID:1000 pgtables:2096kB oom_score_adj:0",
+		"Memory cgroup out of memory: Killed process 4711 (stress) total-vm:1051708kB, anon-rss:1048576kB, file-rss:4kB, shmem-rss:0kB, UID:1000 pgtables:2096kB oom_score_adj:0",
+	} {
+		input := strings.Join([]string{
+			"4,5120,7200000000,-;stress invoked oom-killer: gfp_mask=0xcc0(GFP_KERNEL), order=0, oom_score_adj=0",
+			"6,5121,7200000100,-;oom-kill:constraint=CONSTRAINT_MEMCG,nodemask=(null),cpuset=/,mems_allowed=0,oom_memcg=/stress,task_memcg=/stress,task=stress,pid=4711,uid=1000",
+			"3,5122,7200000200,-;" + killedLine,
+		}, "\n") + "\n"
+		oomLog := newKmsgOomParser(strings.NewReader(input), bootTime)
+		outStream := make(chan *OomInstance)
+		go oomLog.StreamOoms(outStream)
+		select {
+		case oomInstance := <-outStream:
+			expectedTime := bootTime.Add(7200000200 * time.Microsecond)
+			if oomInstance.Pid != 4711 || oomInstance.ProcessName != "stress" || !oomInstance.TimeOfDeath.Equal(expectedTime) {
+				t.Errorf("%q: expected stress (4711) killed at %v, got %v", killedLine, expectedTime, oomInstance)
+			}
+		case <-time.After(1 * time.Second):
+			t.Errorf("%q: timeout happened before oomInstance was found", killedLine)
+		}
+	}
+
+	// dmesg output has no date, only the printk timestamp.
+	input := strings.Join([]string{
+		"[ 7200.000000] stress invoked oom-killer: gfp_mask=0xcc0(GFP_KERNEL), order=0, oom_score_adj=0",
+		"[ 7200.000100] oom-kill:constraint=CONSTRAINT_MEMCG,nodemask=(null),cpuset=/,mems_allowed=0,oom_memcg=/stress,task_memcg=/stress,task=stress,pid=4711,uid=1000",
+		"[ 7200.000200] Memory cgroup out of memory: Killed process 4711 (stress) total-vm:1051708kB, anon-rss:1048576kB, file-rss:4kB, shmem-rss:0kB, UID:1000 pgtables:2096kB oom_score_adj:0",
+	}, "\n") + "\n"
+	oomLog := NewFromReader(strings.NewReader(input))
+	outStream := make(chan *OomInstance)
+	go oomLog.StreamOoms(outStream)
+	select {
+	case oomInstance := <-outStream:
+		if oomInstance.Pid != 4711 || oomInstance.ContainerName != "/stress" || !oomInstance.TimeOfDeath.IsZero() {
+			t.Errorf("expected stress (4711) in /stress with no time of death from dmesg, got %v", oomInstance)
+		}
+	case <-time.After(1 * time.Second):
+		t.Error("timeout happened before oomInstance was found in dmesg output")
+	}
+}
+
+func TestNewAuto(t *testing.T) {
+	dump := func(pid int) string {
+		return startLine + "\n" + containerLine + "\n" + fmt.Sprintf("Jan 21 22:01:49 localhost kernel: [62279.421192] Killed process %d (evilprogram2)", pid) + "\n"
+	}
+	var opened []string
+	source := func(name string, in io.Reader, err error) autoSource {
+		return autoSource{name, func() (*OomParser, error) {
+			opened = append(opened, name)
+			if err != nil {
+				return nil, err
+			}
+			return NewFromReader(in), nil
+		}}
+	}
+	readErr := fmt.Errorf("read failed")
+	sources := func() []autoSource {
+		return []autoSource{
+			source("kmsg", nil, &KmsgError{Reason: ErrKmsgNotPermitted, Err: fmt.Errorf("open /dev/kmsg: operation not permitted")}),
+			source("journald", &failingReader{strings.NewReader(dump(100)), readErr}, nil),
+			source("broken", nil, fmt.Errorf("cannot open")),
+			source("logfile", strings.NewReader(dump(101)), nil),
+		}
+	}
+
+	for _, failover := range []bool{false, true} {
+		opened = nil
+		oomLog, name, err := newAuto(sources())
+		if err != nil || name != "journald" || oomLog.Source() != "journald" {
+			t.Fatalf("expected journald to be chosen, got %q and %v", name, err)
+		}
+		oomLog.Failover = failover
+		oomLog.CloseStreamOnExit = true
+		outStream := make(chan *OomInstance)
+		streamErr := make(chan error, 1)
+		go func() {
+			streamErr <- oomLog.StreamOomsContext(context.Background(), outStream)
+		}()
+		var pids []int
+		for oomInstance := range outStream {
+			pids = append(pids, oomInstance.Pid)
+		}
+		err = <-streamErr
+		if !failover {
+			if !reflect.DeepEqual(pids, []int{100}) || err != readErr || !reflect.DeepEqual(opened, []string{"kmsg", "journald"}) {
+				t.Errorf("expected only journald to be read before it failed with %v, got pids %v and %v after opening %v", readErr, pids, err, opened)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(pids, []int{100, 101}) || err != io.EOF || oomLog.Source() != "logfile" {
+			t.Errorf("expected journald then logfile to be read to the end, got pids %v and %v from %q", pids, err, oomLog.Source())
+		}
+		if expected := []string{"kmsg", "journald", "broken", "logfile"}; !reflect.DeepEqual(opened, expected) {
+			t.Errorf("expected the sources to be opened in order %v, got %v", expected, opened)
+		}
+	}
+
+	// The KmsgError is more useful than why the last source failed.
+	_, _, err := newAuto(sources()[:1])
+	if !errors.Is(err, ErrKmsgNotPermitted) || !errors.Is(err, ErrNoKernelLog) {
+		t.Errorf("expected %v and %v, got %v", ErrNoKernelLog, ErrKmsgNotPermitted, err)
+	}
+	_, _, err = newAuto(append(sources()[:1], sources()[2]))
+	var kmsgErr *KmsgError
+	if !errors.Is(err, ErrKmsgNotPermitted) || !errors.Is(err, ErrNoKernelLog) || !errors.As(err, &kmsgErr) {
+		t.Errorf("expected %v and a KmsgError for %v, got %v", ErrNoKernelLog, ErrKmsgNotPermitted, err)
+	}
+	_, _, err = newAuto(sources()[2:3])
+	if !errors.Is(err, ErrNoKernelLog) || errors.As(err, &kmsgErr) || !strings.Contains(err.Error(), "cannot open") {
+		t.Errorf("expected %v with why the last source failed, got %v", ErrNoKernelLog, err)
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	reader, writer := io.Pipe()
+	defer writer.Close()
+	oomLog := NewFromReader(reader)
+	beats := make(chan time.Time, 100)
+	oomLog.Heartbeat = func(now time.Time) {
+		beats <- now
+	}
+	oomLog.HeartbeatInterval = 10 * time.Millisecond
+	outStream := make(chan *OomInstance)
+	finished := make(chan struct{})
+	go func() {
+		oomLog.StreamOoms(outStream)
+		close(finished)
+	}()
+
+	var last time.Time
+	for i := 0; i < 3; i++ {
+		select {
+		case now := <-beats:
+			if !now.After(last) {
+				t.Errorf("expected heartbeat %d to be after %v, got %v", i, last, now)
+			}
+			last = now
+		case <-time.After(1 * time.Second):
+			t.Fatalf("timeout happened before heartbeat %d", i)
+		}
+	}
+
+	oomLog.Close()
+	select {
+	case <-finished:
+	case <-time.After(1 * time.Second):
+		t.Fatal("timeout happened before the stream ended after Close")
 	}
-	return &OomParser{
-		ioreader: bufio.NewReader(file),
+	// Drain the beats that raced with Close.
+	for len(beats) > 0 {
+		<-beats
+	}
+	select {
+	case now := <-beats:
+		t.Errorf("expected no heartbeats once closed, got one at %v", now)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestFlushPartialAfter(t *testing.T) {
+	reader, writer := io.Pipe()
+	defer writer.Close()
+	oomLog := NewFromReader(reader)
+	defer oomLog.Close()
+	metrics := &fakeMetrics{}
+	oomLog.Metrics = metrics
+	oomLog.FlushPartialAfter = 20 * time.Millisecond
+	outStream := make(chan *OomInstance)
+	go oomLog.StreamOoms(outStream)
+
+	if _, err := io.WriteString(writer, startLine+"\n"+containerLine+"\n"); err != nil {
+		t.Fatalf("failed to write the start of the dump: %v", err)
+	}
+	var partial *OomInstance
+	select {
+	case partial = <-outStream:
+	case <-time.After(1 * time.Second):
+		t.Fatal("timeout happened before the partial OOM was flushed")
+	}
+	if !partial.Partial || partial.ContainerName != "/mem2" || partial.VictimContainerName != "/mem3" || partial.Pid != 0 || !partial.TimeOfDeath.IsZero() {
+		t.Errorf("expected a partial OOM in /mem2 without a victim, got %+v", partial)
+	}
+
+	if _, err := io.WriteString(writer, endLine+"\n"); err != nil {
+		t.Fatalf("failed to write the end of the dump: %v", err)
+	}
+	var complete *OomInstance
+	select {
+	case complete = <-outStream:
+	case <-time.After(1 * time.Second):
+		t.Fatal("timeout happened before the complete OOM was sent")
+	}
+	if complete.Partial || complete.ContainerName != "/mem2" || complete.Pid != 19667 {
+		t.Errorf("expected the complete OOM of pid 19667 in /mem2, got %+v", complete)
+	}
+	if complete.EventSeq != partial.EventSeq+1 {
+		t.Errorf("expected the complete OOM to follow the partial one, got EventSeqs %d and %d", partial.EventSeq, complete.EventSeq)
+	}
+	// The partial OOM is not counted as parsed.
+	if metrics.parsed != 1 {
+		t.Errorf("expected 1 OOM parsed, got %d", metrics.parsed)
+	}
+
+	// Each OOM is only flushed once, and not once it is complete.
+	select {
+	case oomInstance := <-outStream:
+		t.Errorf("expected nothing more to be sent, got %+v", oomInstance)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestStreamOomsHistorical(t *testing.T) {
+	dump := func(seq int, usec int64, pid int) string {
+		return strings.Join([]string{
+			fmt.Sprintf("4,%d,%d,-;ruby invoked oom-killer: gfp_mask=0x201da, order=0, oom_score_adj=0", seq, usec),
+			fmt.Sprintf("6,%d,%d,-;Task in /mem2 killed as a result of limit of /mem3", seq+1, usec),
+			fmt.Sprintf("3,%d,%d,-;Killed process %d (evilprogram2) total-vm:1460016kB", seq+2, usec, pid),
+		}, "\n") + "\n"
+	}
+	bootTime := time.Date(2016, time.March, 1, 0, 0, 0, 0, time.UTC)
+	reader, writer := io.Pipe()
+	oomLog := newKmsgOomParser(reader, bootTime)
+	oomLog.historyEnd = bootTime.Add(2 * time.Second)
+	defer oomLog.Close()
+	outStream := make(chan *OomInstance)
+	go oomLog.StreamOoms(outStream)
+
+	go io.WriteString(writer, dump(1, 1000000, 100)+dump(4, 2000000, 101)+dump(7, 3000000, 102))
+	for _, expected := range []struct {
+		pid        int
+		historical bool
+	}{{100, true}, {101, true}, {102, false}} {
+		select {
+		case oomInstance := <-outStream:
+			if oomInstance.Pid != expected.pid || oomInstance.Historical != expected.historical {
+				t.Errorf("expected pid %d with Historical %v, got %d with %v", expected.pid, expected.historical, oomInstance.Pid, oomInstance.Historical)
+			}
+		case <-time.After(1 * time.Second):
+			t.Fatalf("timeout happened before pid %d was found", expected.pid)
+		}
+	}
+
+	// A live source has no history.
+	if oomInstance := readOneOom(containerLogFile, t); oomInstance.Historical {
+		t.Errorf("expected the OOM in %s not to be historical", containerLogFile)
+	}
+}
+
+func TestMaxHistoryAge(t *testing.T) {
+	dump := func(seq int, usec int64, pid int) string {
+		return strings.Join([]string{
+			fmt.Sprintf("4,%d,%d,-;ruby invoked oom-killer: gfp_mask=0x201da, order=0, oom_score_adj=0", seq, usec),
+			fmt.Sprintf("3,%d,%d,-;Killed process %d (evilprogram2) total-vm:1460016kB", seq+1, usec, pid),
+		}, "\n") + "\n"
+	}
+	bootTime := time.Date(2016, time.March, 1, 0, 0, 0, 0, time.UTC)
+	// Replayed OOMs an hour and a minute before the parser was created,
+	// then a new one.
+	input := dump(1, 3600000000, 100) + dump(3, 7140000000, 101) + dump(5, 7300000000, 102)
+	oomLog := newKmsgOomParser(strings.NewReader(input), bootTime)
+	oomLog.historyEnd = bootTime.Add(2 * time.Hour)
+	oomLog.MaxHistoryAge = 10 * time.Minute
+	oomLog.CloseStreamOnExit = true
+	outStream := make(chan *OomInstance)
+	go oomLog.StreamOoms(outStream)
+
+	var pids []int
+	var historical []bool
+	for oomInstance := range outStream {
+		pids = append(pids, oomInstance.Pid)
+		historical = append(historical, oomInstance.Historical)
+	}
+	if !reflect.DeepEqual(pids, []int{101, 102}) || !reflect.DeepEqual(historical, []bool{true, false}) {
+		t.Errorf("expected pids [101 102] with Historical [true false], got %v with %v", pids, historical)
+	}
+	if skipped := oomLog.SkippedHistoricalOoms(); skipped != 1 {
+		t.Errorf("expected 1 OOM to be skipped, got %d", skipped)
+	}
+}
+
+func TestSplitKmsgLine(t *testing.T) {
+	bootTime := time.Date(2016, time.March, 1, 0, 0, 0, 0, time.UTC)
+	oomLog := newKmsgOomParser(strings.NewReader(""), bootTime)
+
+	message, lineTime, isMessage := oomLog.splitKmsgLine("6,1933,120000500,-;Task in /mem2 killed as a result of limit of /mem3")
+	if !isMessage || message != "Task in /mem2 killed as a result of limit of /mem3" {
+		t.Errorf("expected the kmsg message to be split from its header, got %q", message)
+	}
+	if expected := bootTime.Add(120000500 * time.Microsecond); !lineTime.Equal(expected) {
+		t.Errorf("expected the kmsg timestamp to be %v, got %v", expected, lineTime)
+	}
+	if oomLog.lastKmsgLevel != 6 {
+		t.Errorf("expected the kmsg level to be 6, got %d", oomLog.lastKmsgLevel)
+	}
+
+	if _, _, isMessage := oomLog.splitKmsgLine(" SUBSYSTEM=usb"); isMessage {
+		t.Errorf("continuation lines should not be treated as messages")
+	}
+
+	message, lineTime, isMessage = oomLog.splitKmsgLine("Task in /mem2 killed as a result of limit of /mem3")
+	if !isMessage || message != "Task in /mem2 killed as a result of limit of /mem3" || !lineTime.IsZero() {
+		t.Errorf("a line without a kmsg header should be kept as is with no timestamp, got %q at %v", message, lineTime)
+	}
+	if oomLog.lastKmsgLevel != -1 {
+		t.Errorf("expected no kmsg level for a line without a header, got %d", oomLog.lastKmsgLevel)
+	}
+}
+
+func TestStripKernSOH(t *testing.T) {
+	testCases := []struct {
+		line     string
+		expected string
+	}{
+		{"\x013Killed process 19667 (evilprogram2)", "Killed process 19667 (evilprogram2)"},
+		{"Jan 21 22:01:49 localhost kernel: \x014\x01cruby invoked oom-killer", "Jan 21 22:01:49 localhost kernel: ruby invoked oom-killer"},
+		{"\x01dmessage", "message"},
+		{"\x01xmessage\x01", "xmessage"},
+		{endLine, endLine},
+	}
+	for _, testCase := range testCases {
+		if stripped := stripKernSOH(testCase.line); stripped != testCase.expected {
+			t.Errorf("expected %q to be stripped to %q, got %q", testCase.line, testCase.expected, stripped)
+		}
+	}
+
+	// As delivered raw, and as /dev/kmsg escapes it.
+	withLevels := func(line string, level string) string {
+		i := strings.Index(line, "] ") + 2
+		return line[:i] + level + line[i:]
+	}
+	input := withLevels(startLine, "\x014") + "\n" + withLevels(containerLine, "\x016") + "\n" + withLevels(endLine, "\x013") + "\n"
+	kmsgInput := "4,1,100,-;\\x014ruby invoked oom-killer: gfp_mask=0x201da, order=0, oom_score_adj=0\n" +
+		"6,2,101,-;\\x016Task in /mem2 killed as a result of limit of /mem3\n" +
+		"3,3,102,-;\\x013Killed process 19667 (evilprogram2) total-vm:1460016kB\n"
+	for _, oomLog := range []*OomParser{NewFromReader(strings.NewReader(input)), newKmsgOomParser(strings.NewReader(kmsgInput), time.Unix(0, 0))} {
+		oomLog.CloseStreamOnExit = true
+		outStream := make(chan *OomInstance)
+		go oomLog.StreamOoms(outStream)
+		var oomInstances []*OomInstance
+		for oomInstance := range outStream {
+			oomInstances = append(oomInstances, oomInstance)
+		}
+		if len(oomInstances) != 1 || oomInstances[0].Pid != 19667 || oomInstances[0].ProcessName != "evilprogram2" || oomInstances[0].ContainerName != "/mem2" || oomInstances[0].InvokingProcess != "ruby" {
+			t.Errorf("expected the OOM of pid 19667 in /mem2 despite the printk levels, got %v", oomInstances)
+		}
+	}
+}
+
+func TestMalformedKmsg(t *testing.T) {
+	input := strings.Join([]string{
+		"6,1,100,-;ruby invoked oom-killer: gfp_mask=0x201da, order=0, oom_score_adj=0",
+		"Killed process 19667 (evilprogram2) total-vm:1460016kB",
+		"6,2,102,-;Killed process 19668 (evilprogram2) total-vm:1460016kB",
+	}, "\n") + "\n"
+	testCases := []struct {
+		policy   MalformedKmsgPolicy
+		pid      int
+		warnings int
+	}{
+		{MalformedKmsgProcess, 19667, 1},
+		{MalformedKmsgSkip, 19668, 1},
+		{MalformedKmsgSkipSilently, 19668, 0},
+	}
+	for _, testCase := range testCases {
+		logger := &captureLogger{}
+		oomLog := newKmsgOomParser(strings.NewReader(input), time.Unix(0, 0))
+		oomLog.MalformedKmsg = testCase.policy
+		oomLog.Logger = logger
+		oomLog.CloseStreamOnExit = true
+		outStream := make(chan *OomInstance)
+		go oomLog.StreamOoms(outStream)
+		var pids []int
+		for oomInstance := range outStream {
+			pids = append(pids, oomInstance.Pid)
+		}
+		if len(pids) != 1 || pids[0] != testCase.pid {
+			t.Errorf("policy %d: expected the OOM of pid %d, got %v", testCase.policy, testCase.pid, pids)
+		}
+		if len(logger.warnings) != testCase.warnings {
+			t.Errorf("policy %d: expected %d warnings, got %q", testCase.policy, testCase.warnings, logger.warnings)
+		}
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	input := strings.Join([]string{
+		"4,1,100,-;ruby invoked oom-killer: gfp_mask=0x201da, order=0, oom_score_adj=0",
+		"3,2,101,-;Killed process 19667 (evilprogram2) total-vm:1460016kB",
+		"7,3,102,-;ruby invoked oom-killer: gfp_mask=0x201da, order=0, oom_score_adj=0",
+		"3,4,103,-;Killed process 19668 (evilprogram2) total-vm:1460016kB",
+		"ruby invoked oom-killer: gfp_mask=0x201da, order=0, oom_score_adj=0",
+		"3,5,104,-;Killed process 19669 (evilprogram2) total-vm:1460016kB",
+	}, "\n") + "\n"
+	oomLog := newKmsgOomParser(strings.NewReader(input), time.Unix(0, 0))
+	oomLog.CloseStreamOnExit = true
+	outStream := make(chan *OomInstance)
+	go oomLog.StreamOoms(outStream)
+	var levels []int
+	for oomInstance := range outStream {
+		levels = append(levels, oomInstance.LogLevel)
+	}
+	if expected := []int{4, 7, -1}; !reflect.DeepEqual(levels, expected) {
+		t.Errorf("expected the levels of the start lines to be %v, got %v", expected, levels)
+	}
+
+	if oomInstance := readOneOom(containerLogFile, t); oomInstance.LogLevel != -1 {
+		t.Errorf("expected no level from a syslog file, got %d", oomInstance.LogLevel)
+	}
+}
+
+func TestKmsgSeqGap(t *testing.T) {
+	oomLog := newKmsgOomParser(strings.NewReader(""), time.Unix(0, 0))
+	for _, line := range []string{
+		"6,10,100,-;" + startLine,
+		" SUBSYSTEM=usb",
+		"3,11,200,-;" + containerLine,
+		"3,14,300,-;" + endLine,
+		"3,15,400,-;" + endLine,
+	} {
+		oomLog.splitKmsgLine(line)
+	}
+	if oomLog.lostKmsgRecs != 2 {
+		t.Errorf("expected the gap from 11 to 14 to count as 2 lost records, got %d", oomLog.lostKmsgRecs)
+	}
+}
+
+func TestEventSeq(t *testing.T) {
+	oomEvent := startLine + "\n" + containerLine + "\n" + endLine + "\n"
+	for run := 0; run < 2; run++ {
+		oomLog := NewFromReader(strings.NewReader(oomEvent + oomEvent + oomEvent))
+		outStream := make(chan *OomInstance)
+		go oomLog.StreamOoms(outStream)
+		for expected := uint64(1); expected <= 3; expected++ {
+			select {
+			case oomInstance := <-outStream:
+				if oomInstance.EventSeq != expected {
+					t.Errorf("expected EventSeq %d from a new parser, got %d", expected, oomInstance.EventSeq)
+				}
+			case <-time.After(1 * time.Second):
+				t.Fatalf("timeout happened before oomInstance %d was found in reader", expected)
+			}
+		}
+	}
+}
+
+func TestStreamOomsWithErrors(t *testing.T) {
+	badLimitLine := "Jan 21 22:01:49 localhost kernel: [62279.001234] memory: usage 980kB, limit 99999999999999999999999kB, failcnt 1"
+	input := startLine + "\n" + badLimitLine + "\n" + containerLine + "\n" + endLine + "\n"
+	oomLog := NewFromReader(strings.NewReader(input))
+	outStream := make(chan *OomInstance)
+	errs := make(chan error)
+	streamErr := make(chan error, 1)
+	go func() {
+		streamErr <- oomLog.StreamOomsWithErrors(outStream, errs)
+	}()
+
+	select {
+	case err := <-errs:
+		if !strings.Contains(err.Error(), badLimitLine) {
+			t.Errorf("expected the error to identify the line %q, got %v", badLimitLine, err)
+		}
+	case oomInstance := <-outStream:
+		t.Fatalf("expected a parse error before the instance, got %v", oomInstance)
+	case <-time.After(1 * time.Second):
+		t.Fatal("timeout happened before the parse error was reported")
+	}
+	select {
+	case oomInstance := <-outStream:
+		if oomInstance.Pid != 19667 || oomInstance.ContainerName != "/mem2" {
+			t.Errorf("parsing should carry on after an error, but got %v", oomInstance)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("timeout happened before oomInstance was found after the parse error")
+	}
+	select {
+	case err := <-streamErr:
+		if err != io.EOF {
+			t.Errorf("expected StreamOomsWithErrors to return %v, got %v", io.EOF, err)
+		}
+	case <-time.After(1 * time.Second):
+		t.Error("StreamOomsWithErrors did not return at the end of its input")
+	}
+}
+
+// failingReader returns its contents, then err.
+type failingReader struct {
+	contents io.Reader
+	err      error
+}
+
+func (self *failingReader) Read(p []byte) (int, error) {
+	n, err := self.contents.Read(p)
+	if err == io.EOF {
+		return n, self.err
+	}
+	return n, err
+}
+
+// overrunReader returns each of chunks in turn, failing every other read with
+// the EPIPE /dev/kmsg returns after its reader has been overrun.
+type overrunReader struct {
+	chunks []string
+	reads  int
+}
+
+func (self *overrunReader) Read(p []byte) (int, error) {
+	self.reads++
+	if self.reads%2 == 0 {
+		return 0, &os.PathError{Op: "read", Path: "/dev/kmsg", Err: syscall.EPIPE}
+	}
+	if len(self.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, self.chunks[0])
+	self.chunks = self.chunks[1:]
+	return n, nil
+}
+
+func TestStreamOomsKmsgOverrun(t *testing.T) {
+	reader := &overrunReader{chunks: []string{
+		"6,1,100,-;" + startLine + "\n",
+		"3,2,200,-;" + containerLine + "\n",
+		"3,3,300,-;" + endLine + "\n",
+	}}
+	oomLog := newKmsgOomParser(reader, time.Unix(0, 0))
+	oomLog.CloseStreamOnExit = true
+	outStream := make(chan *OomInstance)
+	go oomLog.StreamOoms(outStream)
+
+	count := 0
+	timeout := time.After(1 * time.Second)
+	for {
+		select {
+		case _, ok := <-outStream:
+			if !ok {
+				if count != 1 {
+					t.Errorf("expected 1 oom instance across the overruns, got %d", count)
+				}
+				if err := oomLog.Err(); err != io.EOF {
+					t.Errorf("expected streaming to stop with %v, got %v", io.EOF, err)
+				}
+				return
+			}
+			count++
+		case <-timeout:
+			t.Fatalf("timed out waiting for the stream to end")
+		}
+	}
+}
+
+func TestStreamOomsTruncated(t *testing.T) {
+	input := startLine + "\n" + containerLine + "\n"
+	oomLog := NewFromReader(strings.NewReader(input))
+	oomLog.CloseStreamOnExit = true
+	outStream := make(chan *OomInstance)
+	go oomLog.StreamOoms(outStream)
+
+	select {
+	case oomInstance, ok := <-outStream:
+		if ok {
+			t.Errorf("expected no oom instance from a truncated dump, got %+v", oomInstance)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatalf("timed out waiting for the stream to end")
+	}
+}
+
+func TestStreamOomsReconnect(t *testing.T) {
+	dump := startLine + "\n" + containerLine + "\n" + endLine + "\n"
+	readErr := &os.PathError{Op: "read", Path: "/dev/kmsg", Err: syscall.EINVAL}
+	oomLog := NewFromReader(&failingReader{strings.NewReader(dump), readErr})
+	reopens := 0
+	oomLog.reopen = func() (*OomParser, error) {
+		reopens++
+		if reopens == 1 {
+			return nil, fmt.Errorf("open /dev/kmsg: no such device")
+		}
+		return NewFromReader(strings.NewReader(dump)), nil
+	}
+	oomLog.MaxReconnectBackoff = 10 * time.Millisecond
+	oomLog.CloseStreamOnExit = true
+	outStream := make(chan *OomInstance)
+	go oomLog.StreamOoms(outStream)
+
+	count := 0
+	timeout := time.After(1 * time.Second)
+	for {
+		select {
+		case _, ok := <-outStream:
+			if !ok {
+				if count != 2 {
+					t.Errorf("expected an oom instance before and after reconnecting, got %d", count)
+				}
+				if reopens != 2 {
+					t.Errorf("expected the source to be reopened twice, got %d", reopens)
+				}
+				if err := oomLog.Err(); err != io.EOF {
+					t.Errorf("expected streaming to stop with %v, got %v", io.EOF, err)
+				}
+				return
+			}
+			count++
+		case <-timeout:
+			t.Fatalf("timed out waiting for the stream to end")
+		}
+	}
+}
+
+func TestStreamOomsFiltered(t *testing.T) {
+	dump := func(containerLine string) string {
+		return startLine + "\n" + containerLine + "\n" + endLine + "\n"
+	}
+	input := dump("Jan 21 22:01:49 localhost kernel: [62279.001] Task in /kubepods/pod1/c1 killed as a result of limit of /kubepods/pod1") +
+		dump("Jan 21 22:01:49 localhost kernel: [62279.002] Task in /kubepodsx killed as a result of limit of /kubepodsx") +
+		dump("Jan 21 22:01:49 localhost kernel: [62279.003] Task in / killed as a result of limit of /") +
+		dump("Jan 21 22:01:49 localhost kernel: [62279.004] Task in /kubepods killed as a result of limit of /kubepods")
+	testCases := []struct {
+		filter   ContainerFilter
+		expected []string
+	}{
+		{ContainerFilter{Prefixes: []string{"/kubepods"}}, []string{"/kubepods/pod1/c1", "/kubepods"}},
+		{ContainerFilter{Prefixes: []string{"/kubepods/"}, IncludeGlobal: true}, []string{"/kubepods/pod1/c1", "/", "/kubepods"}},
+		{ContainerFilter{IncludeGlobal: true}, []string{"/"}},
+	}
+	for _, testCase := range testCases {
+		oomLog := NewFromReader(strings.NewReader(input))
+		oomLog.CloseStreamOnExit = true
+		outStream := make(chan *OomInstance)
+		go oomLog.StreamOomsFiltered(outStream, testCase.filter)
+
+		var containers []string
+		var lastSeq uint64
+		for oomInstance := range outStream {
+			containers = append(containers, oomInstance.ContainerName)
+			if oomInstance.EventSeq != lastSeq+1 {
+				t.Errorf("%+v: expected EventSeq %d, got %d", testCase.filter, lastSeq+1, oomInstance.EventSeq)
+			}
+			lastSeq = oomInstance.EventSeq
+		}
+		if !reflect.DeepEqual(containers, testCase.expected) {
+			t.Errorf("%+v: expected OOMs in %v, got %v", testCase.filter, testCase.expected, containers)
+		}
+	}
+}
+
+type fakeMetrics struct {
+	parsed, parseErrors, unrecognizedLines int
+}
+
+func (self *fakeMetrics) IncParsed()           { self.parsed++ }
+func (self *fakeMetrics) IncParseError()       { self.parseErrors++ }
+func (self *fakeMetrics) IncUnrecognizedLine() { self.unrecognizedLines++ }
+
+type captureLogger struct {
+	lock                    sync.Mutex
+	infos, warnings, errors []string
+}
+
+func (self *captureLogger) Infof(format string, args ...interface{}) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.infos = append(self.infos, fmt.Sprintf(format, args...))
+}
+
+func (self *captureLogger) Warningf(format string, args ...interface{}) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.warnings = append(self.warnings, fmt.Sprintf(format, args...))
+}
+
+func (self *captureLogger) Errorf(format string, args ...interface{}) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.errors = append(self.errors, fmt.Sprintf(format, args...))
+}
+
+// returns a copy of the warnings logged so far.
+func (self *captureLogger) loggedWarnings() []string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return append([]string(nil), self.warnings...)
+}
+
+func TestLogger(t *testing.T) {
+	badLimitLine := "memory: usage 980kB, limit 99999999999999999999999kB, failcnt 1"
+	input := strings.Join([]string{
+		"usb 1-1: new high-speed USB device number 2 using xhci_hcd",
+		"4,1,100,-;ruby invoked oom-killer: gfp_mask=0x201da, order=0, oom_score_adj=0",
+		"6,2,101,-;" + badLimitLine,
+		"3,3,102,-;Killed process 19667 (evilprogram2) total-vm:1460016kB",
+	}, "\n") + "\n"
+	logger := &captureLogger{}
+	oomLog := newKmsgOomParser(strings.NewReader(input), time.Unix(0, 0))
+	oomLog.Logger = logger
+	oomLog.CloseStreamOnExit = true
+	outStream := make(chan *OomInstance)
+	go oomLog.StreamOoms(outStream)
+	for range outStream {
+	}
+	if len(logger.warnings) != 1 || !strings.Contains(logger.warnings[0], "usb 1-1") {
+		t.Errorf("expected a warning about the line without a kmsg header, got %q", logger.warnings)
+	}
+	if len(logger.errors) != 1 || !strings.Contains(logger.errors[0], badLimitLine) {
+		t.Errorf("expected an error about the bad limit, got %q", logger.errors)
+	}
+	if len(logger.infos) != 1 || !strings.Contains(logger.infos[0], io.EOF.Error()) {
+		t.Errorf("expected a message about the stream ending, got %q", logger.infos)
+	}
+}
+
+func TestNormalizeContainerName(t *testing.T) {
+	oomLog := mockOomParser(kubepodsLogFile, t)
+	defer oomLog.Close()
+	oomLog.NormalizeContainerName = func(name string) string {
+		return strings.TrimPrefix(name, "/kubepods/")
+	}
+	outStream := make(chan *OomInstance)
+	go oomLog.StreamOomsFiltered(outStream, ContainerFilter{Prefixes: []string{"/kubepods/burstable"}})
+	select {
+	case oomInstance := <-outStream:
+		if oomInstance.ContainerName != "burstable/pod6c1f9bd3-4607-11e9-8e6a-42010a800002/5b7f0cd34578" || oomInstance.VictimContainerName != "burstable/pod6c1f9bd3-4607-11e9-8e6a-42010a800002" {
+			t.Errorf("expected the kubepods prefix to be stripped after filtering, got %v", oomInstance)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatalf("timeout happened before oomInstance was found in %s", kubepodsLogFile)
+	}
+}
+
+func TestStreamOomsMetrics(t *testing.T) {
+	badLimitLine := "Jan 21 22:01:49 localhost kernel: [62279.001234] memory: usage 980kB, limit 99999999999999999999999kB, failcnt 1"
+	otherLine := "Jan 21 22:01:50 localhost kernel: [62280.000001] usb 1-1: new high-speed USB device number 2 using xhci_hcd"
+	dump := startLine + "\n" + containerLine + "\n" + endLine + "\n"
+	input := otherLine + "\n" + dump + otherLine + "\n" + startLine + "\n" + badLimitLine + "\n" + endLine + "\n"
+	metrics := &fakeMetrics{}
+	oomLog := NewFromReader(strings.NewReader(input))
+	oomLog.Metrics = metrics
+	oomLog.DropGlobalOoms = true
+	oomLog.CloseStreamOnExit = true
+	outStream := make(chan *OomInstance)
+	go oomLog.StreamOoms(outStream)
+	for range outStream {
+	}
+
+	expected := fakeMetrics{parsed: 2, parseErrors: 1, unrecognizedLines: 2}
+	if *metrics != expected {
+		t.Errorf("expected metrics %+v, got %+v", expected, *metrics)
+	}
+}
+
+// lineMetrics signals lines when an unrecognized line has been read.
+type lineMetrics struct {
+	lines chan struct{}
+}
+
+func (self lineMetrics) IncParsed()           {}
+func (self lineMetrics) IncParseError()       {}
+func (self lineMetrics) IncUnrecognizedLine() { self.lines <- struct{}{} }
+
+func TestStreamOomsStalledConsumer(t *testing.T) {
+	const dumps = 20
+	testCases := []struct {
+		stagedLines int
+		buffer      int
+		// whether the whole source is read while the consumer stalls
+		drained bool
+	}{
+		{0, 0, true},
+		{1, 0, false},
+		{3 * dumps, 0, true},
+		{0, dumps, true},
+		// Capped, not unbounded.
+		{2 * maxStagedLines, 0, true},
+	}
+	for _, testCase := range testCases {
+		reader, writer := io.Pipe()
+		oomLog := NewFromReader(reader)
+		oomLog.StagedLines = testCase.stagedLines
+		oomLog.CloseStreamOnExit = true
+		outStream := NewBufferedOomChannel(testCase.buffer)
+		if cap(outStream) != testCase.buffer {
+			t.Errorf("expected a channel buffering %d OOMs, got %d", testCase.buffer, cap(outStream))
+		}
+		go oomLog.StreamOoms(outStream)
+		written := make(chan struct{})
+		go func() {
+			for pid := 1; pid <= dumps; pid++ {
+				io.WriteString(writer, startLine+"\n"+containerLine+"\n"+strings.Replace(endLine, "19667", strconv.Itoa(pid), 1)+"\n")
+			}
+			writer.Close()
+			close(written)
+		}()
+
+		wait := 2 * time.Second
+		if !testCase.drained {
+			wait = 100 * time.Millisecond
+		}
+		select {
+		case <-written:
+			if !testCase.drained {
+				t.Errorf("%+v: expected reading the source to stop while the consumer stalls", testCase)
+			}
+		case <-time.After(wait):
+			if testCase.drained {
+				t.Errorf("%+v: expected the source to be read while the consumer stalls", testCase)
+			}
+		}
+		// Nothing is lost either way.
+		pid := 0
+		for oomInstance := range outStream {
+			pid++
+			if oomInstance.Pid != pid {
+				t.Errorf("%+v: expected pid %d, got %d", testCase, pid, oomInstance.Pid)
+			}
+		}
+		if pid != dumps || oomLog.Stats().Dropped != 0 {
+			t.Errorf("%+v: expected all %d OOMs to be sent, got %d with %d dropped", testCase, dumps, pid, oomLog.Stats().Dropped)
+		}
+	}
+}
+
+func TestStreamOomsOverflow(t *testing.T) {
+	dump := startLine + "\n" + containerLine + "\n" + endLine + "\n"
+	input := strings.Repeat(dump, 5) + "Jan 21 22:01:50 localhost kernel: [62280.000001] done\n"
+	testCases := []struct {
+		overflow OverflowPolicy
+		expected []uint64
+	}{
+		{OverflowDropOldest, []uint64{4, 5}},
+		{OverflowDropNewest, []uint64{1, 2}},
+	}
+	for _, testCase := range testCases {
+		reader, writer := io.Pipe()
+		oomLog := NewFromReader(reader)
+		oomLog.Overflow = testCase.overflow
+		oomLog.OverflowBufferSize = 2
+		oomLog.CloseStreamOnExit = true
+		metrics := lineMetrics{make(chan struct{}, 1)}
+		oomLog.Metrics = metrics
+		outStream := make(chan *OomInstance)
+		go oomLog.StreamOoms(outStream)
+
+		// Wait for all the OOMs to be parsed before reading any of them.
+		go io.WriteString(writer, input)
+		select {
+		case <-metrics.lines:
+		case <-time.After(1 * time.Second):
+			t.Fatalf("overflow policy %d: timeout happened before the input was parsed", testCase.overflow)
+		}
+		writer.Close()
+
+		var seqs []uint64
+		for oomInstance := range outStream {
+			seqs = append(seqs, oomInstance.EventSeq)
+		}
+		if !reflect.DeepEqual(seqs, testCase.expected) {
+			t.Errorf("overflow policy %d: expected OOMs %v, got %v", testCase.overflow, testCase.expected, seqs)
+		}
+	}
+}
+
+func TestStreamOomsInterleaved(t *testing.T) {
+	noise := []string{
+		"Jan 21 22:01:49 localhost dockerd[812]: level=info msg=\"Task in /fake killed as a result of limit of /fake\"",
+		"Jan 21 22:01:49 localhost myapp: [ 4242]  0 4242 1 1 0 0 0 fake",
+		"Jan 21 22:01:49 localhost CRON[14608]: (root) CMD (Killed process 4242 (fake))",
+	}
+	tableHeader := "Jan 21 22:01:49 localhost kernel: [62279.001] [ pid ]   uid  tgid total_vm      rss nr_ptes swapents oom_score_adj name"
+	tableRow := "Jan 21 22:01:49 localhost kernel: [62279.002] [19667]     0 19667   365004   353502     701        0             0 evilprogram2"
+	lines := []string{startLine, containerLine, noise[0], tableHeader, noise[1], tableRow, noise[2], endLine}
+	oomLog := NewFromReader(strings.NewReader(strings.Join(lines, "\n") + "\n"))
+	outStream := make(chan *OomInstance)
+	go oomLog.StreamOoms(outStream)
+	select {
+	case oomInstance := <-outStream:
+		if oomInstance.Pid != 19667 || oomInstance.ContainerName != "/mem2" || oomInstance.VictimRSSPages != 353502 {
+			t.Errorf("expected lines logged by other programs to be ignored, got %v", oomInstance)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("timeout happened before oomInstance was found")
+	}
+
+	kmsgInput := strings.Join([]string{
+		"4,1,100,-;ruby invoked oom-killer: gfp_mask=0x201da, order=0, oom_score_adj=0",
+		"6,2,101,-;Task in /mem2 killed as a result of limit of /mem3",
+		"14,3,102,-;Task in /fake killed as a result of limit of /fake",
+		"3,4,103,-;Killed process 19667 (evilprogram2) total-vm:1460016kB",
+	}, "\n") + "\n"
+	oomLog = newKmsgOomParser(strings.NewReader(kmsgInput), time.Unix(0, 0))
+	go oomLog.StreamOoms(outStream)
+	select {
+	case oomInstance := <-outStream:
+		if oomInstance.ContainerName != "/mem2" || oomInstance.VictimContainerName != "/mem3" {
+			t.Errorf("expected records written from userspace to be ignored, got %v", oomInstance)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("timeout happened before oomInstance was found in kmsg")
+	}
+}
+
+func TestStreamOomsMaxOomLines(t *testing.T) {
+	otherLine := "Jan 21 22:01:49 localhost kernel: [62279.001] CPU: 0 PID: 19667 Comm: evilprogram2"
+	lines := []string{startLine, containerLine}
+	for i := 0; i < 10; i++ {
+		lines = append(lines, otherLine)
+	}
+	lines = append(lines, startLine, containerLine, endLine)
+	oomLog := NewFromReader(strings.NewReader(strings.Join(lines, "\n") + "\n"))
+	oomLog.MaxOomLines = 5
+	oomLog.CloseStreamOnExit = true
+	outStream := make(chan *OomInstance)
+	go oomLog.StreamOoms(outStream)
+
+	var oomInstances []*OomInstance
+	for oomInstance := range outStream {
+		oomInstances = append(oomInstances, oomInstance)
+	}
+	if len(oomInstances) != 2 {
+		t.Fatalf("expected a partial OOM and then a complete one, got %v", oomInstances)
+	}
+	if partial := oomInstances[0]; !partial.Partial || partial.Pid != 0 || partial.ContainerName != "/mem2" {
+		t.Errorf("expected a partial OOM in /mem2, got %v", partial)
+	}
+	if complete := oomInstances[1]; complete.Partial || complete.Pid != 19667 {
+		t.Errorf("expected the next OOM to be complete, got %v", complete)
+	}
+
+	// The line that runs over the limit is still parsed, as a line outside
+	// any dump.
+	lines = []string{startLine, containerLine}
+	for i := 0; i < 4; i++ {
+		lines = append(lines, otherLine)
+	}
+	lines = append(lines, "Jan 21 22:01:49 localhost kernel: [62279.002] over the limit")
+	oomLog = NewFromReader(strings.NewReader(strings.Join(lines, "\n") + "\n"))
+	oomLog.MaxOomLines = 5
+	metrics := &fakeMetrics{}
+	oomLog.Metrics = metrics
+	if pids := streamPids(t, oomLog); len(pids) != 1 || pids[0] != 0 {
+		t.Errorf("expected a partial OOM, got the OOMs of pids %v", pids)
+	}
+	if metrics.unrecognizedLines != 1 {
+		t.Errorf("expected the line over the limit to be parsed as unrecognized, got %d unrecognized lines", metrics.unrecognizedLines)
+	}
+}
+
+func TestOomStartedInsideDump(t *testing.T) {
+	lines := []string{
+		"Jan 21 22:01:49 localhost kernel: [62278.816267] ruby invoked oom-killer: gfp_mask=0x201da, order=0, oom_score_adj=0",
+		"Jan 21 22:01:49 localhost kernel: [62278.816268] Task in /a killed as a result of limit of /a",
+		// The rest of ruby's dump was lost.
+		"Jan 21 22:01:50 localhost kernel: [62279.816267] python invoked oom-killer: gfp_mask=0x201da, order=3, oom_score_adj=0",
+		"Jan 21 22:01:50 localhost kernel: [62279.816268] Task in /b killed as a result of limit of /b",
+		"Jan 21 22:01:50 localhost kernel: [62279.816269] Killed process 222 (python)",
+	}
+	oomInstances, err := ParseAll(strings.NewReader(strings.Join(lines, "\n") + "\n"))
+	if err != nil || len(oomInstances) != 2 {
+		t.Fatalf("expected 2 OOMs, got %v and %v", oomInstances, err)
+	}
+	if first := oomInstances[0]; !first.Partial || first.Pid != 0 || first.InvokingProcess != "ruby" || first.ContainerName != "/a" {
+		t.Errorf("expected a partial OOM invoked by ruby in /a, got %+v", first)
+	}
+	if second := oomInstances[1]; second.Partial || second.Pid != 222 || second.InvokingProcess != "python" || second.AllocationOrder != 3 || second.ContainerName != "/b" {
+		t.Errorf("expected python's OOM in /b to be complete, got %+v", second)
+	}
+}
+
+func TestStreamOomsExit(t *testing.T) {
+	readErr := fmt.Errorf("read /dev/kmsg: broken pipe")
+	input := startLine + "\n" + containerLine + "\n" + endLine + "\n"
+	testCases := []struct {
+		reader   io.Reader
+		expected error
+	}{
+		{strings.NewReader(input), io.EOF},
+		{&failingReader{strings.NewReader(input), readErr}, readErr},
+	}
+	for _, testCase := range testCases {
+		oomLog := NewFromReader(testCase.reader)
+		oomLog.CloseStreamOnExit = true
+		outStream := make(chan *OomInstance)
+		go oomLog.StreamOoms(outStream)
+
+		count := 0
+		timeout := time.After(1 * time.Second)
+	loop:
+		for {
+			select {
+			case _, ok := <-outStream:
+				if !ok {
+					break loop
+				}
+				count++
+			case <-timeout:
+				t.Fatalf("timeout happened before the stream ended")
+			}
+		}
+		if count != 1 {
+			t.Errorf("expected 1 instance before the stream ended, got %d", count)
+		}
+		if err := oomLog.Err(); err != testCase.expected {
+			t.Errorf("expected the stream to end with %v, got %v", testCase.expected, err)
+		}
+	}
+}
+
+func TestStreamOomsExitOnCancel(t *testing.T) {
+	reader, _ := io.Pipe()
+	oomLog := NewFromReader(reader)
+	oomLog.CloseStreamOnExit = true
+	outStream := make(chan *OomInstance)
+	ctx, cancel := context.WithCancel(context.Background())
+	go oomLog.StreamOomsContext(ctx, outStream)
+	cancel()
+	select {
+	case _, ok := <-outStream:
+		if ok {
+			t.Errorf("expected the stream to be closed without sending anything")
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatalf("timeout happened before the stream ended")
+	}
+	if err := oomLog.Err(); err != context.Canceled {
+		t.Errorf("expected the stream to end with %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestCheckIfStartOfMessages(t *testing.T) {
+	couldParseLine := DefaultMatchers.checkIfStartOfOomMessages(endLine)
+	if couldParseLine {
+		t.Errorf("bad line fed to checkIfStartOfMessages should return false but returned %v", couldParseLine)
+	}
+	couldParseLine = DefaultMatchers.checkIfStartOfOomMessages(startLine)
+	if !couldParseLine {
+		t.Errorf("start line fed to checkIfStartOfMessages should return true but returned %v", couldParseLine)
+	}
+}
+
+func TestStreamOomsContainer(t *testing.T) {
+	expectedContainerOomInstance := createExpectedContainerOomInstance(t)
+	helpTestStreamOoms(expectedContainerOomInstance, containerLogFile, t)
+}
+
+func TestStreamOomsSystem(t *testing.T) {
+	expectedSystemOomInstance := createExpectedSystemOomInstance(t)
+	helpTestStreamOoms(expectedSystemOomInstance, systemLogFile, t)
+}
+
+func helpTestStreamOoms(oomCheckInstance *OomInstance, sysFile string, t *testing.T) {
+	outStream := make(chan *OomInstance)
+	oomLog := mockOomParser(sysFile, t)
+	timeout := make(chan bool, 1)
+	go func() {
+		time.Sleep(1 * time.Second)
+		timeout <- true
+	}()
+
+	go oomLog.StreamOoms(outStream)
+
+	select {
+	case oomInstance := <-outStream:
+		if reflect.DeepEqual(*oomCheckInstance, *oomInstance) {
+			t.Errorf("wrong instance returned. Expected %v and got %v",
+				oomCheckInstance, oomInstance)
+		}
+	case <-timeout:
+		t.Error(
+			"timeout happened before oomInstance was found in test file")
+	}
+}
+
+func TestStreamOomsContextCancel(t *testing.T) {
+	reader, writer := io.Pipe()
+	oomLog := NewFromReader(reader)
+	outStream := make(chan *OomInstance, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	finished := make(chan error, 1)
+	go func() {
+		finished <- oomLog.StreamOomsContext(ctx, outStream)
+	}()
+
+	// Start an OOM message group but never finish it, then cancel.
+	if _, err := io.WriteString(writer, startLine+"\n"+containerLine+"\n"); err != nil {
+		t.Fatalf("failed to write to pipe: %v", err)
+	}
+	cancel()
+
+	select {
+	case err := <-finished:
+		if err != context.Canceled {
+			t.Errorf("expected StreamOomsContext to return %v, got %v", context.Canceled, err)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("StreamOomsContext did not return after the context was cancelled")
+	}
+	select {
+	case oomInstance := <-outStream:
+		t.Errorf("no instance should be sent after cancellation, but got %v", oomInstance)
+	default:
+	}
+	if _, err := io.WriteString(writer, endLine+"\n"); err != io.ErrClosedPipe {
+		t.Errorf("the reader should have been closed on cancellation, but writing gave %v", err)
+	}
+}
+
+func TestStreamOomsCancelMidStream(t *testing.T) {
+	source := oomtesting.NewSource(append(oomtesting.Lines(startLine, containerLine, endLine),
+		oomtesting.Step{Delay: 10 * time.Millisecond, Line: startLine},
+		oomtesting.Step{Delay: 10 * time.Millisecond, Line: containerLine},
+		oomtesting.Step{Delay: time.Hour, Line: endLine})...)
+	oomLog := NewFromReader(source)
+	outStream := make(chan *OomInstance, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	finished := make(chan error, 1)
+	go func() {
+		finished <- oomLog.StreamOomsContext(ctx, outStream)
+	}()
+	select {
+	case oomInstance := <-outStream:
+		if oomInstance.Pid != 19667 {
+			t.Errorf("expected the first OOM to be of pid 19667, got %d", oomInstance.Pid)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("timeout happened before the first OOM was sent")
+	}
+
+	// Cancel while the second dump is waiting for its last line.
+	for deadline := time.Now().Add(1 * time.Second); source.Remaining() > 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout happened before the last line was waited for, with %d steps left", source.Remaining())
+		}
+	}
+	cancel()
+	select {
+	case err := <-finished:
+		if err != context.Canceled {
+			t.Errorf("expected StreamOomsContext to return %v, got %v", context.Canceled, err)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("StreamOomsContext did not return after the context was cancelled")
+	}
+	if len(outStream) != 0 {
+		t.Errorf("expected the unfinished OOM not to be sent, got %v", <-outStream)
+	}
+	if _, err := source.Read(make([]byte, 1)); err != os.ErrClosed {
+		t.Errorf("expected the source to have been closed on cancellation, but reading it gave %v", err)
+	}
+}
+
+func TestStreamOomsReconnectSource(t *testing.T) {
+	dump := oomtesting.Lines(startLine, containerLine, endLine)
+	readErr := errors.New("input/output error")
+	oomLog := NewFromReader(oomtesting.NewSource(append(dump, oomtesting.Step{Delay: 10 * time.Millisecond, Err: readErr})...))
+	oomLog.MaxReconnectBackoff = time.Millisecond
+	reopened := 0
+	oomLog.reopen = func() (*OomParser, error) {
+		reopened++
+		return NewFromReader(oomtesting.NewSource(dump...)), nil
+	}
+	outStream := make(chan *OomInstance, 10)
+	if err := oomLog.StreamOomsContext(context.Background(), outStream); err != io.EOF {
+		t.Errorf("expected the reopened source to end with %v, got %v", io.EOF, err)
+	}
+	if len(outStream) != 2 || reopened != 1 {
+		t.Errorf("expected an OOM before and after reopening once, got %d after reopening %d times", len(outStream), reopened)
+	}
+}
+
+func TestCloseReleasesFile(t *testing.T) {
+	oomLog := mockOomParser(containerLogFile, t)
+	file := oomLog.closer.(*os.File)
+	fdPath := fmt.Sprintf("/proc/self/fd/%d", file.Fd())
+	target, err := os.Readlink(fdPath)
+	if err != nil {
+		t.Fatalf("could not resolve the parser's fd: %v", err)
+	}
+
+	outStream := make(chan *OomInstance, 1)
+	finished := make(chan struct{})
+	go func() {
+		oomLog.StreamOoms(outStream)
+		close(finished)
+	}()
+	if err := oomLog.Close(); err != nil {
+		t.Errorf("Close returned error %v", err)
+	}
+	select {
+	case <-finished:
+	case <-time.After(1 * time.Second):
+		t.Fatal("StreamOoms did not return after Close")
+	}
+	if newTarget, err := os.Readlink(fdPath); err == nil && newTarget == target {
+		t.Errorf("fd %s still refers to %s after Close", fdPath, target)
+	}
+	if err := oomLog.Close(); err != nil {
+		t.Errorf("second Close returned error %v", err)
+	}
+}
+
+func TestReset(t *testing.T) {
+	input := startLine + "\n" + containerLine + "\n" + endLine + "\n"
+	oomLog := NewFromReader(strings.NewReader(input))
+	for run := 0; run < 2; run++ {
+		outStream := make(chan *OomInstance, 1)
+		if err := oomLog.StreamOomsContext(context.Background(), outStream); err != io.EOF {
+			t.Fatalf("run %d: expected the stream to end with %v, got %v", run, io.EOF, err)
+		}
+		select {
+		case oomInstance := <-outStream:
+			if oomInstance.Pid != 19667 || oomInstance.EventSeq != 1 {
+				t.Errorf("run %d: expected pid 19667 with EventSeq 1, got %v with %d", run, oomInstance, oomInstance.EventSeq)
+			}
+		default:
+			t.Errorf("run %d: expected an oomInstance", run)
+		}
+		if err := oomLog.Reset(); err != nil {
+			t.Fatalf("run %d: failed to reset: %v", run, err)
+		}
+	}
+
+	// Next streams again from the start after a reset.
+	for run := 0; run < 2; run++ {
+		oomInstance, err := oomLog.Next(context.Background())
+		if err != nil || oomInstance.Pid != 19667 || oomInstance.EventSeq != 1 {
+			t.Errorf("run %d: expected pid 19667 with EventSeq 1 from Next, got %v and %v", run, oomInstance, err)
+		}
+		if oomInstance, err := oomLog.Next(context.Background()); err != io.EOF {
+			t.Errorf("run %d: expected %v at the end of the source, got %v and %v", run, io.EOF, oomInstance, err)
+		}
+		if err := oomLog.Reset(); err != nil {
+			t.Fatalf("run %d: failed to reset: %v", run, err)
+		}
+	}
+
+	if err := NewFromReader(&failingReader{strings.NewReader(input), io.EOF}).Reset(); err == nil {
+		t.Errorf("expected an error resetting a source that cannot seek")
+	}
+}
+
+func TestNext(t *testing.T) {
+	oomEvent := startLine + "\n" + containerLine + "\n" + endLine + "\n"
+	reader, writer := io.Pipe()
+	oomLog := NewFromReader(reader)
+	go io.WriteString(writer, oomEvent)
+
+	oomInstance, err := oomLog.Next(context.Background())
+	if err != nil || oomInstance.Pid != 19667 {
+		t.Fatalf("expected the OOM of pid 19667, got %v and %v", oomInstance, err)
+	}
+
+	// Nothing more has been written yet.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if oomInstance, err := oomLog.Next(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected %v waiting for an OOM that has not happened, got %v and %v", context.DeadlineExceeded, oomInstance, err)
+	}
+	if err := oomLog.StreamOomsContext(context.Background(), make(chan *OomInstance)); err == nil {
+		t.Errorf("expected an error streaming from a parser that Next is streaming from")
+	}
+
+	go func() {
+		io.WriteString(writer, oomEvent)
+		writer.Close()
+	}()
+	oomInstance, err = oomLog.Next(context.Background())
+	if err != nil || oomInstance.EventSeq != 2 {
+		t.Errorf("expected the second OOM, got %v and %v", oomInstance, err)
+	}
+	if oomInstance, err := oomLog.Next(context.Background()); err != io.EOF {
+		t.Errorf("expected %v at the end of the source, got %v and %v", io.EOF, oomInstance, err)
+	}
+}
+
+func TestCloseWithoutCloser(t *testing.T) {
+	oomLog := NewFromReader(strings.NewReader(startLine + "\n"))
+	if err := oomLog.Close(); err != nil {
+		t.Errorf("Close of a parser with no closable source should return nil, not %v", err)
+	}
+}
+
+func TestNewFromReader(t *testing.T) {
+	input := startLine + "\n" + containerLine + "\n" + endLine + "\n"
+	oomLog := NewFromReader(strings.NewReader(input))
+	outStream := make(chan *OomInstance)
+	go oomLog.StreamOoms(outStream)
+
+	select {
+	case oomInstance := <-outStream:
+		if oomInstance.Pid != 19667 || oomInstance.ProcessName != "evilprogram2" {
+			t.Errorf("expected pid 19667 and process evilprogram2, got %v", oomInstance)
+		}
+		if oomInstance.ContainerName != "/mem2" || oomInstance.VictimContainerName != "/mem3" {
+			t.Errorf("expected container /mem2 and victim /mem3, got %v", oomInstance)
+		}
+	case <-time.After(1 * time.Second):
+		t.Error("timeout happened before oomInstance was found in reader")
+	}
+}
+
+func TestVictimRSS(t *testing.T) {
+	oomInstance := readOneOom(kubepodsLogFile, t)
+	if oomInstance.Pid != 30211 {
+		t.Fatalf("expected the victim to be pid 30211, not %d", oomInstance.Pid)
+	}
+	if oomInstance.VictimRSSPages != 65211 {
+		t.Errorf("expected the victim's rss to be 65211 pages, not %d", oomInstance.VictimRSSPages)
+	}
+
+	oomInstance = readOneOom(containerLogFile, t)
+	if oomInstance.VictimRSSPages != 343 {
+		t.Errorf("expected the victim's rss to be 343 pages, not %d", oomInstance.VictimRSSPages)
+	}
+}
+
+func TestVictimUID(t *testing.T) {
+	if oomInstance := readOneOom(kubepodsLogFile, t); oomInstance.VictimUID != 1000 {
+		t.Errorf("expected the victim's uid from the task table to be 1000, not %d", oomInstance.VictimUID)
+	}
+
+	tableHeader := "Sep  2 14:31:05 worker-1 kernel: [ 9012.345083] [  pid  ]   uid  tgid total_vm      rss pgtables_bytes swapents oom_score_adj name"
+	tableRow := "Sep  2 14:31:05 worker-1 kernel: [ 9012.345084] [  19667]  1000 19667    33597    32174   307200        0           984 evilprogram2"
+	summary := "Sep  2 14:31:05 worker-1 kernel: [ 9012.345086] oom-kill:constraint=CONSTRAINT_MEMCG,nodemask=(null),cpuset=/,mems_allowed=0,oom_memcg=/mem3,task_memcg=/mem2,task=evilprogram2,pid=19667,uid=2000"
+	testCases := []struct {
+		lines    []string
+		expected int
+	}{
+		{[]string{startLine, tableHeader, tableRow, summary, endLine}, 2000},
+		{[]string{startLine, tableHeader, tableRow, endLine}, 1000},
+		{[]string{startLine, containerLine, endLine}, -1},
+	}
+	for _, testCase := range testCases {
+		oomLog := NewFromReader(strings.NewReader(strings.Join(testCase.lines, "\n") + "\n"))
+		outStream := make(chan *OomInstance)
+		go oomLog.StreamOoms(outStream)
+		select {
+		case oomInstance := <-outStream:
+			if oomInstance.VictimUID != testCase.expected {
+				t.Errorf("expected the victim's uid to be %d, not %d, from %q", testCase.expected, oomInstance.VictimUID, testCase.lines)
+			}
+		case <-time.After(1 * time.Second):
+			t.Error("timeout happened before oomInstance was found in reader")
+		}
+	}
+}
+
+func TestVictimOomScore(t *testing.T) {
+	testCases := []struct {
+		logFile  string
+		expected int
+		has      bool
+	}{
+		{containerLogFile, 996, true},
+		{kubepodsLogFile, 1935, true},
+		{pagesLogFile, 991, true},
+		{systemLogFile, 919, true},
+		{cgroupv2LogFile, 0, false},
+		{kmsgLogFile, 0, false},
+	}
+	for _, testCase := range testCases {
+		oomInstance := readOneOom(testCase.logFile, t)
+		if oomInstance.VictimOomScore != testCase.expected || oomInstance.HasVictimOomScore != testCase.has {
+			t.Errorf("expected the victim's score in %s to be %d with HasVictimOomScore %v, got %d and %v", testCase.logFile, testCase.expected, testCase.has, oomInstance.VictimOomScore, oomInstance.HasVictimOomScore)
+		}
+	}
+
+	tableHeader := "Sep  2 14:31:05 worker-1 kernel: [ 9012.345083] [  pid  ]   uid  tgid total_vm      rss pgtables_bytes swapents oom_score oom_score_adj name"
+	tableRow := "Sep  2 14:31:05 worker-1 kernel: [ 9012.345084] [  19667]  1000 19667    33597    32174   307200        0      1180           984 evilprogram2"
+	parentRow := "Sep  2 14:31:05 worker-1 kernel: [ 9012.345085] [  19660]  1000 19660     4510      120    53248        0       192           0 sh"
+	killParent := "Sep  2 14:31:05 worker-1 kernel: [ 9012.345086] Memory cgroup out of memory: Kill process 19660 (sh) score 192 or sacrifice child"
+	killVictim := "Sep  2 14:31:05 worker-1 kernel: [ 9012.345086] Memory cgroup out of memory: Kill process 19667 (evilprogram2) score 1001 or sacrifice child"
+	tableCases := []struct {
+		lines    []string
+		expected int
+		has      bool
+	}{
+		// The task table's column is preferred.
+		{[]string{startLine, tableHeader, tableRow, killVictim, endLine}, 1180, true},
+		{[]string{startLine, tableHeader, tableRow, parentRow, endLine}, 1180, true},
+		{[]string{startLine, containerLine, killVictim, endLine}, 1001, true},
+		// A child killed in its parent's place was not scored.
+		{[]string{startLine, containerLine, killParent, endLine}, 0, false},
+		{[]string{startLine, containerLine, endLine}, 0, false},
+	}
+	for _, testCase := range tableCases {
+		oomLog := NewFromReader(strings.NewReader(strings.Join(testCase.lines, "\n") + "\n"))
+		outStream := make(chan *OomInstance)
+		go oomLog.StreamOoms(outStream)
+		select {
+		case oomInstance := <-outStream:
+			if oomInstance.VictimOomScore != testCase.expected || oomInstance.HasVictimOomScore != testCase.has {
+				t.Errorf("expected the victim's score to be %d with HasVictimOomScore %v, got %d and %v, from %q", testCase.expected, testCase.has, oomInstance.VictimOomScore, oomInstance.HasVictimOomScore, testCase.lines)
+			}
+		case <-time.After(1 * time.Second):
+			t.Error("timeout happened before oomInstance was found in reader")
+		}
+	}
+}
+
+func TestContextLines(t *testing.T) {
+	dump := []string{startLine, containerLine, endLine}
+	secondDump := []string{startLine, containerLine, strings.Replace(endLine, "19667", "19668", 1)}
+	lines := append([]string{"one", "two", "three"}, dump...)
+	lines = append(lines, "four", "five")
+	lines = append(lines, secondDump...)
+	lines = append(lines, "six")
+	streamContext := func(before int, after int, lines []string) []*OomInstance {
+		oomLog := NewFromReader(strings.NewReader(strings.Join(lines, "\n") + "\n"))
+		oomLog.ContextBefore = before
+		oomLog.ContextAfter = after
+		oomLog.CloseStreamOnExit = true
+		outStream := make(chan *OomInstance)
+		go oomLog.StreamOoms(outStream)
+		var oomInstances []*OomInstance
+		for oomInstance := range outStream {
+			oomInstances = append(oomInstances, oomInstance)
+		}
+		return oomInstances
+	}
+
+	if oomInstances := streamContext(0, 0, lines); len(oomInstances) != 2 || oomInstances[0].ContextBefore != nil || oomInstances[0].ContextAfter != nil {
+		t.Errorf("expected no context lines unless asked for, got %v", oomInstances)
+	}
+	oomInstances := streamContext(2, 3, lines)
+	if len(oomInstances) != 2 {
+		t.Fatalf("expected 2 OOMs, got %v", oomInstances)
+	}
+	// The context of one OOM may run into the next.
+	expected := [][]string{
+		{"two", "three"}, {"four", "five", startLine},
+		{"four", "five"}, {"six"},
+	}
+	for i, oomInstance := range oomInstances {
+		if !reflect.DeepEqual(oomInstance.ContextBefore, expected[2*i]) || !reflect.DeepEqual(oomInstance.ContextAfter, expected[2*i+1]) {
+			t.Errorf("expected the OOM of pid %d to have the context %q before and %q after, got %q and %q", oomInstance.Pid, expected[2*i], expected[2*i+1], oomInstance.ContextBefore, oomInstance.ContextAfter)
+		}
+	}
+	if oomInstances[0].Pid != 19667 || oomInstances[1].Pid != 19668 {
+		t.Errorf("expected the OOMs to be sent in order, got %v", oomInstances)
+	}
+
+	// The context is capped.
+	var manyLines []string
+	for i := 0; i < 2*maxContextLines; i++ {
+		manyLines = append(manyLines, fmt.Sprintf("line %d", i))
+	}
+	oomInstances = streamContext(3*maxContextLines, 3*maxContextLines, append(append(manyLines, dump...), manyLines...))
+	if len(oomInstances) != 1 || len(oomInstances[0].ContextBefore) != maxContextLines || len(oomInstances[0].ContextAfter) != maxContextLines {
+		t.Fatalf("expected an OOM with %d lines of context either side, got %v", maxContextLines, oomInstances)
+	}
+	if first, last := oomInstances[0].ContextBefore[0], oomInstances[0].ContextAfter[maxContextLines-1]; first != manyLines[maxContextLines] || last != manyLines[maxContextLines-1] {
+		t.Errorf("expected the context to run from %q to %q, got %q to %q", manyLines[maxContextLines], manyLines[maxContextLines-1], first, last)
+	}
+}
+
+func TestTopConsumers(t *testing.T) {
+	if oomInstance := readOneOom(kubepodsLogFile, t); oomInstance.TopConsumers != nil {
+		t.Errorf("expected no top consumers unless asked for, got %v", oomInstance.TopConsumers)
+	}
+
+	oomLog := mockOomParser(kubepodsLogFile, t)
+	defer oomLog.Close()
+	oomLog.TopConsumers = 3
+	outStream := make(chan *OomInstance)
+	go oomLog.StreamOoms(outStream)
+	expected := []ProcessMemInfo{
+		{Pid: 30211, Name: "stress", RSSPages: 65211, OomScoreAdj: 939},
+		{Pid: 30205, Name: "sh", RSSPages: 309, OomScoreAdj: 939},
+		{Pid: 30210, Name: "stress", RSSPages: 48, OomScoreAdj: 939},
+	}
+	select {
+	case oomInstance := <-outStream:
+		if !reflect.DeepEqual(oomInstance.TopConsumers, expected) {
+			t.Errorf("expected the top consumers to be %v, got %v", expected, oomInstance.TopConsumers)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("timeout happened before oomInstance was found in reader")
+	}
+
+	// The processes killed with the group share the dump's list.
+	oomLog = mockOomParser(groupKillLogFile, t)
+	defer oomLog.Close()
+	oomLog.TopConsumers = 1
+	oomLog.CloseStreamOnExit = true
+	outStream = make(chan *OomInstance)
+	go oomLog.StreamOoms(outStream)
+	oomInstances := 0
+	for oomInstance := range outStream {
+		oomInstances++
+		if len(oomInstance.TopConsumers) != 1 || oomInstance.TopConsumers[0].Pid != 7011 {
+			t.Errorf("expected pid %d to share the top consumer 7011, got %v", oomInstance.Pid, oomInstance.TopConsumers)
+		}
+	}
+	if oomInstances != 4 {
+		t.Errorf("expected the 4 OOMs of the group kill, got %d", oomInstances)
+	}
+
+	// Ties are broken by pid.
+	tableHeader := "Sep  2 14:31:05 worker-1 kernel: [ 9012.345083] [  pid  ]   uid  tgid total_vm      rss pgtables_bytes swapents oom_score_adj name"
+	lines := []string{startLine, tableHeader}
+	for pid := 100; pid < 100+2*maxTopConsumers; pid++ {
+		lines = append(lines, fmt.Sprintf("Sep  2 14:31:05 worker-1 kernel: [ 9012.345084] [  %d]  1000 %d    33597    %d   307200        0          -%d my worker", pid, pid, 1000+pid/2, pid))
+	}
+	lines = append(lines, endLine)
+	oomLog = NewFromReader(strings.NewReader(strings.Join(lines, "\n") + "\n"))
+	oomLog.TopConsumers = 2 * maxTopConsumers
+	outStream = make(chan *OomInstance)
+	go oomLog.StreamOoms(outStream)
+	var topConsumers []ProcessMemInfo
+	select {
+	case oomInstance := <-outStream:
+		topConsumers = oomInstance.TopConsumers
+	case <-time.After(1 * time.Second):
+		t.Fatal("timeout happened before oomInstance was found in reader")
+	}
+	if len(topConsumers) != maxTopConsumers {
+		t.Fatalf("expected the top consumers to be capped at %d, got %d", maxTopConsumers, len(topConsumers))
+	}
+	last := 100 + 2*maxTopConsumers - 1
+	expected = []ProcessMemInfo{
+		{Pid: last - 1, Name: "my worker", RSSPages: uint64(1000 + last/2), OomScoreAdj: 1 - last},
+		{Pid: last, Name: "my worker", RSSPages: uint64(1000 + last/2), OomScoreAdj: -last},
+	}
+	if !reflect.DeepEqual(topConsumers[:2], expected) {
+		t.Errorf("expected the top consumers to start with %v, got %v", expected, topConsumers[:2])
+	}
+}
+
+func TestMalformedTaskTable(t *testing.T) {
+	testCases := [][]string{
+		// A header without columns.
+		{"[  pid  ]  ", "[19667]  1000 19667 evilprogram2"},
+		// Numbers too large for their fields.
+		{"[  pid  ]   uid  tgid total_vm      rss nr_ptes swapents oom_score_adj name", "[99999999999999999999]  1000 19667 1 99999999999999999999 1 0 -99999999999999999999 evilprogram2"},
+	}
+	for _, testCase := range testCases {
+		var table taskTable
+		for _, line := range testCase {
+			table.addLine(line)
+		}
+		oomInstance := &OomInstance{Pid: 19667, VictimUID: -1}
+		table.fillVictim(oomInstance)
+		if oomInstance.VictimRSSPages != 0 || oomInstance.VictimUID != -1 {
+			t.Errorf("expected nothing to be read from %q, got %+v", testCase, oomInstance)
+		}
+		if topConsumers := table.topConsumers(3); topConsumers != nil {
+			t.Errorf("expected no top consumers in %q, got %v", testCase, topConsumers)
+		}
+	}
+
+	currentOomInstance := new(OomInstance)
+	if _, err := DefaultMatchers.getProcessNamePid(strings.Replace(endLine, "19667", "99999999999999999999", 1), time.Now(), currentOomInstance); err == nil {
+		t.Errorf("expected an error for a pid too large for an int, got pid %d", currentOomInstance.Pid)
+	}
+}
+
+func TestZones(t *testing.T) {
+	if oomInstance := readOneOom(systemLogFile, t); oomInstance.Zones != nil {
+		t.Errorf("expected no zones unless asked for, got %v", oomInstance.Zones)
+	}
+
+	oomLog := mockOomParser(systemLogFile, t)
+	defer oomLog.Close()
+	oomLog.ParseZones = true
+	outStream := make(chan *OomInstance)
+	go oomLog.StreamOoms(outStream)
+	expected := []ZoneMemInfo{
+		{Node: 0, Zone: "DMA", FreeKB: 7124, MinKB: 412, LowKB: 512, HighKB: 616},
+		{Node: 0, Zone: "DMA32", FreeKB: 44592, MinKB: 44640, LowKB: 55800, HighKB: 66960},
+	}
+	select {
+	case oomInstance := <-outStream:
+		if !reflect.DeepEqual(oomInstance.Zones, expected) {
+			t.Errorf("expected the zones in %s to be %v, got %v", systemLogFile, expected, oomInstance.Zones)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("timeout happened before oomInstance was found in reader")
+	}
+
+	// Newer kernels, on a host with two nodes, one of which only has
+	// movable memory.
+	prefix := "Mar  4 09:12:01 numa-1 kernel: [ 3601.000050] "
+	lines := []string{
+		"Mar  4 09:12:01 numa-1 kernel: [ 3601.000010] stress invoked oom-killer: gfp_mask=0x100cca(GFP_HIGHUSER_MOVABLE), order=0, oom_score_adj=0",
+		prefix + "Mem-Info:",
+		prefix + "Node 0 active_anon:3905220kB inactive_anon:12kB active_file:144kB inactive_file:80kB unevictable:0kB isolated(anon):0kB isolated(file):0kB mapped:48kB dirty:0kB writeback:0kB shmem:0kB writeback_tmp:0kB kernel_stack:2432kB all_unreclaimable? yes",
+		prefix + "Node 0 DMA free:15360kB boost:0kB min:128kB low:160kB high:192kB reserved_highatomic:0KB active_anon:0kB inactive_anon:0kB present:15992kB managed:15360kB mlocked:0kB bounce:0kB free_pcp:0kB local_pcp:0kB free_cma:0kB",
+		prefix + "lowmem_reserve[]: 0 2960 3896 3896 3896",
+		prefix + "Node 0 DMA32 free:12048kB boost:4096kB min:12168kB low:15208kB high:18248kB reserved_highatomic:0KB active_anon:2950000kB inactive_anon:0kB present:3129212kB managed:3031460kB mlocked:0kB bounce:0kB free_pcp:0kB local_pcp:0kB free_cma:0kB",
+		prefix + "Node 0 Normal free:3860kB boost:0kB min:3880kB low:4848kB high:5816kB reserved_highatomic:0KB active_anon:955220kB inactive_anon:12kB present:1048576kB managed:958856kB mlocked:0kB bounce:0kB free_pcp:124kB local_pcp:0kB free_cma:0kB",
+		prefix + "Node 1 Movable free:2011264kB boost:0kB min:8140kB low:10172kB high:12204kB reserved_highatomic:0KB active_anon:0kB inactive_anon:0kB present:2097152kB managed:2031616kB mlocked:0kB bounce:0kB free_pcp:0kB local_pcp:0kB free_cma:0kB",
+		prefix + "Node 0 DMA: 0*4kB 0*8kB 0*16kB 0*32kB 0*64kB 0*128kB 0*256kB 0*512kB 1*1024kB (U) 1*2048kB (M) 3*4096kB (M) = 15360kB",
+		prefix + "Node 0 hugepages_total=0 hugepages_free=0 hugepages_surp=0 hugepages_size=2048kB",
+		"Mar  4 09:12:01 numa-1 kernel: [ 3601.000090] Out of memory: Killed process 5120 (stress) total-vm:3909652kB, anon-rss:3905220kB, file-rss:0kB, shmem-rss:0kB, UID:1000 pgtables:7688kB oom_score_adj:0",
+	}
+	oomLog = NewFromReader(strings.NewReader(strings.Join(lines, "\n") + "\n"))
+	oomLog.ParseZones = true
+	outStream = make(chan *OomInstance)
+	go oomLog.StreamOoms(outStream)
+	expected = []ZoneMemInfo{
+		{Node: 0, Zone: "DMA", FreeKB: 15360, MinKB: 128, LowKB: 160, HighKB: 192},
+		{Node: 0, Zone: "DMA32", FreeKB: 12048, MinKB: 12168, LowKB: 15208, HighKB: 18248},
+		{Node: 0, Zone: "Normal", FreeKB: 3860, MinKB: 3880, LowKB: 4848, HighKB: 5816},
+		{Node: 1, Zone: "Movable", FreeKB: 2011264, MinKB: 8140, LowKB: 10172, HighKB: 12204},
+	}
+	select {
+	case oomInstance := <-outStream:
+		if !reflect.DeepEqual(oomInstance.Zones, expected) {
+			t.Errorf("expected the zones %v, got %v", expected, oomInstance.Zones)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("timeout happened before oomInstance was found in reader")
+	}
+}
+
+func TestVictimMemoryLayout(t *testing.T) {
+	testCases := []struct {
+		logFile       string
+		totalVMPages  uint64
+		pgTablesBytes uint64
+	}{
+		// Linux 3.13, whose table has nr_ptes rather than pgtables_bytes.
+		{containerLogFile, 8389663, 0},
+		// Linux 4.15
+		{kubepodsLogFile, 67585, 577536},
+	}
+	for _, testCase := range testCases {
+		oomInstance := readOneOom(testCase.logFile, t)
+		if oomInstance.VictimTotalVMPages != testCase.totalVMPages {
+			t.Errorf("%s: expected the victim's total_vm to be %d pages, not %d", testCase.logFile, testCase.totalVMPages, oomInstance.VictimTotalVMPages)
+		}
+		if oomInstance.VictimPgTablesBytes != testCase.pgTablesBytes {
+			t.Errorf("%s: expected the victim's page tables to be %d bytes, not %d", testCase.logFile, testCase.pgTablesBytes, oomInstance.VictimPgTablesBytes)
+		}
+	}
+}
+
+func TestStreamOomsLongLine(t *testing.T) {
+	// Lines are read with ReadString, so unlike with a bufio.Scanner there is
+	// no limit on their length.
+	longName := "/" + strings.Repeat("a", 100*1024)
+	longLine := "Jan 26 14:10:07 localhost kernel: [1814368.465205] Task in " + longName + " killed as a result of limit of /mem3"
+	oomLog := NewFromReader(strings.NewReader(startLine + "\n" + longLine + "\n" + endLine + "\n"))
+	outStream := make(chan *OomInstance)
+	go oomLog.StreamOoms(outStream)
+	select {
+	case oomInstance := <-outStream:
+		if oomInstance.ContainerName != longName {
+			t.Errorf("expected the %d byte container name to be read in full, got %d bytes", len(longName), len(oomInstance.ContainerName))
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("timeout happened before oomInstance was found")
+	}
+}
+
+func TestRawLines(t *testing.T) {
+	lines := []string{startLine, containerLine, "Jan 21 22:01:49 localhost CRON[14608]: (root) CMD (true)", endLine}
+	input := strings.Join(lines, "\n") + "\n"
+	oomLog := NewFromReader(strings.NewReader(input + input))
+	oomLog.KeepRawLines = true
+	oomLog.CloseStreamOnExit = true
+	outStream := make(chan *OomInstance)
+	go oomLog.StreamOoms(outStream)
+	oomInstance := <-outStream
+	expected := []string{startLine, containerLine, endLine}
+	if !reflect.DeepEqual(oomInstance.RawLines, expected) {
+		t.Errorf("expected raw lines %q, got %q", expected, oomInstance.RawLines)
+	}
+	for range outStream {
+	}
+
+	oomLog = NewFromReader(strings.NewReader(input))
+	oomLog.KeepRawLines = true
+	oomLog.MaxRawLines = 2
+	outStream = make(chan *OomInstance)
+	go oomLog.StreamOoms(outStream)
+	if oomInstance := <-outStream; !reflect.DeepEqual(oomInstance.RawLines, expected[:2]) {
+		t.Errorf("expected raw lines capped at %q, got %q", expected[:2], oomInstance.RawLines)
+	}
+
+	if oomInstance := readOneOom(containerLogFile, t); oomInstance.RawLines != nil {
+		t.Errorf("expected no raw lines unless KeepRawLines is set, got %q", oomInstance.RawLines)
+	}
+}
+
+func TestParseAll(t *testing.T) {
+	for _, logFile := range []string{containerLogFile, systemLogFile, kubepodsLogFile, cgroupv2LogFile, pagesLogFile, groupKillLogFile} {
+		oomLog := mockOomParser(logFile, t)
+		oomLog.CloseStreamOnExit = true
+		outStream := make(chan *OomInstance)
+		go oomLog.StreamOoms(outStream)
+		var streamed []*OomInstance
+		for oomInstance := range outStream {
+			streamed = append(streamed, oomInstance)
+		}
+
+		file, err := os.Open(logFile)
+		if err != nil {
+			t.Fatalf("had an error opening file: %v", err)
+		}
+		parsed, err := ParseAll(file)
+		file.Close()
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", logFile, err)
+		}
+		if len(parsed) == 0 || !reflect.DeepEqual(parsed, streamed) {
+			t.Errorf("%s: expected ParseAll to find the OOMs streamed, %v, got %v", logFile, streamed, parsed)
+		}
+	}
+
+	// A dump without a trailing newline is still complete.
+	parsed, err := ParseAll(strings.NewReader(startLine + "\n" + containerLine + "\n" + endLine))
+	if err != nil || len(parsed) != 1 || parsed[0].Pid != 19667 {
+		t.Errorf("expected one OOM without error, got %v and %v", parsed, err)
+	}
+}
+
+func TestParseOomBlock(t *testing.T) {
+	for _, logFile := range []string{containerLogFile, systemLogFile, kubepodsLogFile, cgroupv2LogFile, groupKillLogFile} {
+		contents, err := ioutil.ReadFile(logFile)
+		if err != nil {
+			t.Fatalf("had an error reading file: %v", err)
+		}
+		oomInstance, err := ParseOomBlock(strings.Split(string(contents), "\n"))
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", logFile, err)
+		}
+		if expected := readOneOom(logFile, t); !reflect.DeepEqual(oomInstance, expected) {
+			t.Errorf("%s: expected the OOM streamed, %v, got %v", logFile, expected, oomInstance)
+		}
+	}
+
+	oomInstance, err := ParseOomBlock([]string{startLine + "\n", containerLine + "\n", endLine + "\n"})
+	if err != nil || oomInstance.Pid != 19667 || oomInstance.ContainerName != "/mem2" {
+		t.Errorf("expected the OOM of pid 19667 in /mem2 without error, got %v and %v", oomInstance, err)
+	}
+	// A line that fails to parse is reported, along with what did parse.
+	badPid := "Sep  2 14:31:05 worker-1 kernel: [ 9012.345086] oom-kill:constraint=CONSTRAINT_MEMCG,nodemask=(null),cpuset=/,mems_allowed=0,oom_memcg=/mem3,task_memcg=/mem2,task=evilprogram2,pid=99999999999999999999,uid=2000"
+	oomInstance, err = ParseOomBlock([]string{startLine, badPid, endLine})
+	if err == nil || oomInstance == nil || oomInstance.VictimUID != 2000 {
+		t.Errorf("expected the OOM of uid 2000 along with an error for the pid, got %v and %v", oomInstance, err)
+	}
+
+	for _, lines := range [][]string{
+		nil,
+		{"not a kernel message"},
+		{startLine, containerLine, endLine, startLine, containerLine, endLine},
+		// Cut short before the victim.
+		{startLine, containerLine},
+	} {
+		if oomInstance, err := ParseOomBlock(lines); err == nil || oomInstance != nil {
+			t.Errorf("%q: expected an error and no OOM, got %v and %v", lines, oomInstance, err)
+		}
+	}
+}
+
+func TestCgroupStats(t *testing.T) {
+	testCases := []struct {
+		logFile  string
+		expected map[string]uint64
+	}{
+		// cgroup v1
+		{containerLogFile, map[string]uint64{"rss": 980 * 1024, "inactive_anon": 560 * 1024, "writeback": 20 * 1024}},
+		// cgroup v1, where the pod's stats come first then the container's
+		{kubepodsLogFile, map[string]uint64{"rss": 0}},
+		// cgroup v2
+		{cgroupv2LogFile, map[string]uint64{"anon": 133169152, "slab": 245760, "pgfault": 32977}},
+	}
+	for _, testCase := range testCases {
+		oomLog := mockOomParser(testCase.logFile, t)
+		oomLog.ParseCgroupStats = true
+		outStream := make(chan *OomInstance)
+		go oomLog.StreamOoms(outStream)
+		select {
+		case oomInstance := <-outStream:
+			for stat, expected := range testCase.expected {
+				if value, ok := oomInstance.CgroupStats[stat]; !ok || value != expected {
+					t.Errorf("%s: expected %s to be %d, got %d", testCase.logFile, stat, expected, value)
+				}
+			}
+			if _, ok := oomInstance.CgroupStats["pgmajfault"]; testCase.logFile == cgroupv2LogFile && !ok {
+				t.Errorf("%s: expected the whole v2 block to be read, got %v", testCase.logFile, oomInstance.CgroupStats)
+			}
+		case <-time.After(1 * time.Second):
+			t.Errorf("timeout happened before oomInstance was found in %s", testCase.logFile)
+		}
+		oomLog.Close()
+	}
+
+	if oomInstance := readOneOom(cgroupv2LogFile, t); oomInstance.CgroupStats != nil {
+		t.Errorf("expected no stats unless ParseCgroupStats is set, got %v", oomInstance.CgroupStats)
+	}
+}
+
+func TestTruncatedContainerName(t *testing.T) {
+	const prefix = "Sep  2 14:31:05 worker-1 kernel: [ 9012.345086] "
+	longPath := "/kubepods.slice/" + strings.Repeat("kubepods-burstable-pod0f1e2d3c.slice/", 30)
+	atLimit := func(message string) string {
+		return message[:kernelMaxMessageLen]
+	}
+	testCases := []struct {
+		line      string
+		truncated bool
+	}{
+		{oomKillLine, false},
+		{containerLine, false},
+		{prefix + "oom-kill:constraint=CONSTRAINT_NONE,nodemask=(null),cpuset=/,mems_allowed=0,global_oom,task_memcg=/user.slice,task=stress,pid=48213,uid=0", false},
+		// Cut off in task_memcg.
+		{prefix + "oom-kill:constraint=CONSTRAINT_MEMCG,nodemask=(null),cpuset=stress.scope,mems_allowed=0,oom_memcg=/mem3,task_memcg=/mem3/me", true},
+		// Cut off in oom_memcg.
+		{prefix + "oom-kill:constraint=CONSTRAINT_MEMCG,nodemask=(null),cpuset=stress.scope,mems_allowed=0,oom_memcg=/me", true},
+		{prefix + atLimit("oom-kill:constraint=CONSTRAINT_MEMCG,nodemask=(null),cpuset=stress.scope,mems_allowed=0,oom_memcg="+longPath+",task_memcg="+longPath+",task=stress,pid=48213,uid=0"), true},
+		{prefix + atLimit("Task in /mem2 killed as a result of limit of "+longPath), true},
+	}
+	for _, testCase := range testCases {
+		currentOomInstance := new(OomInstance)
+		if err := DefaultMatchers.getContainerName(testCase.line, currentOomInstance); err != nil {
+			t.Errorf("%q: unexpected error %v", testCase.line, err)
+		}
+		if currentOomInstance.ContainerName == "" && currentOomInstance.VictimContainerName == "" || currentOomInstance.Truncated != testCase.truncated {
+			t.Errorf("%q: expected a container name with Truncated %v, got %q in %q with %v", testCase.line, testCase.truncated, currentOomInstance.ContainerName, currentOomInstance.VictimContainerName, currentOomInstance.Truncated)
+		}
+	}
+}
+
+func TestSwap(t *testing.T) {
+	const prefix = "Jan 21 22:01:49 localhost kernel: [62279.001234] "
+	testCases := []struct {
+		name      string
+		counters  []string
+		usage     uint64
+		limit     uint64
+		unlimited bool
+	}{
+		{"v1", []string{"memory: usage 262144kB, limit 262144kB, failcnt 87", "memory+swap: usage 393216kB, limit 524288kB, failcnt 0"}, 128 << 20, 256 << 20, false},
+		{"v1 unlimited", []string{"memory: usage 262144kB, limit 262144kB, failcnt 87", "memory+swap: usage 393216kB, limit 9007199254740988kB, failcnt 0"}, 128 << 20, 0, true},
+		{"v1 without swap accounting", []string{"memory: usage 262144kB, limit 262144kB, failcnt 87", "memory+swap: usage 0kB, limit 9007199254740988kB, failcnt 0"}, 0, 0, false},
+		{"v2", []string{"memory: usage 262144kB, limit 262144kB, failcnt 87", "swap: usage 524288kB, limit 1048576kB, failcnt 3"}, 512 << 20, 1 << 30, false},
+		{"v2 unlimited", []string{"memory: usage 262144kB, limit 262144kB, failcnt 87", "swap: usage 524288kB, limit 9007199254740988kB, failcnt 0"}, 512 << 20, 0, true},
+		{"v2 swap off", []string{"memory: usage 262144kB, limit 262144kB, failcnt 87", "swap: usage 0kB, limit 0kB, failcnt 0"}, 0, 0, false},
+	}
+	for _, testCase := range testCases {
+		input := startLine + "\n" + containerLine + "\n"
+		for _, counter := range testCase.counters {
+			input += prefix + counter + "\n"
+		}
+		input += endLine + "\n"
+		oomInstances, err := ParseAll(strings.NewReader(input))
+		if err != nil || len(oomInstances) != 1 {
+			t.Fatalf("%s: expected 1 OOM, got %v and %v", testCase.name, oomInstances, err)
+		}
+		if oomInstance := oomInstances[0]; oomInstance.SwapUsageBytes != testCase.usage || oomInstance.SwapLimitBytes != testCase.limit || oomInstance.SwapUnlimited != testCase.unlimited {
+			t.Errorf("%s: expected swap usage %d and limit %d with SwapUnlimited %v, got %d and %d with %v", testCase.name, testCase.usage, testCase.limit, testCase.unlimited, oomInstance.SwapUsageBytes, oomInstance.SwapLimitBytes, oomInstance.SwapUnlimited)
+		}
+	}
+
+	for _, testCase := range []struct {
+		logFile   string
+		unlimited bool
+	}{
+		{containerLogFile, false},
+		{kubepodsLogFile, false},
+		{cgroupv2LogFile, false},
+		// Swap is accounted, but none was used.
+		{pagesLogFile, true},
+	} {
+		if oomInstance := readOneOom(testCase.logFile, t); oomInstance.SwapUsageBytes != 0 || oomInstance.SwapLimitBytes != 0 || oomInstance.SwapUnlimited != testCase.unlimited {
+			t.Errorf("%s: expected no swap with SwapUnlimited %v, got %d of %d with %v", testCase.logFile, testCase.unlimited, oomInstance.SwapUsageBytes, oomInstance.SwapLimitBytes, oomInstance.SwapUnlimited)
+		}
+	}
+}
+
+func TestMemInfo(t *testing.T) {
+	memInfo := []string{
+		"Sep  2 14:31:05 worker-1 kernel: [ 9012.341810] Mem-Info:",
+		"Sep  2 14:31:05 worker-1 kernel: [ 9012.341815] active_anon:1894243 inactive_anon:61207 isolated_anon:0",
+		"Sep  2 14:31:05 worker-1 kernel: [ 9012.341833] 61514 total pagecache pages",
+		"Sep  2 14:31:05 worker-1 kernel: [ 9012.341835] 20117 pages in swap cache",
+		"Sep  2 14:31:05 worker-1 kernel: [ 9012.341837] Free swap  = 1428kB",
+		"Sep  2 14:31:05 worker-1 kernel: [ 9012.341838] Total swap = 2097148kB",
+		"Sep  2 14:31:05 worker-1 kernel: [ 9012.341839] 2097017 pages RAM",
+		"Sep  2 14:31:05 worker-1 kernel: [ 9012.341840] 0 pages HighMem/MovableOnly",
+		"Sep  2 14:31:05 worker-1 kernel: [ 9012.341841] 47766 pages reserved",
+	}
+	input := startLine + "\n" + strings.Join(memInfo, "\n") + "\n" + endLine + "\n"
+	oomLog := NewFromReader(strings.NewReader(input))
+	oomLog.ParseCgroupStats = true
+	oomLog.CloseStreamOnExit = true
+	outStream := make(chan *OomInstance)
+	go oomLog.StreamOoms(outStream)
+	var oomInstances []*OomInstance
+	for oomInstance := range outStream {
+		oomInstances = append(oomInstances, oomInstance)
+	}
+	if len(oomInstances) != 1 {
+		t.Fatalf("expected 1 OOM, got %d", len(oomInstances))
+	}
+	if oomInstance := oomInstances[0]; oomInstance.FreeSwapKB != 1428 || oomInstance.TotalSwapKB != 2097148 || oomInstance.TotalRAMPages != 2097017 {
+		t.Errorf("expected 1428kB of 2097148kB swap free and 2097017 pages of RAM, got %d, %d and %d", oomInstance.FreeSwapKB, oomInstance.TotalSwapKB, oomInstance.TotalRAMPages)
+	}
+
+	oomLog = mockOomParser(systemLogFile, t)
+	oomLog.ParseCgroupStats = true
+	outStream = make(chan *OomInstance)
+	go oomLog.StreamOoms(outStream)
+	select {
+	case oomInstance := <-outStream:
+		if oomInstance.FreeSwapKB != 0 || oomInstance.TotalSwapKB != 0 || oomInstance.TotalRAMPages != 445340 {
+			t.Errorf("%s: expected no swap and 445340 pages of RAM, got %d, %d and %d", systemLogFile, oomInstance.FreeSwapKB, oomInstance.TotalSwapKB, oomInstance.TotalRAMPages)
+		}
+	case <-time.After(1 * time.Second):
+		t.Errorf("timeout happened before oomInstance was found in %s", systemLogFile)
+	}
+	oomLog.Close()
+
+	if oomInstance := readOneOom(systemLogFile, t); oomInstance.TotalRAMPages != 0 {
+		t.Errorf("expected no Mem-Info unless ParseCgroupStats is set, got %d pages of RAM", oomInstance.TotalRAMPages)
+	}
+}
+
+func TestSelfKill(t *testing.T) {
+	testCases := []struct {
+		stackHeader string
+		invokingTid int
+		selfKill    bool
+	}{
+		{"Jan 21 22:01:49 localhost kernel: [62278.816270] CPU: 0 PID: 19667 Comm: ruby Not tainted 4.4.0-112-generic #135-Ubuntu", 19667, true},
+		{"Jan 21 22:01:49 localhost kernel: [62278.816270] CPU: 0 UID: 0 PID: 19667 Comm: ruby Not tainted 6.11.0-9-generic #9-Ubuntu", 19667, true},
+		// Another thread of the process killed, whose id is not its pid.
+		{"Jan 21 22:01:49 localhost kernel: [62278.816270] CPU: 0 PID: 19670 Comm: ruby-timer-thr Not tainted 4.4.0-112-generic #135-Ubuntu", 19670, false},
+		// A bystander killed to satisfy another process's allocation.
+		{"Jan 21 22:01:49 localhost kernel: [62278.816270] CPU: 0 PID: 1024 Comm: ruby Not tainted 4.4.0-112-generic #135-Ubuntu", 1024, false},
+		// Without the stack dump the invoking thread is unknown.
+		{"", 0, false},
+	}
+	for _, testCase := range testCases {
+		input := startLine + "\n"
+		if testCase.stackHeader != "" {
+			input += testCase.stackHeader + "\n"
+		}
+		input += containerLine + "\n" + endLine + "\n"
+		oomLog := NewFromReader(strings.NewReader(input))
+		outStream := make(chan *OomInstance)
+		go oomLog.StreamOoms(outStream)
+		select {
+		case oomInstance := <-outStream:
+			if oomInstance.InvokingTid != testCase.invokingTid || oomInstance.SelfKill != testCase.selfKill {
+				t.Errorf("%q: expected invoking thread %d with SelfKill %v, got %d with %v", testCase.stackHeader, testCase.invokingTid, testCase.selfKill, oomInstance.InvokingTid, oomInstance.SelfKill)
+			}
+		case <-time.After(1 * time.Second):
+			t.Errorf("%q: timeout happened before oomInstance was found", testCase.stackHeader)
+		}
+		oomLog.Close()
+	}
+
+	for _, logFile := range []string{containerLogFile, systemLogFile, kubepodsLogFile, pagesLogFile} {
+		if oomInstance := readOneOom(logFile, t); !oomInstance.SelfKill {
+			t.Errorf("%s: expected the invoking thread %d to have been killed, got %d", logFile, oomInstance.InvokingTid, oomInstance.VictimGlobalPid)
+		}
+	}
+}
+
+func TestIsGlobal(t *testing.T) {
+	if oomInstance := readOneOom(systemLogFile, t); !oomInstance.IsGlobal {
+		t.Errorf("expected the OOM in %s to be global", systemLogFile)
+	}
+	if oomInstance := readOneOom(containerLogFile, t); oomInstance.IsGlobal {
+		t.Errorf("expected the OOM in %s not to be global", containerLogFile)
+	}
+
+	globalOomKillLine := "Sep  2 14:31:05 worker-1 kernel: [ 9012.345086] oom-kill:constraint=CONSTRAINT_NONE,nodemask=(null),cpuset=/,mems_allowed=0,global_oom,task_memcg=/kubepods/pod1,task=evilprogram2,pid=19667,uid=0"
+	input := startLine + "\n" + globalOomKillLine + "\n" + endLine + "\n" + startLine + "\n" + containerLine + "\n" + endLine + "\n"
+	for _, dropGlobalOoms := range []bool{false, true} {
+		oomLog := NewFromReader(strings.NewReader(input))
+		oomLog.DropGlobalOoms = dropGlobalOoms
+		oomLog.CloseStreamOnExit = true
+		outStream := make(chan *OomInstance)
+		go oomLog.StreamOoms(outStream)
+
+		var oomInstances []*OomInstance
+		for oomInstance := range outStream {
+			oomInstances = append(oomInstances, oomInstance)
+		}
+		if dropGlobalOoms {
+			if len(oomInstances) != 1 || oomInstances[0].IsGlobal {
+				t.Errorf("expected only the memcg OOM with DropGlobalOoms set, got %v", oomInstances)
+			}
+			continue
+		}
+		if len(oomInstances) != 2 {
+			t.Fatalf("expected 2 OOMs, got %v", oomInstances)
+		}
+		if !oomInstances[0].IsGlobal || oomInstances[0].ContainerName != "/kubepods/pod1" {
+			t.Errorf("expected a global OOM of a task in /kubepods/pod1, got %v", oomInstances[0])
+		}
+		if oomInstances[1].IsGlobal {
+			t.Errorf("expected a memcg OOM, got %v", oomInstances[1])
+		}
+	}
+}
+
+func TestAnnotateBootID(t *testing.T) {
+	defer func(original func() (string, error)) { readBootID = original }(readBootID)
+	input := startLine + "\n" + containerLine + "\n" + endLine + "\n"
+	testCases := []struct {
+		annotate bool
+		bootID   string
+		err      error
+		expected string
+	}{
+		{true, "1c3fb2c5-8a6e-4b1e-9d2b-7f0e5a9c4d11", nil, "1c3fb2c5-8a6e-4b1e-9d2b-7f0e5a9c4d11"},
+		{true, "", os.ErrNotExist, ""},
+		{false, "1c3fb2c5-8a6e-4b1e-9d2b-7f0e5a9c4d11", nil, ""},
+	}
+	for _, testCase := range testCases {
+		reads := 0
+		readBootID = func() (string, error) {
+			reads++
+			return testCase.bootID, testCase.err
+		}
+		oomLog := NewFromReader(strings.NewReader(input))
+		oomLog.AnnotateBootID = testCase.annotate
+		for i := 0; i < 2; i++ {
+			outStream := make(chan *OomInstance, 1)
+			if err := oomLog.StreamOomsContext(context.Background(), outStream); err != io.EOF {
+				t.Errorf("expected the stream to end with %v, got %v", io.EOF, err)
+			}
+			if i > 0 {
+				// The input was all read by the first stream.
+				continue
+			}
+			select {
+			case oomInstance := <-outStream:
+				if oomInstance.BootID != testCase.expected {
+					t.Errorf("expected BootID %q with AnnotateBootID %v, got %q", testCase.expected, testCase.annotate, oomInstance.BootID)
+				}
+			default:
+				t.Errorf("expected an OOM from %q", input)
+			}
+		}
+		expectedReads := 0
+		if testCase.annotate {
+			expectedReads = 1
+		}
+		if reads != expectedReads {
+			t.Errorf("expected the boot id to be read %d times with AnnotateBootID %v, got %d", expectedReads, testCase.annotate, reads)
+		}
+	}
+}
+
+func TestCpusetFallback(t *testing.T) {
+	oomKillLine := "Sep  2 14:31:05 worker-1 kernel: [ 9012.345086] oom-kill:constraint=CONSTRAINT_MEMCG,nodemask=(null),cpuset=cri-containerd-a1b2c3d4e5f6.scope,mems_allowed=0,"
+	cpusetLine := "Mar 14 10:02:11 node-3 kernel: [80912.102213] stress cpuset=5b7f0cd34578 mems_allowed=0"
+	testCases := []struct {
+		lines      []string
+		expected   string
+		fromCpuset bool
+	}{
+		// Cut short before task_memcg, or before oom_memcg.
+		{[]string{oomKillLine + "oom_memcg=/kubepods.slice/kubepods-burstable.slice/kubep"}, "/cri-containerd-a1b2c3d4e5f6.scope", true},
+		{[]string{oomKillLine + "oom_"}, "/cri-containerd-a1b2c3d4e5f6.scope", true},
+		{[]string{oomKillLine + "oom_memcg=/kubepods.slice,task_memcg=/,task=stress,pid=19667,uid=0"}, "/cri-containerd-a1b2c3d4e5f6.scope", true},
+		{[]string{oomKillLine + "oom_memcg=/kubepods.slice,task_memcg=/kubepods.slice/pod1,task=stress,pid=19667,uid=0"}, "/kubepods.slice/pod1", false},
+		{[]string{cpusetLine, "Mar 14 10:02:11 node-3 kernel: [80912.102263] Task in / killed as a result of limit of /kubepods/pod1"}, "/5b7f0cd34578", true},
+		{[]string{cpusetLine, containerLine}, "/mem2", false},
+		{[]string{"Mar 14 10:02:11 node-3 kernel: [80912.102213] stress cpuset=/ mems_allowed=0", "Mar 14 10:02:11 node-3 kernel: [80912.102263] Task in / killed as a result of limit of /kubepods/pod1"}, "/", false},
+		// The invoking process of a global OOM need not be in the
+		// container of its victim.
+		{[]string{cpusetLine}, "/", false},
+		{[]string{"Sep  2 14:31:05 worker-1 kernel: [ 9012.345086] oom-kill:constraint=CONSTRAINT_NONE,nodemask=(null),cpuset=cri-containerd-a1b2c3d4e5f6.scope,mems_allowed=0,global_oom,task_memcg=/,task=stress,pid=19667,uid=0"}, "/", false},
+	}
+	for _, testCase := range testCases {
+		lines := append(append([]string{startLine}, testCase.lines...), endLine)
+		oomInstances, err := ParseAll(strings.NewReader(strings.Join(lines, "\n") + "\n"))
+		if err != nil || len(oomInstances) != 1 {
+			t.Fatalf("expected an OOM from %q, got %v and %v", testCase.lines, oomInstances, err)
+		}
+		oomInstance := oomInstances[0]
+		if oomInstance.ContainerName != testCase.expected || oomInstance.ContainerNameFromCpuset != testCase.fromCpuset {
+			t.Errorf("expected ContainerName %q with ContainerNameFromCpuset %v from %q, got %q and %v", testCase.expected, testCase.fromCpuset, testCase.lines, oomInstance.ContainerName, oomInstance.ContainerNameFromCpuset)
+		}
+	}
+	for _, logFile := range []string{containerLogFile, kubepodsLogFile, cgroupv2LogFile, systemLogFile} {
+		if oomInstance := readOneOom(logFile, t); oomInstance.ContainerNameFromCpuset {
+			t.Errorf("expected the ContainerName reported in %s to be used, got %q", logFile, oomInstance.ContainerName)
+		}
+	}
+}
+
+func TestRootMemcgKill(t *testing.T) {
+	testCases := []struct {
+		line          string
+		isGlobal      bool
+		rootMemcgKill bool
+	}{
+		{"Jan 21 22:01:49 localhost kernel: [62279.421192] Task in / killed as a result of limit of /", false, true},
+		{"Jan 21 22:01:49 localhost kernel: [62279.421192] Task in /mem2 killed as a result of limit of /", false, true},
+		{"Sep  2 14:31:05 worker-1 kernel: [ 9012.345086] oom-kill:constraint=CONSTRAINT_MEMCG,nodemask=(null),cpuset=/,mems_allowed=0,oom_memcg=/,task_memcg=/,task=evilprogram2,pid=19667,uid=0", false, true},
+		{"Sep  2 14:31:05 worker-1 kernel: [ 9012.345086] oom-kill:constraint=CONSTRAINT_NONE,nodemask=(null),cpuset=/,mems_allowed=0,global_oom,task_memcg=/,task=evilprogram2,pid=19667,uid=0", true, false},
+		{containerLine, false, false},
+		// Older kernels report no cgroups for a global OOM.
+		{"", true, false},
+	}
+	for _, testCase := range testCases {
+		input := startLine + "\n" + testCase.line + "\n" + endLine + "\n"
+		oomInstances, err := ParseAll(strings.NewReader(input))
+		if err != nil || len(oomInstances) != 1 {
+			t.Fatalf("expected an OOM from %q, got %v and %v", testCase.line, oomInstances, err)
+		}
+		oomInstance := oomInstances[0]
+		if oomInstance.IsGlobal != testCase.isGlobal || oomInstance.RootMemcgKill != testCase.rootMemcgKill {
+			t.Errorf("expected IsGlobal %v and RootMemcgKill %v from %q, got %v and %v", testCase.isGlobal, testCase.rootMemcgKill, testCase.line, oomInstance.IsGlobal, oomInstance.RootMemcgKill)
+		}
+	}
+	if oomInstance := readOneOom(systemLogFile, t); oomInstance.RootMemcgKill {
+		t.Errorf("expected the global OOM in %s not to be of the root memory cgroup", systemLogFile)
+	}
+}
+
+func TestScope(t *testing.T) {
+	oomKillPrefix := "Sep  2 14:31:05 worker-1 kernel: [ 9012.345086] oom-kill:"
+	testCases := []struct {
+		constraint string
+		line       string
+		scope      OomScope
+	}{
+		// Classified by the kernel.
+		{"", oomKillPrefix + "constraint=CONSTRAINT_NONE,nodemask=(null),cpuset=/,mems_allowed=0,global_oom,task_memcg=/kubepods/pod1,task=evilprogram2,pid=19667,uid=0", ScopeGlobal},
+		{"", oomKillPrefix + "constraint=CONSTRAINT_MEMORY_POLICY,nodemask=1,cpuset=/,mems_allowed=0-1,global_oom,task_memcg=/user.slice,task=evilprogram2,pid=19667,uid=0", ScopeGlobal},
+		{"", oomKillPrefix + "constraint=CONSTRAINT_CPUSET,nodemask=(null),cpuset=numa0,mems_allowed=0,global_oom,task_memcg=/user.slice,task=evilprogram2,pid=19667,uid=0", ScopeCpuset},
+		{"", oomKillPrefix + "constraint=CONSTRAINT_MEMCG,nodemask=(null),cpuset=/,mems_allowed=0,oom_memcg=/mem3,task_memcg=/mem3/mem2,task=evilprogram2,pid=19667,uid=0", ScopeMemcg},
+		// The kernel's classification wins over the constraint.
+		{"", oomKillPrefix + "constraint=CONSTRAINT_NONE,nodemask=(null),cpuset=/,mems_allowed=0,oom_memcg=/mem3,task_memcg=/mem3/mem2,task=evilprogram2,pid=19667,uid=0", ScopeMemcg},
+		// Inferred from the constraint.
+		{ConstraintNone, containerLine, ScopeGlobal},
+		{ConstraintCpuset, "", ScopeCpuset},
+		{ConstraintMemoryPolicy, "", ScopeGlobal},
+		{ConstraintMemcg, "", ScopeMemcg},
+		// Inferred from whether a memory cgroup hit its limit.
+		{"", containerLine, ScopeMemcg},
+		{"", "", ScopeGlobal},
+	}
+	for _, testCase := range testCases {
+		firstLine := startLine
+		if testCase.constraint != "" {
+			firstLine += ", constraint=" + testCase.constraint
+		}
+		input := firstLine + "\n" + testCase.line + "\n" + endLine + "\n"
+		oomInstances, err := ParseAll(strings.NewReader(input))
+		if err != nil || len(oomInstances) != 1 {
+			t.Fatalf("expected an OOM from %q, got %v and %v", input, oomInstances, err)
+		}
+		if scope := oomInstances[0].Scope; scope != testCase.scope {
+			t.Errorf("expected scope %q from %q, got %q", testCase.scope, input, scope)
+		}
+	}
+	if oomInstance := readOneOom(systemLogFile, t); oomInstance.Scope != ScopeGlobal {
+		t.Errorf("expected the OOM in %s to be global, got %q", systemLogFile, oomInstance.Scope)
+	}
+	if oomInstance := readOneOom(cgroupv2LogFile, t); oomInstance.Scope != ScopeMemcg {
+		t.Errorf("expected the OOM in %s to be of a memory cgroup, got %q", cgroupv2LogFile, oomInstance.Scope)
+	}
+}
+
+func TestVictimGlobalPid(t *testing.T) {
+	// Both kills are of processes in containers with their own pid
+	// namespaces, but only their global pids are logged.
+	testCases := []struct {
+		logFile string
+		pid     int
+	}{
+		{kubepodsLogFile, 30211},
+		{cgroupv2LogFile, 48213},
+	}
+	for _, testCase := range testCases {
+		oomInstance := readOneOom(testCase.logFile, t)
+		if oomInstance.VictimGlobalPid != testCase.pid || oomInstance.Pid != testCase.pid {
+			t.Errorf("%s: expected pid and global pid %d, got %d and %d", testCase.logFile, testCase.pid, oomInstance.Pid, oomInstance.VictimGlobalPid)
+		}
+	}
+}
+
+func TestStreamOomsGroupKill(t *testing.T) {
+	file, err := os.Open(groupKillLogFile)
+	if err != nil {
+		t.Fatalf("failed to open %s: %v", groupKillLogFile, err)
+	}
+	oomLog := NewFromReader(file)
+	oomLog.CloseStreamOnExit = true
+	outStream := make(chan *OomInstance)
+	go oomLog.StreamOoms(outStream)
+
+	expected := []struct {
+		pid       int
+		rss       uint64
+		groupKill bool
+	}{
+		{7011, 21301, false},
+		{7001, 812, true},
+		{7010, 21290, true},
+		{7012, 21288, true},
+	}
+	var oomInstances []*OomInstance
+	for oomInstance := range outStream {
+		oomInstances = append(oomInstances, oomInstance)
+	}
+	if len(oomInstances) != len(expected) {
+		t.Fatalf("expected an OOM for each of the %d processes killed, got %v", len(expected), oomInstances)
+	}
+	for i, oomInstance := range oomInstances {
+		if oomInstance.Pid != expected[i].pid || oomInstance.VictimRSSPages != expected[i].rss || oomInstance.GroupKill != expected[i].groupKill {
+			t.Errorf("expected pid %d with rss %d and GroupKill %v, got %d with %d and %v", expected[i].pid, expected[i].rss, expected[i].groupKill, oomInstance.Pid, oomInstance.VictimRSSPages, oomInstance.GroupKill)
+		}
+		if oomInstance.InvokingTid != 7011 || oomInstance.SelfKill != (oomInstance.Pid == 7011) {
+			t.Errorf("expected only pid 7011 to have invoked the OOM killer, got pid %d invoked by %d with SelfKill %v", oomInstance.Pid, oomInstance.InvokingTid, oomInstance.SelfKill)
+		}
+		if oomInstance.ContainerName != "/batch.slice/job-17.scope" || oomInstance.VictimContainerName != "/batch.slice/job-17.scope" {
+			t.Errorf("expected pid %d to be in /batch.slice/job-17.scope, got %v", oomInstance.Pid, oomInstance)
+		}
+		if oomInstance.MemoryLimitBytes != 262144*1024 || oomInstance.VictimUID != 1000 {
+			t.Errorf("expected pid %d to share the dump's details, got %v", oomInstance.Pid, oomInstance)
+		}
+	}
+}
+
+func TestStreamOomsSingleTaskKill(t *testing.T) {
+	groupKillLog, err := ioutil.ReadFile(groupKillLogFile)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", groupKillLogFile, err)
+	}
+	// Without the memory.oom.group line, the kills after the first are not
+	// of its group.
+	var withoutGroup []string
+	for _, line := range strings.Split(string(groupKillLog), "\n") {
+		if !strings.Contains(line, "memory.oom.group set") {
+			withoutGroup = append(withoutGroup, line)
+		}
+	}
+	cgroupv2Log, err := ioutil.ReadFile(cgroupv2LogFile)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", cgroupv2LogFile, err)
+	}
+	for _, input := range []string{string(cgroupv2Log), strings.Join(withoutGroup, "\n")} {
+		oomInstances, err := ParseAll(strings.NewReader(input))
+		if err != nil {
+			t.Fatalf("failed to parse %q: %v", input, err)
+		}
+		if len(oomInstances) != 1 || oomInstances[0].GroupKill {
+			t.Errorf("expected a single OOM without GroupKill, got %v", oomInstances)
+		}
+	}
+}
+
+func TestVictimRSSMissingRow(t *testing.T) {
+	input := startLine + "\n" + containerLine + "\n" + endLine + "\n"
+	oomLog := NewFromReader(strings.NewReader(input))
+	outStream := make(chan *OomInstance)
+	go oomLog.StreamOoms(outStream)
+	select {
+	case oomInstance := <-outStream:
+		if oomInstance.VictimRSSPages != 0 {
+			t.Errorf("expected no rss without a task table, got %d", oomInstance.VictimRSSPages)
+		}
+	case <-time.After(1 * time.Second):
+		t.Error("timeout happened before oomInstance was found in reader")
+	}
+}
+
+// readOneOom streams the given file and returns the first OomInstance found.
+func readOneOom(sysFile string, t *testing.T) *OomInstance {
+	oomLog := mockOomParser(sysFile, t)
+	defer oomLog.Close()
+	outStream := make(chan *OomInstance)
+	go oomLog.StreamOoms(outStream)
+	select {
+	case oomInstance := <-outStream:
+		return oomInstance
+	case <-time.After(1 * time.Second):
+		t.Fatalf("timeout happened before oomInstance was found in %s", sysFile)
+	}
+	return nil
+}
+
+func mockOomParser(sysFile string, t *testing.T) *OomParser {
+	file, err := os.Open(sysFile)
+	if err != nil {
+		t.Errorf("had an error opening file: %v", err)
+	}
+	return NewFromReader(file)
+}
+
+// a kernel log of 10000 lines with an OOM dump every 1000, in syslog format or,
+// if kmsg is set, as /dev/kmsg records
+func benchmarkLog(kmsg bool) string {
+	var lines []string
+	for i := 0; i < 10000; i++ {
+		if i%1000 == 0 {
+			lines = append(lines, startLine, containerLine, endLine)
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("Jan 21 22:01:49 localhost kernel: [62279.%06d] IPv4: martian source 10.0.0.%d from 10.0.0.1, on dev eth0", i, i%256))
+	}
+	if kmsg {
+		for i, line := range lines {
+			lines[i] = fmt.Sprintf("6,%d,%d,-;%s", i, i*100, line[strings.Index(line, "] ")+2:])
+		}
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func BenchmarkParseAll(b *testing.B) {
+	input := benchmarkLog(false)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ParseAll(strings.NewReader(input)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkStreamOomsKmsg(b *testing.B) {
+	input := benchmarkLog(true)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		oomLog := newKmsgOomParser(strings.NewReader(input), time.Unix(0, 0))
+		oomLog.CloseStreamOnExit = true
+		outStream := make(chan *OomInstance)
+		go oomLog.StreamOoms(outStream)
+		for range outStream {
+		}
 	}
 }
@@ -0,0 +1,64 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oomparser
+
+import (
+	"math"
+	"time"
+)
+
+// RateLimitMetrics may also be implemented by an OomParser's Metrics to count
+// the OOMs that RateLimit suppresses.
+type RateLimitMetrics interface {
+	// IncRateLimited is called for each OOM suppressed by the rate limit.
+	IncRateLimited()
+}
+
+// a token bucket allowing rate OOMs a second on average, and bursts of up to
+// burst OOMs.
+type rateLimiter struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+// burst defaults to rate rounded up, or 1 if that is less.
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst <= 0 {
+		burst = int(math.Max(1, math.Ceil(rate)))
+	}
+	return &rateLimiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		now:    time.Now,
+	}
+}
+
+// takes a token if one is available.
+func (self *rateLimiter) allow() bool {
+	now := self.now()
+	if !self.last.IsZero() {
+		self.tokens = math.Min(self.burst, self.tokens+now.Sub(self.last).Seconds()*self.rate)
+	}
+	self.last = now
+	if self.tokens < 1 {
+		return false
+	}
+	self.tokens--
+	return true
+}
@@ -0,0 +1,97 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oomparser
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2016, time.January, 21, 22, 1, 49, 0, time.UTC)
+	limiter := newRateLimiter(2, 0)
+	limiter.now = func() time.Time { return now }
+	for i, expected := range []bool{true, true, false} {
+		if allowed := limiter.allow(); allowed != expected {
+			t.Errorf("burst %d: expected allowed to be %v", i, expected)
+		}
+	}
+	now = now.Add(500 * time.Millisecond)
+	if !limiter.allow() {
+		t.Errorf("expected a token to be refilled after 500ms at 2 a second")
+	}
+	if limiter.allow() {
+		t.Errorf("expected only one token to be refilled after 500ms at 2 a second")
+	}
+	now = now.Add(time.Hour)
+	for i := 0; i < 2; i++ {
+		if !limiter.allow() {
+			t.Errorf("expected the bucket to refill to its burst of 2")
+		}
+	}
+	if limiter.allow() {
+		t.Errorf("expected the bucket to refill to no more than its burst of 2")
+	}
+}
+
+type rateLimitMetrics struct {
+	fakeMetrics
+	rateLimited int
+}
+
+func (self *rateLimitMetrics) IncRateLimited() { self.rateLimited++ }
+
+func TestStreamOomsRateLimit(t *testing.T) {
+	dump := startLine + "\n" + containerLine + "\n" + endLine + "\n"
+	input := strings.Repeat(dump, 10)
+	for _, coalesce := range []bool{false, true} {
+		oomLog := NewFromReader(strings.NewReader(input))
+		// Slow enough that no tokens are refilled during the test.
+		oomLog.RateLimit = 0.001
+		oomLog.RateLimitBurst = 3
+		oomLog.CoalesceRateLimited = coalesce
+		metrics := &rateLimitMetrics{}
+		oomLog.Metrics = metrics
+		oomLog.CloseStreamOnExit = true
+		outStream := make(chan *OomInstance)
+		go oomLog.StreamOoms(outStream)
+		var oomInstances []*OomInstance
+		for oomInstance := range outStream {
+			oomInstances = append(oomInstances, oomInstance)
+		}
+
+		if metrics.parsed != 10 || metrics.rateLimited != 7 {
+			t.Errorf("coalesce %v: expected 10 OOMs parsed and 7 suppressed, got %d and %d", coalesce, metrics.parsed, metrics.rateLimited)
+		}
+		expected := 3
+		if coalesce {
+			expected = 4
+		}
+		if len(oomInstances) != expected {
+			t.Fatalf("coalesce %v: expected %d OOMs, got %d", coalesce, expected, len(oomInstances))
+		}
+		for i, oomInstance := range oomInstances[:3] {
+			if oomInstance.EventSeq != uint64(i+1) || oomInstance.Coalesced != 0 {
+				t.Errorf("coalesce %v: expected OOM %d of the burst to be sent individually, got seq %d coalescing %d", coalesce, i, oomInstance.EventSeq, oomInstance.Coalesced)
+			}
+		}
+		if coalesce {
+			if summary := oomInstances[3]; summary.EventSeq != 10 || summary.Coalesced != 7 || summary.Pid != 19667 {
+				t.Errorf("expected the last OOM suppressed to stand for all 7, got %+v", summary)
+			}
+		}
+	}
+}
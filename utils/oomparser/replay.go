@@ -0,0 +1,96 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oomparser
+
+import (
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// the "[ 5864.708607]" before each message in dmesg and syslog output, which
+// is the time since boot of the kernel's monotonic clock
+var kernelTimestampRegexp = regexp.MustCompile(`\[\s*([0-9]+)\.([0-9]{1,9})\]`)
+
+// ReplayWithTiming paces the lines streamed from the parser's source to the
+// kernel timestamps in them, multiplier times faster than they were logged,
+// e.g. lines logged a minute apart are sent a second apart at a multiplier of
+// 60. It is meant for tests that replay saved logs, such as /dev/kmsg records
+// or dmesg output read with NewFromReader, so the gaps between OOMs are kept
+// when testing the windows of Dedup or Bursts. The first timestamped line is
+// sent at once; lines without a timestamp, or stamped before one already
+// sent, are not held back. A multiplier that is not positive turns pacing
+// off. It must be called before streaming starts.
+func (self *OomParser) ReplayWithTiming(multiplier float64) {
+	self.replaySpeed = multiplier
+}
+
+// replayPacer works out how long to hold back each line of a log being
+// replayed at speed times the rate it was logged.
+type replayPacer struct {
+	speed float64
+	// the timestamp of the first timestamped line and when it was sent,
+	// which later lines are paced from so that delays do not add up
+	first     time.Duration
+	firstSent time.Time
+	started   bool
+	now       func() time.Time
+}
+
+func newReplayPacer(speed float64) *replayPacer {
+	return &replayPacer{speed: speed, now: time.Now}
+}
+
+// returns how long to wait before sending a line with the given timestamp.
+func (self *replayPacer) delay(timestamp time.Duration) time.Duration {
+	now := self.now()
+	if !self.started {
+		self.first = timestamp
+		self.firstSent = now
+		self.started = true
+		return 0
+	}
+	due := self.firstSent.Add(time.Duration(float64(timestamp-self.first) / self.speed))
+	if delay := due.Sub(now); delay > 0 {
+		return delay
+	}
+	return 0
+}
+
+// returns the kernel timestamp of a /dev/kmsg record or a line of dmesg or
+// syslog output, and whether it has one.
+func lineTimestamp(line string) (time.Duration, bool) {
+	if record, err := ParseKmsgRecord(line); err == nil {
+		return record.Timestamp, true
+	}
+	match := kernelTimestampRegexp.FindStringSubmatch(line)
+	if match == nil {
+		return 0, false
+	}
+	seconds, err := strconv.ParseInt(match[1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	// The fraction is usually microseconds, but scale it whatever its length.
+	fraction := match[2]
+	for len(fraction) < 9 {
+		fraction += "0"
+	}
+	nanoseconds, err := strconv.ParseInt(fraction, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(seconds)*time.Second + time.Duration(nanoseconds), true
+}
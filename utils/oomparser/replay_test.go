@@ -0,0 +1,119 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oomparser
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// returns line with its kernel timestamp replaced by seconds since boot.
+func atUptime(line string, seconds float64) string {
+	return kernelTimestampRegexp.ReplaceAllString(line, fmt.Sprintf("[%.6f]", seconds))
+}
+
+func TestLineTimestamp(t *testing.T) {
+	testCases := []struct {
+		line      string
+		expected  time.Duration
+		timestamp bool
+	}{
+		{startLine, 62278*time.Second + 816267*time.Microsecond, true},
+		{"[  455.669006] Out of memory: Kill process 1532 (badsysprogram) score 919 or sacrifice child", 455*time.Second + 669006*time.Microsecond, true},
+		{"[    1.5] short fraction", 1500 * time.Millisecond, true},
+		{"6,1930,5864708440,-;memorymonster invoked oom-killer: gfp_mask=0xd0, order=0, oom_score_adj=0", 5864708440 * time.Microsecond, true},
+		{" SUBSYSTEM=usb", 0, false},
+		{"Jan 21 22:01:49 localhost kernel: [  19667]     0 19667   365004   353502", 0, false},
+	}
+	for _, testCase := range testCases {
+		timestamp, ok := lineTimestamp(testCase.line)
+		if timestamp != testCase.expected || ok != testCase.timestamp {
+			t.Errorf("expected the timestamp of %q to be %v (%v), got %v (%v)", testCase.line, testCase.expected, testCase.timestamp, timestamp, ok)
+		}
+	}
+}
+
+func TestReplayPacer(t *testing.T) {
+	now := time.Date(2016, time.January, 21, 22, 1, 49, 0, time.UTC)
+	pacer := newReplayPacer(60)
+	pacer.now = func() time.Time { return now }
+	if delay := pacer.delay(100 * time.Second); delay != 0 {
+		t.Errorf("expected the first line not to be held back, got %v", delay)
+	}
+	if delay := pacer.delay(160 * time.Second); delay != time.Second {
+		t.Errorf("expected a line a minute later to be held back a second at 60 times, got %v", delay)
+	}
+	// Lines are paced from the first, so time already spent is not waited
+	// for again.
+	now = now.Add(1500 * time.Millisecond)
+	if delay := pacer.delay(220 * time.Second); delay != 500*time.Millisecond {
+		t.Errorf("expected to wait the rest of the 2 seconds since the first line, got %v", delay)
+	}
+	if delay := pacer.delay(130 * time.Second); delay != 0 {
+		t.Errorf("expected a line stamped earlier not to be held back, got %v", delay)
+	}
+}
+
+func TestReplayWithTiming(t *testing.T) {
+	// Dumps logged 10 and 30 seconds after the first, replayed 100 times
+	// faster.
+	var lines []string
+	for _, uptime := range []float64{100, 110, 130} {
+		lines = append(lines, atUptime(startLine, uptime), atUptime(containerLine, uptime), atUptime(endLine, uptime+0.001))
+	}
+	oomLog := NewFromReader(strings.NewReader(strings.Join(lines, "\n") + "\n"))
+	oomLog.ReplayWithTiming(100)
+	oomLog.CloseStreamOnExit = true
+	outStream := make(chan *OomInstance)
+	go oomLog.StreamOoms(outStream)
+
+	var received []time.Time
+	for range outStream {
+		received = append(received, time.Now())
+	}
+	if len(received) != 3 {
+		t.Fatalf("expected 3 OOMs, got %d", len(received))
+	}
+	for i, expected := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond} {
+		if gap := received[i+1].Sub(received[i]); gap < expected*9/10 {
+			t.Errorf("expected OOM %d to be sent about %v after the one before, got %v", i+1, expected, gap)
+		}
+	}
+}
+
+func TestReplayWithTimingCancelled(t *testing.T) {
+	input := atUptime(startLine, 100) + "\n" + atUptime(startLine, 100000) + "\n"
+	oomLog := NewFromReader(strings.NewReader(input))
+	oomLog.ReplayWithTiming(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		errs <- oomLog.StreamOomsContext(ctx, make(chan *OomInstance))
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-errs:
+		if err != context.Canceled {
+			t.Errorf("expected the stream to end with %v, got %v", context.Canceled, err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected cancelling to stop waiting for the next line")
+	}
+}
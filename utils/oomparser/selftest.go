@@ -0,0 +1,139 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oomparser
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// selfTestDump is an OOM dump that SelfTest parses, and what it must parse
+// into.
+type selfTestDump struct {
+	// the form of the lines, for errors
+	name                string
+	lines               []string
+	pid                 int
+	processName         string
+	containerName       string
+	victimContainerName string
+	// the time of death in the "Jan _2 15:04:05" layout, as the year of a
+	// syslog date depends on when it is parsed, or empty if the lines have
+	// no date
+	timeOfDeath string
+}
+
+// The dumps of mainline kernels in the forms the parser reads them in.
+var selfTestDumps = []selfTestDump{
+	{
+		name: "syslog",
+		lines: []string{
+			"Jan 21 22:01:49 localhost kernel: [62278.816267] ruby invoked oom-killer: gfp_mask=0x201da, order=0, oom_score_adj=0",
+			"Jan 21 22:01:49 localhost kernel: [62279.421192] Task in /mem2 killed as a result of limit of /mem3",
+			"Jan 21 22:01:49 localhost kernel: [62279.421192] Killed process 19667 (evilprogram2) total-vm:1460016kB, anon-rss:1414008kB, file-rss:4kB",
+		},
+		pid:                 19667,
+		processName:         "evilprogram2",
+		containerName:       "/mem2",
+		victimContainerName: "/mem3",
+		timeOfDeath:         "Jan 21 22:01:49",
+	},
+	{
+		name: "journald",
+		lines: []string{
+			"2016-09-02T14:31:05+0000 worker-1 kernel: stress invoked oom-killer: gfp_mask=0xcc0(GFP_KERNEL), order=0, oom_score_adj=984",
+			"2016-09-02T14:31:05+0000 worker-1 kernel: oom-kill:constraint=CONSTRAINT_MEMCG,nodemask=(null),cpuset=stress.scope,mems_allowed=0,oom_memcg=/mem3,task_memcg=/mem3/mem2,task=stress,pid=48213,uid=0",
+			"2016-09-02T14:31:05+0000 worker-1 kernel: Memory cgroup out of memory: Killed process 48213 (stress) total-vm:134388kB, anon-rss:128696kB, file-rss:0kB, shmem-rss:0kB, UID:0 pgtables:300kB oom_score_adj:984",
+		},
+		pid:                 48213,
+		processName:         "stress",
+		containerName:       "/mem3/mem2",
+		victimContainerName: "/mem3",
+		timeOfDeath:         "Sep  2 14:31:05",
+	},
+	{
+		name: "dmesg",
+		lines: []string{
+			"[ 9012.345012] stress invoked oom-killer: gfp_mask=0xcc0(GFP_KERNEL), order=0, oom_score_adj=984",
+			"[ 9012.345086] oom-kill:constraint=CONSTRAINT_MEMCG,nodemask=(null),cpuset=stress.scope,mems_allowed=0,oom_memcg=/mem3,task_memcg=/mem3/mem2,task=stress,pid=48213,uid=0",
+			"[ 9012.345091] Memory cgroup out of memory: Killed process 48213 (stress) total-vm:134388kB, anon-rss:128696kB, file-rss:0kB, shmem-rss:0kB, UID:0 pgtables:300kB oom_score_adj:984",
+		},
+		pid:                 48213,
+		processName:         "stress",
+		containerName:       "/mem3/mem2",
+		victimContainerName: "/mem3",
+	},
+}
+
+// SelfTest checks that the parser's matchers, DefaultMatchers unless its
+// Matchers is set, parse known OOM dumps of mainline kernels, as logged to
+// syslog, journald and dmesg, into the OOMs expected, e.g. so that a liveness
+// probe can tell that OOMs would not be missed. The returned error says what
+// was parsed wrongly. A MatcherSet for a kernel that words its dumps
+// differently will fail it.
+func (self *OomParser) SelfTest() error {
+	for _, dump := range selfTestDumps {
+		if err := self.selfTestDump(dump); err != nil {
+			return fmt.Errorf("self test failed to parse the %s dump: %v", dump.name, err)
+		}
+	}
+	return nil
+}
+
+func (self *OomParser) selfTestDump(dump selfTestDump) error {
+	probe := NewFromReader(strings.NewReader(strings.Join(dump.lines, "\n") + "\n"))
+	probe.Matchers = self.Matchers
+	probe.Location = self.Location
+	probe.nowFunc = self.nowFunc
+	nextLine, _ := probe.readAll()
+	var oomInstances []*OomInstance
+	var parseErr error
+	emit := func(oomInstance *OomInstance) {
+		oomInstances = append(oomInstances, oomInstance)
+	}
+	reportError := func(line string, err error) {
+		if parseErr == nil {
+			parseErr = fmt.Errorf("failed to parse %q: %v", line, err)
+		}
+	}
+	probe.parseLines(nextLine, nil, emit, reportError, noopMetrics{})
+	if parseErr != nil {
+		return parseErr
+	}
+	if len(oomInstances) != 1 {
+		return fmt.Errorf("expected 1 OOM, got %d", len(oomInstances))
+	}
+	oomInstance := oomInstances[0]
+	timeOfDeath := ""
+	if !oomInstance.TimeOfDeath.IsZero() {
+		timeOfDeath = oomInstance.TimeOfDeath.Format(time.Stamp)
+	}
+	for _, field := range []struct {
+		name             string
+		parsed, expected interface{}
+	}{
+		{"pid", oomInstance.Pid, dump.pid},
+		{"process name", oomInstance.ProcessName, dump.processName},
+		{"container name", oomInstance.ContainerName, dump.containerName},
+		{"victim container name", oomInstance.VictimContainerName, dump.victimContainerName},
+		{"time of death", timeOfDeath, dump.timeOfDeath},
+	} {
+		if field.parsed != field.expected {
+			return fmt.Errorf("expected %s %q, got %q", field.name, fmt.Sprint(field.expected), fmt.Sprint(field.parsed))
+		}
+	}
+	return nil
+}
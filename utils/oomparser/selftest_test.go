@@ -0,0 +1,65 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oomparser
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSelfTest(t *testing.T) {
+	if err := NewFromReader(strings.NewReader("")).SelfTest(); err != nil {
+		t.Errorf("expected DefaultMatchers to pass the self test, got %v", err)
+	}
+	// Whatever the time zone and the date.
+	oomLog := NewFromReader(strings.NewReader(""))
+	oomLog.Location = time.FixedZone("JST", 9*60*60)
+	oomLog.nowFunc = func() time.Time { return time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC) }
+	if err := oomLog.SelfTest(); err != nil {
+		t.Errorf("expected the self test to pass in %v, got %v", oomLog.Location, err)
+	}
+
+	testCases := []struct {
+		change   func(*MatcherSet)
+		expected string
+	}{
+		{func(matchers *MatcherSet) { matchers.FirstLine = regexp.MustCompile(`invoked oom-killer!`) }, "syslog dump: expected 1 OOM, got 0"},
+		{func(matchers *MatcherSet) {
+			matchers.Container = regexp.MustCompile(`Task in (.*) killed as a result of limit of (\S{2})`)
+		}, `syslog dump: expected victim container name "/mem3", got "/m"`},
+		{func(matchers *MatcherSet) {
+			matchers.LastLine = regexp.MustCompile(`(^[A-Z][a-z]{2} [0-9]{2}) .* Killed process ([0-9]+) \(([\w]+)\)`)
+		}, "syslog dump: failed to parse"},
+		{func(matchers *MatcherSet) {
+			matchers.IsoLastLine = regexp.MustCompile(`(^[0-9]{4}-[0-9]{2}-[0-9]{2}) .* Killed process ([0-9]+) \(([\w]+)\)`)
+		}, `journald dump: expected time of death "Sep  2 14:31:05", got ""`},
+		{func(matchers *MatcherSet) { matchers.OomKill = regexp.MustCompile(`oom_kill:(.*)`) }, `journald dump: expected container name "/mem3/mem2", got "/"`},
+		{func(matchers *MatcherSet) {
+			matchers.UndatedLastLine = regexp.MustCompile(`Killed process ([0-9]+) \(([a-r]+)\)`)
+		}, "dmesg dump: expected 1 OOM, got 0"},
+	}
+	for _, testCase := range testCases {
+		matchers := DefaultMatchers
+		testCase.change(&matchers)
+		oomLog := NewFromReader(strings.NewReader(""))
+		oomLog.Matchers = &matchers
+		err := oomLog.SelfTest()
+		if err == nil || !strings.Contains(err.Error(), testCase.expected) {
+			t.Errorf("expected the self test to fail with %q, got %v", testCase.expected, err)
+		}
+	}
+}
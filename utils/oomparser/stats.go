@@ -0,0 +1,126 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oomparser
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// ParserStats is a snapshot of the counts of what an OomParser has streamed,
+// since it was created, across all of its streams.
+type ParserStats struct {
+	// the OOMs parsed, including those then filtered out, suppressed or
+	// dropped rather than sent
+	Parsed uint64
+	// the OOMs sent to the consumer, including those then Dropped as it was
+	// not keeping up
+	Sent uint64
+	// the lines that failed to parse, and those read that were not part of
+	// an OOM dump
+	ParseErrors       uint64
+	UnrecognizedLines uint64
+	// the times the source was reopened after reading it failed, and the
+	// times the parser failed over to another source, see Failover
+	Reconnects uint64
+	Failovers  uint64
+	// the OOMs dropped as the consumer was not keeping up, see Overflow, and
+	// those suppressed by RateLimit
+	Dropped     uint64
+	RateLimited uint64
+	// the Historical OOMs skipped for being older than MaxHistoryAge
+	SkippedHistorical uint64
+	// the /dev/kmsg records lost to the ring buffer being overwritten before
+	// they were read
+	LostKmsgRecords uint64
+	// when the last OOM was sent, or zero if none has been
+	LastSent time.Time
+}
+
+// the counters Stats reports, accessed atomically
+type parserCounters struct {
+	parsed            uint64
+	sent              uint64
+	parseErrors       uint64
+	unrecognizedLines uint64
+	reconnects        uint64
+	failovers         uint64
+	dropped           uint64
+	rateLimited       uint64
+	// in nanoseconds since the Unix epoch, or 0
+	lastSent int64
+	lastRead int64
+}
+
+// Stats returns the counts of what the parser has streamed so far. It is
+// safe to call while the parser is being streamed from. Reset does not
+// clear them.
+func (self *OomParser) Stats() ParserStats {
+	stats := ParserStats{
+		Parsed:            atomic.LoadUint64(&self.counters.parsed),
+		Sent:              atomic.LoadUint64(&self.counters.sent),
+		ParseErrors:       atomic.LoadUint64(&self.counters.parseErrors),
+		UnrecognizedLines: atomic.LoadUint64(&self.counters.unrecognizedLines),
+		Reconnects:        atomic.LoadUint64(&self.counters.reconnects),
+		Failovers:         atomic.LoadUint64(&self.counters.failovers),
+		Dropped:           atomic.LoadUint64(&self.counters.dropped),
+		RateLimited:       atomic.LoadUint64(&self.counters.rateLimited),
+		SkippedHistorical: atomic.LoadUint64(&self.skippedHistorical),
+		LostKmsgRecords:   atomic.LoadUint64(&self.lostKmsgRecs),
+	}
+	if lastSent := atomic.LoadInt64(&self.counters.lastSent); lastSent != 0 {
+		stats.LastSent = time.Unix(0, lastSent)
+	}
+	return stats
+}
+
+// LastActivity returns when the parser last read a line from its source, or
+// zero if it has read none. A source that has stalled stops it advancing, so
+// it can be alerted on if it goes stale for longer than the source is ever
+// expected to be quiet. It is safe to call while the parser is being streamed
+// from.
+func (self *OomParser) LastActivity() time.Time {
+	if lastRead := atomic.LoadInt64(&self.counters.lastRead); lastRead != 0 {
+		return time.Unix(0, lastRead)
+	}
+	return time.Time{}
+}
+
+// records that a line was just read, for LastActivity.
+func (self *OomParser) markRead() {
+	atomic.StoreInt64(&self.counters.lastRead, self.nowFunc().UnixNano())
+}
+
+// countingMetrics counts what is parsed for Stats, as well as passing it on
+// to the parser's Metrics.
+type countingMetrics struct {
+	Metrics
+	counters *parserCounters
+}
+
+func (self countingMetrics) IncParsed() {
+	atomic.AddUint64(&self.counters.parsed, 1)
+	self.Metrics.IncParsed()
+}
+
+func (self countingMetrics) IncParseError() {
+	atomic.AddUint64(&self.counters.parseErrors, 1)
+	self.Metrics.IncParseError()
+}
+
+func (self countingMetrics) IncUnrecognizedLine() {
+	atomic.AddUint64(&self.counters.unrecognizedLines, 1)
+	self.Metrics.IncUnrecognizedLine()
+}
@@ -0,0 +1,121 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oomparser
+
+import (
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	file, err := os.Open(groupKillLogFile)
+	if err != nil {
+		t.Fatalf("failed to open %s: %v", groupKillLogFile, err)
+	}
+	oomLog := NewFromReader(file)
+	oomLog.CloseStreamOnExit = true
+	metrics := &fakeMetrics{}
+	oomLog.Metrics = metrics
+	outStream := make(chan *OomInstance)
+	go oomLog.StreamOoms(outStream)
+
+	before := time.Now()
+	count := 0
+	for range outStream {
+		// Stats may be read while streaming.
+		oomLog.Stats()
+		count++
+	}
+	stats := oomLog.Stats()
+	if stats.Parsed != 4 || stats.Sent != 4 || count != 4 {
+		t.Errorf("expected the 4 OOMs in %s to be parsed and sent, got %+v", groupKillLogFile, stats)
+	}
+	if stats.ParseErrors != 0 || stats.UnrecognizedLines != uint64(metrics.unrecognizedLines) || stats.UnrecognizedLines == 0 {
+		t.Errorf("expected no parse errors and the %d unrecognized lines Metrics was told of, got %+v", metrics.unrecognizedLines, stats)
+	}
+	if stats.Reconnects != 0 || stats.Failovers != 0 || stats.Dropped != 0 || stats.RateLimited != 0 {
+		t.Errorf("expected nothing to be reopened or lost, got %+v", stats)
+	}
+	if stats.LastSent.Before(before) || stats.LastSent.After(time.Now()) {
+		t.Errorf("expected the last OOM to have been sent during the stream, got %v", stats.LastSent)
+	}
+}
+
+func TestStatsDropped(t *testing.T) {
+	dump := startLine + "\n" + containerLine + "\n" + endLine + "\n"
+	oomLog := NewFromReader(strings.NewReader(strings.Repeat(dump, 5) + "not a kernel message\n"))
+	oomLog.RateLimit = 1
+	oomLog.RateLimitBurst = 4
+	oomLog.Overflow = OverflowDropNewest
+	oomLog.OverflowBufferSize = 2
+	oomLog.CloseStreamOnExit = true
+	metrics := lineMetrics{make(chan struct{}, 1)}
+	oomLog.Metrics = metrics
+	outStream := make(chan *OomInstance)
+	go oomLog.StreamOoms(outStream)
+
+	// Nothing is read from outStream, so only 2 of the 4 OOMs the rate
+	// limit lets through can be buffered.
+	select {
+	case <-metrics.lines:
+	case <-time.After(1 * time.Second):
+		t.Fatal("timeout happened before the input was parsed")
+	}
+	stats := oomLog.Stats()
+	if stats.Parsed != 5 || stats.RateLimited != 1 || stats.Sent != 4 || stats.Dropped != 2 {
+		t.Errorf("expected 5 OOMs parsed, 1 rate limited and 2 of the 4 sent dropped, got %+v", stats)
+	}
+	if stats.UnrecognizedLines != 1 {
+		t.Errorf("expected the line after the OOMs to be unrecognized, got %+v", stats)
+	}
+	for range outStream {
+	}
+}
+
+func TestLastActivity(t *testing.T) {
+	reader, writer := io.Pipe()
+	defer writer.Close()
+	oomLog := NewFromReader(reader)
+	var clockLock sync.Mutex
+	clock := time.Date(2016, time.March, 14, 0, 0, 0, 0, time.UTC)
+	oomLog.nowFunc = func() time.Time {
+		clockLock.Lock()
+		defer clockLock.Unlock()
+		return clock
+	}
+	if lastActivity := oomLog.LastActivity(); !lastActivity.IsZero() {
+		t.Errorf("expected no activity before a line is read, got %v", lastActivity)
+	}
+	go oomLog.StreamOoms(make(chan *OomInstance))
+
+	for _, line := range []string{startLine, containerLine, "not a kernel message"} {
+		clockLock.Lock()
+		clock = clock.Add(time.Minute)
+		now := clock
+		clockLock.Unlock()
+		go io.WriteString(writer, line+"\n")
+		deadline := time.Now().Add(2 * time.Second)
+		for !oomLog.LastActivity().Equal(now) {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected the activity to advance to %v reading %q, got %v", now, line, oomLog.LastActivity())
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
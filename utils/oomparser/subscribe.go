@@ -0,0 +1,112 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oomparser
+
+import (
+	"golang.org/x/net/context"
+)
+
+// a channel returned by Subscribe, and the OOMs queued to be sent to it
+type subscriber struct {
+	queue *overflowQueue
+	// stops the OOMs still queued being sent
+	cancel context.CancelFunc
+}
+
+// Subscribe returns a channel that is sent every OOM read from now on, and a
+// func that unsubscribes from it. Any number of subscribers may be active at
+// once, each sent the same OomInstances, which they must not modify. The
+// first call starts streaming from the parser in the background, so Subscribe
+// cannot be used along with StreamOoms or its variants, or Next.
+//
+// Each subscriber has a buffer of OverflowBufferSize OOMs, so that a slow one
+// does not hold back the others. Once it is full, OOMs are dropped for that
+// subscriber as Overflow says, with OverflowBlock dropping the oldest as
+// OverflowDropOldest does. The channel is closed once the stream has ended
+// and the OOMs buffered for it have been received, see Err, or once it is
+// unsubscribed from, when what was still buffered is discarded.
+func (self *OomParser) Subscribe() (<-chan *OomInstance, func()) {
+	self.subscribeOnce.Do(func() {
+		self.subscribers = make(map[*subscriber]bool)
+		outStream := make(chan *OomInstance)
+		done := make(chan struct{})
+		go func() {
+			self.streamOoms(context.Background(), outStream, nil, nil, nil)
+			close(done)
+		}()
+		go self.fanOut(outStream, done)
+	})
+
+	policy := self.Overflow
+	if policy == OverflowBlock {
+		policy = OverflowDropOldest
+	}
+	queue := newOverflowQueue(policy, self.OverflowBufferSize, self.logger())
+	queue.dropped = &self.counters.dropped
+	ctx, cancel := context.WithCancel(context.Background())
+	subscription := &subscriber{queue: queue, cancel: cancel}
+	outStream := make(chan *OomInstance)
+	go func() {
+		queue.send(ctx, outStream)
+		close(outStream)
+	}()
+	self.subscribersLock.Lock()
+	if self.subscribersDone {
+		queue.close()
+	} else {
+		self.subscribers[subscription] = true
+	}
+	self.subscribersLock.Unlock()
+
+	unsubscribe := func() {
+		self.subscribersLock.Lock()
+		delete(self.subscribers, subscription)
+		self.subscribersLock.Unlock()
+		queue.close()
+		cancel()
+	}
+	return outStream, unsubscribe
+}
+
+// queues each OOM received from outStream for every subscriber, until the
+// stream sending them is done, when the subscribers are sent what is still
+// queued for them and their channels closed.
+func (self *OomParser) fanOut(outStream <-chan *OomInstance, done <-chan struct{}) {
+	for finished := false; !finished; {
+		select {
+		case oomInstance, ok := <-outStream:
+			if !ok {
+				// Closed as CloseStreamOnExit is set.
+				finished = true
+				break
+			}
+			self.subscribersLock.Lock()
+			for subscription := range self.subscribers {
+				subscription.queue.add(oomInstance)
+			}
+			self.subscribersLock.Unlock()
+		case <-done:
+			// Every OOM sent was received before the stream ended.
+			finished = true
+		}
+	}
+	self.subscribersLock.Lock()
+	defer self.subscribersLock.Unlock()
+	self.subscribersDone = true
+	for subscription := range self.subscribers {
+		subscription.queue.close()
+	}
+	self.subscribers = nil
+}
@@ -0,0 +1,109 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oomparser
+
+import (
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// receives the pids of the OOMs sent to outStream until it is closed.
+func receivePids(t *testing.T, outStream <-chan *OomInstance, when string) []int {
+	var pids []int
+	for {
+		select {
+		case oomInstance, ok := <-outStream:
+			if !ok {
+				return pids
+			}
+			pids = append(pids, oomInstance.Pid)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s: timeout happened before the channel was closed, received pids %v", when, pids)
+		}
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	reader, writer := io.Pipe()
+	oomLog := NewFromReader(reader)
+	oomLog.OverflowBufferSize = 2
+	fast, unsubscribeFast := oomLog.Subscribe()
+	defer unsubscribeFast()
+	slow, unsubscribeSlow := oomLog.Subscribe()
+	defer unsubscribeSlow()
+
+	// The slow subscriber does not receive anything until the fast one has
+	// received every OOM, and the stream has ended.
+	for pid := 1; pid <= 5; pid++ {
+		go io.WriteString(writer, startLine+"\n"+containerLine+"\n"+strings.Replace(endLine, "19667", strconv.Itoa(pid), 1)+"\n")
+		select {
+		case oomInstance := <-fast:
+			if oomInstance.Pid != pid {
+				t.Errorf("expected the fast subscriber to receive pid %d, got %d", pid, oomInstance.Pid)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timeout happened before the fast subscriber received pid %d", pid)
+		}
+	}
+	writer.Close()
+	if pids := receivePids(t, fast, "fast subscriber"); len(pids) != 0 {
+		t.Errorf("expected nothing more to be sent to the fast subscriber, got pids %v", pids)
+	}
+	if pids, expected := receivePids(t, slow, "slow subscriber"), []int{4, 5}; !reflect.DeepEqual(pids, expected) {
+		t.Errorf("expected the slow subscriber to receive the newest OOMs, of pids %v, got %v", expected, pids)
+	}
+	if dropped := oomLog.Stats().Dropped; dropped != 3 {
+		t.Errorf("expected 3 OOMs to be dropped for the slow subscriber, got %d", dropped)
+	}
+	if err := oomLog.Err(); err != io.EOF {
+		t.Errorf("expected the stream to end with %v, got %v", io.EOF, err)
+	}
+
+	// Subscribing once the stream has ended gives a closed channel.
+	late, unsubscribeLate := oomLog.Subscribe()
+	defer unsubscribeLate()
+	if pids := receivePids(t, late, "late subscriber"); len(pids) != 0 {
+		t.Errorf("expected nothing to be sent after the stream ended, got pids %v", pids)
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	reader, writer := io.Pipe()
+	defer writer.Close()
+	oomLog := NewFromReader(reader)
+	kept, unsubscribeKept := oomLog.Subscribe()
+	defer unsubscribeKept()
+	dropped, unsubscribe := oomLog.Subscribe()
+	unsubscribe()
+	// Unsubscribing again does nothing.
+	unsubscribe()
+	if pids := receivePids(t, dropped, "after unsubscribing"); len(pids) != 0 {
+		t.Errorf("expected nothing to be sent after unsubscribing, got pids %v", pids)
+	}
+
+	go io.WriteString(writer, startLine+"\n"+containerLine+"\n"+endLine+"\n")
+	select {
+	case oomInstance := <-kept:
+		if oomInstance.Pid != 19667 {
+			t.Errorf("expected pid 19667, got %d", oomInstance.Pid)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timeout happened before oomInstance was found")
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package testing provides a kernel log source for testing how an OomParser
+// streams from logs that are slow, fail or are cut off.
+package testing
+
+import (
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Step is what a Source does for one of its lines: it waits for Delay, then
+// returns Line, or fails with Err if it is set.
+type Step struct {
+	Delay time.Duration
+	Line  string
+	Err   error
+}
+
+// Lines returns the steps for lines that are ready to be read at once.
+func Lines(lines ...string) []Step {
+	steps := make([]Step, len(lines))
+	for i, line := range lines {
+		steps[i].Line = line
+	}
+	return steps
+}
+
+// Source is an io.Reader that plays its steps in order, then reports io.EOF.
+// Each line is returned on its own, with a newline added if it has none, so
+// that a parser reading it is fed one line at a time. A step's error is
+// returned once, and reading carries on with the next step, as for a source
+// that is reconnected to. Closing the Source ends any delay in progress, and
+// reads after that fail with os.ErrClosed.
+type Source struct {
+	lock   sync.Mutex
+	steps  []Step
+	unread string
+	closed chan struct{}
+	// whether closed has been closed, guarded by lock
+	isClosed bool
+}
+
+// NewSource returns a Source that plays steps.
+func NewSource(steps ...Step) *Source {
+	return &Source{
+		steps:  steps,
+		closed: make(chan struct{}),
+	}
+}
+
+func (self *Source) Read(p []byte) (int, error) {
+	self.lock.Lock()
+	if self.isClosed {
+		self.lock.Unlock()
+		return 0, os.ErrClosed
+	}
+	if self.unread == "" {
+		if len(self.steps) == 0 {
+			self.lock.Unlock()
+			return 0, io.EOF
+		}
+		step := self.steps[0]
+		self.steps = self.steps[1:]
+		self.lock.Unlock()
+		if step.Delay > 0 {
+			timer := time.NewTimer(step.Delay)
+			select {
+			case <-timer.C:
+			case <-self.closed:
+				timer.Stop()
+				return 0, os.ErrClosed
+			}
+		}
+		if step.Err != nil {
+			return 0, step.Err
+		}
+		self.lock.Lock()
+		if self.isClosed {
+			self.lock.Unlock()
+			return 0, os.ErrClosed
+		}
+		self.unread = step.Line
+		if !strings.HasSuffix(self.unread, "\n") {
+			self.unread += "\n"
+		}
+	}
+	n := copy(p, self.unread)
+	self.unread = self.unread[n:]
+	self.lock.Unlock()
+	return n, nil
+}
+
+// Remaining returns how many steps have not been started yet.
+func (self *Source) Remaining() int {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return len(self.steps)
+}
+
+func (self *Source) Close() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if !self.isClosed {
+		self.isClosed = true
+		close(self.closed)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oomparser
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// errReadTimeout is returned by a timeoutReader when a read takes too long.
+var errReadTimeout = fmt.Errorf("timed out reading the kernel log")
+
+// the optional interface of sources, such as pollable files, whose reads can
+// be given a deadline
+type readDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+// timeoutReader fails reads from reader that take longer than timeout with
+// errReadTimeout. If the source reader reads from supports read deadlines,
+// they are used. Otherwise each read runs in a watchdog goroutine; one that
+// times out is left running, and the next read waits for it rather than
+// starting another, so data is never read out of order.
+type timeoutReader struct {
+	reader    io.Reader
+	deadliner readDeadliner
+	timeout   time.Duration
+	// the result of the read left running by a timeout, if any
+	pending chan readResult
+	// what the last watchdog read returned that did not fit in p yet
+	unread    []byte
+	unreadErr error
+}
+
+type readResult struct {
+	data []byte
+	err  error
+}
+
+func newTimeoutReader(reader io.Reader, source io.Reader, timeout time.Duration) *timeoutReader {
+	self := &timeoutReader{reader: reader, timeout: timeout}
+	if deadliner, ok := source.(readDeadliner); ok {
+		self.deadliner = deadliner
+	}
+	return self
+}
+
+func (self *timeoutReader) Read(p []byte) (int, error) {
+	if self.deadliner != nil {
+		if err := self.deadliner.SetReadDeadline(time.Now().Add(self.timeout)); err == nil {
+			n, err := self.reader.Read(p)
+			if isTimeout(err) {
+				err = errReadTimeout
+			}
+			return n, err
+		}
+		// e.g. os.ErrNoDeadline from a file that cannot be polled.
+		self.deadliner = nil
+	}
+	if len(self.unread) > 0 {
+		return self.readUnread(p)
+	}
+	if self.pending == nil {
+		// Read into a buffer of our own, so that the caller's is not
+		// written to after a timeout.
+		pending := make(chan readResult, 1)
+		go func(data []byte) {
+			n, err := self.reader.Read(data)
+			pending <- readResult{data[:n], err}
+		}(make([]byte, len(p)))
+		self.pending = pending
+	}
+	timer := time.NewTimer(self.timeout)
+	defer timer.Stop()
+	select {
+	case result := <-self.pending:
+		self.pending = nil
+		self.unread, self.unreadErr = result.data, result.err
+		return self.readUnread(p)
+	case <-timer.C:
+		return 0, errReadTimeout
+	}
+}
+
+func (self *timeoutReader) readUnread(p []byte) (int, error) {
+	n := copy(p, self.unread)
+	self.unread = self.unread[n:]
+	if len(self.unread) > 0 {
+		return n, nil
+	}
+	err := self.unreadErr
+	self.unreadErr = nil
+	return n, err
+}
+
+// reports whether err is a read deadline being exceeded.
+func isTimeout(err error) bool {
+	timeout, ok := err.(interface {
+		Timeout() bool
+	})
+	return ok && timeout.Timeout()
+}
@@ -0,0 +1,132 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oomparser
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// blocks reads until it is closed
+type blockingReader struct {
+	closed chan struct{}
+}
+
+func (self *blockingReader) Read(p []byte) (int, error) {
+	<-self.closed
+	return 0, os.ErrClosed
+}
+
+func (self *blockingReader) Close() error {
+	close(self.closed)
+	return nil
+}
+
+// returns its data only after a delay
+type slowReader struct {
+	data  string
+	delay time.Duration
+}
+
+func (self *slowReader) Read(p []byte) (int, error) {
+	time.Sleep(self.delay)
+	if self.data == "" {
+		return 0, io.EOF
+	}
+	n := copy(p, self.data)
+	self.data = self.data[n:]
+	return n, nil
+}
+
+func TestTimeoutReader(t *testing.T) {
+	reader := newTimeoutReader(&slowReader{data: "hello world", delay: 50 * time.Millisecond}, nil, 10*time.Millisecond)
+	p := make([]byte, 64)
+	if _, err := reader.Read(p); err != errReadTimeout {
+		t.Fatalf("expected a slow read to time out, got %v", err)
+	}
+	// The read left running gets everything, which is then returned in
+	// pieces as p is smaller now.
+	var read string
+	for len(read) < len("hello world") {
+		n, err := reader.Read(p[:4])
+		if err == errReadTimeout {
+			continue
+		}
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		read += string(p[:n])
+	}
+	if read != "hello world" {
+		t.Errorf("expected to read %q after the timeout, got %q", "hello world", read)
+	}
+}
+
+func TestStreamOomsReadTimeout(t *testing.T) {
+	dump := startLine + "\n" + containerLine + "\n" + endLine + "\n"
+	blocking := &blockingReader{closed: make(chan struct{})}
+	defer blocking.Close()
+	oomLog := NewFromReader(io.MultiReader(strings.NewReader(dump), blocking))
+	oomLog.ReadTimeout = 20 * time.Millisecond
+	outStream := make(chan *OomInstance, 10)
+	if err := oomLog.StreamOomsContext(context.Background(), outStream); err != errReadTimeout {
+		t.Errorf("expected the stream to end with %v, got %v", errReadTimeout, err)
+	}
+	if len(outStream) != 1 {
+		t.Errorf("expected the OOM read before the timeout, got %d", len(outStream))
+	}
+
+	// Sources that can be reopened are.
+	oomLog = NewFromReader(io.MultiReader(strings.NewReader(dump), blocking))
+	oomLog.ReadTimeout = 20 * time.Millisecond
+	oomLog.MaxReconnectBackoff = time.Millisecond
+	oomLog.reopen = func() (*OomParser, error) {
+		return NewFromReader(strings.NewReader(dump)), nil
+	}
+	outStream = make(chan *OomInstance, 10)
+	if err := oomLog.StreamOomsContext(context.Background(), outStream); err != io.EOF {
+		t.Errorf("expected the reopened source to end with %v, got %v", io.EOF, err)
+	}
+	if len(outStream) != 2 {
+		t.Errorf("expected an OOM before and after reopening, got %d", len(outStream))
+	}
+}
+
+func TestStreamOomsReadDeadline(t *testing.T) {
+	// Pipes can be polled, so support read deadlines.
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("failed to create pipe: %v", err)
+	}
+	defer writer.Close()
+	if _, err := writer.WriteString(startLine + "\n" + containerLine + "\n" + endLine + "\n"); err != nil {
+		t.Fatalf("failed to write to pipe: %v", err)
+	}
+	oomLog := NewFromReader(reader)
+	defer oomLog.Close()
+	oomLog.ReadTimeout = 20 * time.Millisecond
+	outStream := make(chan *OomInstance, 10)
+	if err := oomLog.StreamOomsContext(context.Background(), outStream); err != errReadTimeout {
+		t.Errorf("expected the stream to end with %v, got %v", errReadTimeout, err)
+	}
+	if len(outStream) != 1 {
+		t.Errorf("expected the OOM read before the timeout, got %d", len(outStream))
+	}
+}